@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/depressi0n/myLua/asm"
+	"github.com/depressi0n/myLua/binchunk"
+	"github.com/depressi0n/myLua/peephole"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+var asmCommand = &command{
+	name:  "asm",
+	usage: "asm [-o out.luac] [-s] [-O] [-noverify] file.lasm",
+	run:   runAsm,
+}
+
+// runAsm 汇编文本形式的汇编代码，输出Lua5.4的二进制chunk
+func runAsm(args []string) error {
+	flags := flag.NewFlagSet("asm", flag.ExitOnError)
+	output := flags.String("o", "", "output file (default: input file with .luac extension)")
+	strip := flags.Bool("s", false, "strip debug information")
+	optimize := flags.Bool("O", false, "run the peephole optimizer (implies verification)")
+	noVerify := flags.Bool("noverify", false, "skip the bytecode verifier, e.g. for intentionally invalid chunks")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("need exactly one assembly file")
+	}
+
+	filename := flags.Arg(0)
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	proto, err := asm.Assemble(src, filename)
+	if err != nil {
+		return err
+	}
+	if *optimize {
+		if err := peephole.Optimize(proto); err != nil {
+			return err
+		}
+	} else if !*noVerify {
+		if err := binchunk.Verify(proto); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	if err := binchunk.Dump(&buf, proto, *strip); err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".luac"
+	}
+	return ioutil.WriteFile(*output, buf.Bytes(), 0644)
+}
@@ -0,0 +1,465 @@
+// Package asm 把文本形式的汇编代码汇编为Lua5.4的函数原型，
+// 用于手写虚拟机的回归测试，尤其是编译器很少生成的指令
+//
+// 汇编代码按行处理，";"之后是注释，操作数之间用空白或逗号分隔。
+// 整个文件是主函数，".function [name]"和".end"之间是子函数，可以嵌套，
+// 子函数按照出现的顺序编号。函数内可以使用下列伪指令：
+//
+//	.source "@file.lua"       源文件名，子函数默认与外围函数相同
+//	.linedefined N            函数定义的起始行号
+//	.lastlinedefined N        函数定义的结束行号
+//	.params N                 固定参数个数
+//	.vararg                   vararg函数
+//	.stack N                  寄存器数量，默认为2
+//	.const VALUE              按顺序添加常量：nil，true，false，
+//	                          Lua格式的数字（可以带负号，以及inf，-inf和nan）或者Go格式的字符串
+//	.upval NAME INSTACK IDX [KIND]
+//	                          按顺序添加upvalue
+//	.local NAME START END     添加局部变量，START和END是指令位置或者标号
+//	.line N                   之后的指令对应源代码第N行
+//
+// 指令由vm包中的操作码名称和操作数组成，格式与 vm.Instruction 的String方法相同：
+// iABC模式的操作数依次为A、B、C，C之后加上"k"表示k标志为1，如"SETFIELD 0 1 2k"，
+// ADDI、EQI等指令的有符号操作数sB和sC直接写成整数，如"ADDI 0 0 -1"，
+// 其他模式依次为A、Bx或sBx，以及Ax或sJ，缺省的操作数为0。
+// "name:"定义标号，JMP，FORPREP，FORLOOP，TFORPREP和TFORLOOP的跳转操作数可以使用标号，
+// 其中FORPREP的标号表示循环结束后的位置；CLOSURE的操作数可以使用子函数名称
+package asm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/depressi0n/myLua/binchunk"
+	"github.com/depressi0n/myLua/number"
+	"github.com/depressi0n/myLua/vm"
+)
+
+// defaultStackSize 与Lua编译器一样，每个函数至少有两个寄存器
+const defaultStackSize = 2
+
+type function struct {
+	proto      *binchunk.Prototype
+	labels     map[string]int // 标号对应的指令位置
+	protoNames map[string]int // 子函数名称对应的编号
+	subs       []*function
+	instrs     []instr
+	locals     []local
+	lines      []int // 每条指令对应的行号
+	line       int   // 当前行号，由.line设置
+	hasLines   bool
+	hasSource  bool
+}
+
+// instr 记录一条指令，所有标号都定义之后再编码
+type instr struct {
+	op      int
+	args    []string
+	srcLine int
+}
+
+type local struct {
+	name       string
+	start, end string
+	srcLine    int
+}
+
+type assembler struct {
+	chunkName string
+	srcLine   int
+	funcs     []*function // 正在汇编的函数，最后一个是当前函数
+}
+
+// asmError 包装汇编过程中发现的错误，由Assemble转换为返回值
+type asmError struct{ err error }
+
+// Assemble 汇编文本形式的汇编代码，返回主函数原型，chunkName用于错误信息
+func Assemble(src []byte, chunkName string) (proto *binchunk.Prototype, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(asmError)
+			if !ok {
+				panic(r)
+			}
+			proto, err = nil, e.err
+		}
+	}()
+	a := &assembler{chunkName: chunkName}
+	a.funcs = []*function{newFunction()}
+	for i, line := range strings.Split(string(src), "\n") {
+		a.srcLine = i + 1
+		a.assembleLine(line)
+	}
+	if len(a.funcs) > 1 {
+		a.errorf("missing .end")
+	}
+	return a.finish(a.funcs[0], ""), nil
+}
+
+func newFunction() *function {
+	return &function{
+		proto: &binchunk.Prototype{
+			Version:      binchunk.LUAC_VERSION,
+			MaxStackSize: defaultStackSize,
+		},
+		labels:     map[string]int{},
+		protoNames: map[string]int{},
+	}
+}
+
+func (a *assembler) errorf(format string, args ...interface{}) {
+	panic(asmError{fmt.Errorf("%s:%d: %s", a.chunkName, a.srcLine, fmt.Sprintf(format, args...))})
+}
+
+func (a *assembler) current() *function {
+	return a.funcs[len(a.funcs)-1]
+}
+
+// fields 把一行切分为记号，双引号括起的字符串是一个记号
+func (a *assembler) fields(line string) []string {
+	var tokens []string
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ';':
+			return tokens
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(line) && line[j] != '"' {
+				if line[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(line) {
+				a.errorf("unfinished string")
+			}
+			tokens = append(tokens, line[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for j < len(line) && !strings.ContainsRune(" \t\r,;\"", rune(line[j])) {
+				j++
+			}
+			tokens = append(tokens, line[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+func (a *assembler) assembleLine(line string) {
+	tokens := a.fields(line)
+	f := a.current()
+	for len(tokens) > 0 && strings.HasSuffix(tokens[0], ":") {
+		label := strings.TrimSuffix(tokens[0], ":")
+		if !isName(label) {
+			a.errorf("invalid label %q", label)
+		}
+		if _, ok := f.labels[label]; ok {
+			a.errorf("duplicate label %q", label)
+		}
+		f.labels[label] = len(f.instrs)
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 {
+		return
+	}
+	if strings.HasPrefix(tokens[0], ".") {
+		a.directive(tokens[0], tokens[1:])
+		return
+	}
+	op, ok := vm.OpcodeByName(strings.ToUpper(tokens[0]))
+	if !ok {
+		a.errorf("unknown instruction %q", tokens[0])
+	}
+	f.instrs = append(f.instrs, instr{op: op, args: tokens[1:], srcLine: a.srcLine})
+	f.lines = append(f.lines, f.line)
+}
+
+func isName(s string) bool {
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		return false
+	}
+	for _, c := range s {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *assembler) directive(name string, args []string) {
+	f := a.current()
+	p := f.proto
+	nargs := func(min, max int) {
+		if len(args) < min || len(args) > max {
+			a.errorf("%s: wrong number of arguments", name)
+		}
+	}
+	switch name {
+	case ".function":
+		nargs(0, 1)
+		sub := newFunction()
+		if len(args) == 1 {
+			if !isName(args[0]) {
+				a.errorf("invalid function name %q", args[0])
+			}
+			if _, ok := f.protoNames[args[0]]; ok {
+				a.errorf("duplicate function %q", args[0])
+			}
+			f.protoNames[args[0]] = len(p.Protos)
+		}
+		p.Protos = append(p.Protos, sub.proto)
+		f.subs = append(f.subs, sub)
+		a.funcs = append(a.funcs, sub)
+	case ".end":
+		nargs(0, 0)
+		if len(a.funcs) == 1 {
+			a.errorf(".end outside function")
+		}
+		a.funcs = a.funcs[:len(a.funcs)-1]
+	case ".source":
+		nargs(1, 1)
+		p.Source = a.quoted(args[0])
+		f.hasSource = true
+	case ".linedefined":
+		nargs(1, 1)
+		p.LineDefined = a.integer(args[0], 0, math.MaxInt32)
+		if !f.hasLines {
+			f.line = p.LineDefined
+		}
+	case ".lastlinedefined":
+		nargs(1, 1)
+		p.LastLineDefined = a.integer(args[0], 0, math.MaxInt32)
+	case ".params":
+		nargs(1, 1)
+		p.NumParams = byte(a.integer(args[0], 0, math.MaxUint8))
+	case ".vararg":
+		nargs(0, 0)
+		p.IsVararg = 1
+	case ".stack":
+		nargs(1, 1)
+		p.MaxStackSize = byte(a.integer(args[0], 0, math.MaxUint8))
+	case ".const":
+		nargs(1, 1)
+		p.Constants = append(p.Constants, a.constant(args[0]))
+	case ".upval":
+		nargs(3, 4)
+		u := binchunk.Upvalue{
+			Instack: byte(a.integer(args[1], 0, 1)),
+			Idx:     byte(a.integer(args[2], 0, math.MaxUint8)),
+		}
+		if len(args) == 4 {
+			u.Kind = byte(a.integer(args[3], 0, math.MaxUint8))
+		}
+		p.Upvalues = append(p.Upvalues, u)
+		p.UpvalueNames = append(p.UpvalueNames, args[0])
+	case ".local":
+		nargs(3, 3)
+		f.locals = append(f.locals, local{name: args[0], start: args[1], end: args[2], srcLine: a.srcLine})
+	case ".line":
+		nargs(1, 1)
+		f.line = a.integer(args[0], 0, math.MaxInt32)
+		f.hasLines = true
+	default:
+		a.errorf("unknown directive %s", name)
+	}
+}
+
+func (a *assembler) integer(s string, min, max int) int {
+	x, err := strconv.Atoi(s)
+	if err != nil {
+		a.errorf("invalid integer %q", s)
+	}
+	if x < min || x > max {
+		a.errorf("%d out of range [%d, %d]", x, min, max)
+	}
+	return x
+}
+
+func (a *assembler) quoted(s string) string {
+	str, err := strconv.Unquote(s)
+	if err != nil || !strings.HasPrefix(s, "\"") {
+		a.errorf("invalid string %s", s)
+	}
+	return str
+}
+
+func (a *assembler) constant(s string) interface{} {
+	switch s {
+	case "nil":
+		return nil
+	case "true":
+		return true
+	case "false":
+		return false
+	case "inf":
+		return math.Inf(1)
+	case "-inf":
+		return math.Inf(-1)
+	case "nan":
+		return math.NaN()
+	}
+	if strings.HasPrefix(s, "\"") {
+		return a.quoted(s)
+	}
+	numeral := strings.TrimPrefix(s, "-")
+	n, ok := number.ParseNumber(numeral)
+	if !ok {
+		a.errorf("invalid constant %s", s)
+	}
+	if numeral == s {
+		return n
+	}
+	switch x := n.(type) {
+	case int64:
+		return -x
+	default:
+		return -x.(float64)
+	}
+}
+
+// finish 在所有函数都读取完之后编码指令，生成行号信息和局部变量表，
+// 没有.source的子函数使用外围函数的源文件名
+func (a *assembler) finish(f *function, parentSource string) *binchunk.Prototype {
+	p := f.proto
+	if !f.hasSource {
+		p.Source = parentSource
+	}
+	p.Code = make([]uint32, len(f.instrs))
+	for pc, in := range f.instrs {
+		a.srcLine = in.srcLine
+		p.Code[pc] = a.encode(f, pc, in)
+	}
+	if f.hasLines {
+		p.SetLines(f.lines)
+	}
+	for _, l := range f.locals {
+		a.srcLine = l.srcLine
+		p.LocVars = append(p.LocVars, binchunk.LocVar{
+			VarName: l.name,
+			StartPC: a.position(f, l.start),
+			EndPC:   a.position(f, l.end),
+		})
+	}
+	for _, sub := range f.subs {
+		a.finish(sub, p.Source)
+	}
+	return p
+}
+
+// position 解析局部变量的起止位置，可以是指令位置或者标号
+func (a *assembler) position(f *function, s string) int {
+	if pc, ok := f.labels[s]; ok {
+		return pc
+	}
+	return a.integer(s, 0, len(f.instrs))
+}
+
+// operand 解析操作数，标号和子函数名称由resolve转换为数值
+func (a *assembler) operand(s string, min, max int, resolve func(name string) (int, bool)) int {
+	if isName(s) {
+		if resolve == nil {
+			a.errorf("unexpected name %q", s)
+		}
+		x, ok := resolve(s)
+		if !ok {
+			a.errorf("undefined name %q", s)
+		}
+		if x < min || x > max {
+			a.errorf("%s: offset %d out of range [%d, %d]", s, x, min, max)
+		}
+		return x
+	}
+	return a.integer(s, min, max)
+}
+
+// argC 解析iABC模式的B或C操作数，有符号的sB和sC写成整数，范围是[-OFFSET_sC, MAXARG_C-OFFSET_sC]
+func (a *assembler) argC(s string, max int, signed bool) int {
+	if !signed {
+		return a.operand(s, 0, max, nil)
+	}
+	x, err := vm.Int2sC(a.operand(s, -vm.OFFSET_sC, vm.MAXARG_C-vm.OFFSET_sC, nil))
+	if err != nil {
+		a.errorf("%v", err)
+	}
+	return x
+}
+
+func (a *assembler) encode(f *function, pc int, in instr) uint32 {
+	i := vm.Instruction(in.op)
+	args := in.args
+	arg := func(n int) string {
+		if n < len(args) {
+			return args[n]
+		}
+		return "0"
+	}
+	label := func(offset func(target int) int) func(string) (int, bool) {
+		return func(name string) (int, bool) {
+			target, ok := f.labels[name]
+			return offset(target), ok
+		}
+	}
+
+	var code vm.Instruction
+	var err error
+	switch i.OpMode() {
+	case vm.OpModeABC:
+		if len(args) > 3 {
+			a.errorf("%s: too many operands", i.OpName())
+		}
+		c, k := arg(2), 0
+		if strings.HasSuffix(c, "k") {
+			c, k = strings.TrimSuffix(c, "k"), 1
+		}
+		info := i.Info()
+		code, err = vm.CreateABCk(in.op, a.operand(arg(0), 0, vm.MAXARG_A, nil),
+			a.argC(arg(1), vm.MAXARG_B, info.SignedB), a.argC(c, vm.MAXARG_C, info.SignedC), k)
+	case vm.OpModeABx:
+		if len(args) > 2 {
+			a.errorf("%s: too many operands", i.OpName())
+		}
+		var resolve func(string) (int, bool)
+		switch in.op {
+		case vm.OP_FORLOOP, vm.OP_TFORLOOP:
+			resolve = label(func(target int) int { return pc + 1 - target })
+		case vm.OP_FORPREP:
+			resolve = label(func(target int) int { return target - pc - 2 })
+		case vm.OP_TFORPREP:
+			resolve = label(func(target int) int { return target - pc - 1 })
+		case vm.OP_CLOSURE:
+			resolve = func(name string) (int, bool) {
+				idx, ok := f.protoNames[name]
+				return idx, ok
+			}
+		}
+		code, err = vm.CreateABx(in.op, a.operand(arg(0), 0, vm.MAXARG_A, nil),
+			a.operand(arg(1), 0, vm.MAXARG_Bx, resolve))
+	case vm.OpModeAsBx:
+		if len(args) > 2 {
+			a.errorf("%s: too many operands", i.OpName())
+		}
+		sbx := a.operand(arg(1), -vm.OFFSET_sBx, vm.MAXARG_Bx-vm.OFFSET_sBx, nil)
+		code, err = vm.CreateAsBx(in.op, a.operand(arg(0), 0, vm.MAXARG_A, nil), sbx)
+	case vm.OpModeAx:
+		if len(args) > 1 {
+			a.errorf("%s: too many operands", i.OpName())
+		}
+		code, err = vm.CreateAx(in.op, a.operand(arg(0), 0, vm.MAXARG_Ax, nil))
+	case vm.OpModesJ:
+		if len(args) > 1 {
+			a.errorf("%s: too many operands", i.OpName())
+		}
+		code, err = vm.CreatesJ(in.op, a.operand(arg(0), -vm.OFFSET_sJ, vm.MAXARG_sJ-vm.OFFSET_sJ,
+			label(func(target int) int { return target - pc - 1 })))
+	}
+	if err != nil {
+		a.errorf("%v", err)
+	}
+	return uint32(code)
+}
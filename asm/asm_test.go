@@ -0,0 +1,168 @@
+package asm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/depressi0n/myLua/binchunk"
+	"github.com/depressi0n/myLua/vm"
+)
+
+func listing(p *binchunk.Prototype) []string {
+	var code []string
+	for _, c := range p.Code {
+		code = append(code, vm.Instruction(c).String())
+	}
+	return code
+}
+
+func TestAssemble(t *testing.T) {
+	src, err := ioutil.ReadFile("testdata/forloop.lasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Assemble(src, "forloop.lasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := binchunk.Verify(p); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"VARARGPREP 0 0 0",
+		"NEWTABLE 0 0 0",
+		"EXTRAARG 0",
+		"LOADI 1 1",
+		"LOADI 2 3",
+		"LOADI 3 1",
+		"FORPREP 1 2",
+		"CLOSURE 5 0",
+		"SETTABLE 0 4 5",
+		"FORLOOP 1 3",
+		"RETURN 1 1 1",
+	}
+	if got := listing(p); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if p.MaxStackSize != 6 || p.IsVararg != 1 || !reflect.DeepEqual(p.Constants, []interface{}{int64(1)}) {
+		t.Errorf("unexpected header %+v", p)
+	}
+	wantLocals := []binchunk.LocVar{{VarName: "t", StartPC: 1, EndPC: 10}, {VarName: "i", StartPC: 7, EndPC: 7}}
+	if !reflect.DeepEqual(p.LocVars, wantLocals) {
+		t.Errorf("got locals %v, want %v", p.LocVars, wantLocals)
+	}
+	if line := p.LineForPC(9); line != 1 {
+		t.Errorf("LineForPC(9) = %d, want 1", line)
+	}
+	if line := p.LineForPC(10); line != 2 {
+		t.Errorf("LineForPC(10) = %d, want 2", line)
+	}
+
+	sub := p.Protos[0]
+	if sub.Source != "@forloop.lua" || sub.LineDefined != 1 || !reflect.DeepEqual(sub.UpvalueNames, []string{"i"}) {
+		t.Errorf("unexpected sub function %+v", sub)
+	}
+	if got := listing(sub); !reflect.DeepEqual(got, []string{"GETUPVAL 0 0 0", "RETURN1 0 0 0", "RETURN0 0 0 0"}) {
+		t.Errorf("sub function code %q", got)
+	}
+
+	var buf bytes.Buffer
+	if err := binchunk.Dump(&buf, p, false); err != nil {
+		t.Fatal(err)
+	}
+	if diffs := binchunk.Diff(p, binchunk.Undump(&buf), false); len(diffs) > 0 {
+		t.Errorf("dump differences: %v", diffs)
+	}
+}
+
+func TestAssembleOperands(t *testing.T) {
+	src := `
+.const -3
+.const 0x10
+.const -2.5e1
+.const "a\tb"
+.const nil
+.const true
+.const -inf
+back:
+	SETFIELD 0 1 2k
+	LOADF 0 -7
+	JMP back
+	JMP end
+	tforprep 0 call
+call:
+	TFORCALL 0 0 1
+	TFORLOOP 0 call
+	LOADKX 0
+	EXTRAARG 3
+	ADDI 0 0 -1
+	EQI 0 128 1k
+	MMBINI 0 -127 6
+end:
+	RETURN0
+`
+	p, err := Assemble([]byte(src), "operands")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"SETFIELD 0 1 2k",
+		"LOADF 0 -7",
+		"JMP -3",
+		"JMP 8",
+		"TFORPREP 0 0",
+		"TFORCALL 0 0 1",
+		"TFORLOOP 0 2",
+		"LOADKX 0 0",
+		"EXTRAARG 3",
+		"ADDI 0 0 -1",
+		"EQI 0 128 1k",
+		"MMBINI 0 -127 6",
+		"RETURN0 0 0 0",
+	}
+	if got := listing(p); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	wantConstants := []interface{}{int64(-3), int64(16), -25.0, "a\tb", nil, true, math.Inf(-1)}
+	if !reflect.DeepEqual(p.Constants, wantConstants) {
+		t.Errorf("got constants %v, want %v", p.Constants, wantConstants)
+	}
+	if len(p.LineInfo) != 0 {
+		t.Errorf("unexpected line info %v", p.LineInfo)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"NOPE 1", `x.lasm:1: unknown instruction "NOPE"`},
+		{"\nMOVE 256 0", "x.lasm:2: 256 out of range [0, 255]"},
+		{"MOVE 1 2 3 4", "x.lasm:1: MOVE: too many operands"},
+		{"JMP nowhere", `x.lasm:1: undefined name "nowhere"`},
+		{"MOVE a 0", `x.lasm:1: unexpected name "a"`},
+		{"FORLOOP 0 later\nRETURN0\nlater:", "x.lasm:1: later: offset -1 out of range [0, 131071]"},
+		{"CLOSURE 0 f", `x.lasm:1: undefined name "f"`},
+		{"l:\nl:", `x.lasm:2: duplicate label "l"`},
+		{".const 1x", "x.lasm:1: invalid constant 1x"},
+		{`.source "abc`, "x.lasm:1: unfinished string"},
+		{".function f", "x.lasm:1: missing .end"},
+		{".end", "x.lasm:1: .end outside function"},
+		{".stack", "x.lasm:1: .stack: wrong number of arguments"},
+		{".bogus", "x.lasm:1: unknown directive .bogus"},
+		{".local x 0 9", "x.lasm:1: 9 out of range [0, 0]"},
+		{"ADDI 0 0 129", "x.lasm:1: 129 out of range [-127, 128]"},
+		{"LTI 0 -128 0", "x.lasm:1: -128 out of range [-127, 128]"},
+	}
+	for _, test := range tests {
+		_, err := Assemble([]byte(test.src), "x.lasm")
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q: got %v, want %q", test.src, err, test.want)
+		}
+	}
+}
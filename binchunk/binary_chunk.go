@@ -1,7 +1,10 @@
 package binchunk
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"runtime"
 )
 
 type binarychunk struct {
@@ -40,6 +43,7 @@ type LocVar struct {
 // Prototype 定义函数原型，包括函数基本信息，指令集，常量表，
 // upvalue表，子函数原型以及调试信息
 type Prototype struct {
+	Version byte // 二进制chunk的版本号，如0x54表示Lua5.4
 	// 基本信息
 	Source          string // 源文件名
 	LineDefined     int
@@ -52,10 +56,11 @@ type Prototype struct {
 	// 常量表，存放字面量包括nil，布尔值，整数，浮点数，字符串
 	// 每个常量都有一个tag，占1个字节
 	// 0x00 -> nil 不存储
-	// 0x01 -> boolean 字节0/1
-	// 0x03 -> number
+	// 0x01 -> false
+	// 0x11 -> true
+	// 0x03 -> integer（Lua5.3中为number）
 	// 0x04 -> 短字符串
-	// 0x13 -> integer
+	// 0x13 -> number（Lua5.3中为integer）
 	// 0x14 -> 长字符串
 	Constants []interface{}
 	// upvalue表
@@ -65,13 +70,20 @@ type Prototype struct {
 	// 调试信息
 	LineInfo     []byte        // 增量行号表
 	AbsLineInfo  []AbsLineInfo // 绝对行号表
+	Lines        []int         // Lua5.1~5.3中每条指令对应的行号，Lua5.4使用LineInfo和AbsLineInfo
 	LocVars      []LocVar      // 局部变量表
 	UpvalueNames []string      // _ENV
 }
 
+// Undump 解析二进制chunk，返回主函数原型
+// 可以解析Lua5.1~5.4的二进制chunk，函数原型的Version记录了chunk的版本号
 func Undump(reader io.Reader) *Prototype {
 	r := NewLuaReader(reader)
 	r.checkHeader()
+	// Lua5.1和Lua5.2的头部之后没有主函数的upvalue数量
+	if r.version <= LUAC_VERSION_52 {
+		return r.loadProto("")
+	}
 	nupvals := r.loadByte()
 	proto := r.loadProto("")
 	if int(nupvals) != len(proto.Upvalues) {
@@ -79,3 +91,38 @@ func Undump(reader io.Reader) *Prototype {
 	}
 	return proto
 }
+
+// TryUndump 与 Undump 相同，但格式错误和截断的chunk以错误的形式返回，
+// 用于解析来源不可信的二进制chunk
+func TryUndump(reader io.Reader) (proto *Prototype, err error) {
+	defer recoverError(&err)
+	return Undump(reader), nil
+}
+
+// recoverError 把解析过程中的panic转换为错误，
+// 运行时错误说明解析代码本身有缺陷，不做转换
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		if _, ok := r.(runtime.Error); ok {
+			panic(r)
+		}
+		if e, ok := r.(error); ok {
+			*err = fmt.Errorf("binchunk: %w", e)
+		} else {
+			*err = fmt.Errorf("binchunk: %v", r)
+		}
+	}
+}
+
+// ErrUnsupportedVersion 表示函数原型来自无法执行的Lua版本
+var ErrUnsupportedVersion = errors.New("unsupported binary chunk version")
+
+// CheckExecutable 检查函数原型能否被执行，只有Lua5.4的函数原型可以执行
+// 其他版本的指令集和Lua5.4不兼容，返回包装了 ErrUnsupportedVersion 的错误
+func (p *Prototype) CheckExecutable() error {
+	if p.Version != LUAC_VERSION {
+		return fmt.Errorf("%w: Lua %d.%d chunk %s cannot be executed, only Lua 5.4 is supported",
+			ErrUnsupportedVersion, p.Version>>4, p.Version&0xF, p.Source)
+	}
+	return nil
+}
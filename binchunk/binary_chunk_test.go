@@ -14,6 +14,9 @@ func TestUndump(t *testing.T) {
 		panic(err)
 	}
 	proto := Undump(file)
+	if err := proto.CheckExecutable(); err != nil {
+		t.Fatal(err)
+	}
 	list(proto)
 }
 
@@ -68,8 +71,8 @@ func constantToString(constant interface{}) string {
 func printCode(fc *Prototype) {
 	for pc, c := range fc.Code {
 		line := "-"
-		if len(fc.LineInfo) > 0 {
-			line = fmt.Sprintf("%d", fc.LineInfo[pc])
+		if l := fc.LineForPC(pc); l >= 0 {
+			line = fmt.Sprintf("%d", l)
 		}
 		i := vm.Instruction(c)
 		fmt.Printf("\t%d\t[%s]\t%s \t", pc+1, line, i.OpName())
@@ -3,7 +3,7 @@ package binchunk
 // Lua5.4中头部检查的相关标识
 const (
 	LUA_SIGNATURE    = "\x1bLua"
-	LUAC_VERSION     = 0x54
+	LUAC_VERSION     = LUAC_VERSION_54
 	LUAC_FORMAT      = 0 /* this is the official format */
 	LUAC_DATA        = "\x19\x93\r\n\x1a\n"
 	INSTRUCTION_SIZE = 4
@@ -18,12 +18,41 @@ const (
 	TAG_BOOLEAN   = 0x01
 	TAG_FALSE     = TAG_BOOLEAN
 	TAG_TRUE      = TAG_BOOLEAN | 0x10
-	TAG_NUMBER    = 0x03
-	TAG_INTERER   = 0x13
+	TAG_NUMINT    = 0x03 // Lua5.4中整数和浮点数的标记与Lua5.3相反
+	TAG_NUMFLT    = 0x13
 	TAG_SHORT_STR = 0x04
 	TAG_LONG_STR  = 0x14
 )
 
+// Lua5.3中数字常量的标记
+const (
+	TAG_NUMBER  = 0x03
+	TAG_INTERER = 0x13
+)
+
 const (
 	LUAI_MAXSHORTLEN = 40
 )
+
+// 可以识别的二进制chunk版本号，只有Lua5.4的chunk可以执行
+const (
+	LUAC_VERSION_51 = 0x51
+	LUAC_VERSION_52 = 0x52
+	LUAC_VERSION_53 = 0x53
+	LUAC_VERSION_54 = 0x54
+)
+
+// Lua5.1和Lua5.2中头部记录的字节序，1表示小端
+const (
+	LUAC_ENDIANNESS_BIG    = 0
+	LUAC_ENDIANNESS_LITTLE = 1
+)
+
+// Lua5.1和Lua5.2中常量的类型标记。Lua5.3的数字常量见 TAG_NUMBER 和 TAG_INTERER：
+// 浮点数是0x03，整数是0x13，与Lua5.4的 TAG_NUMINT 和 TAG_NUMFLT 相反
+const (
+	TAG_NIL_51     = 0x00
+	TAG_BOOLEAN_51 = 0x01
+	TAG_NUMBER_51  = 0x03
+	TAG_STRING_51  = 0x04
+)
@@ -0,0 +1,233 @@
+package binchunk
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/depressi0n/myLua/vm"
+)
+
+// Difference 描述两个函数原型之间的一处差异
+type Difference struct {
+	// 函数原型在原型树中的路径，如"main"和"main/2/1"，子函数从1开始编号，
+	// 对齐的子函数在两棵树中的位置不同时写作"main/2->3"
+	Func  string
+	Field string // 发生差异的部分，如"code"和"constants"
+	Text  string // 差异的具体内容，"-"开头表示只在a中出现，"+"开头表示只在b中出现
+}
+
+func (d Difference) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Func, d.Field, d.Text)
+}
+
+// Diff 对齐两棵函数原型树并比较对应的函数原型，返回所有差异
+// 子函数原型和指令表都按照最长公共子序列对齐，
+// ignoreDebug为true时忽略luac -s会去掉的调试信息：
+// 源文件名，行号信息，局部变量表和upvalue名称
+func Diff(a, b *Prototype, ignoreDebug bool) []Difference {
+	d := &differ{ignoreDebug: ignoreDebug}
+	d.diffProto("main", a, b)
+	return d.diffs
+}
+
+type differ struct {
+	ignoreDebug bool
+	diffs       []Difference
+	path        string
+	field       string
+}
+
+func (d *differ) report(format string, a ...interface{}) {
+	d.diffs = append(d.diffs, Difference{Func: d.path, Field: d.field, Text: fmt.Sprintf(format, a...)})
+}
+
+func (d *differ) diffProto(path string, a, b *Prototype) {
+	d.path = path
+	d.diffHeader(a, b)
+	d.diffCode(a, b)
+	d.diffConstants(a, b)
+	d.diffUpvalues(a, b)
+	if !d.ignoreDebug {
+		d.diffLocVars(a, b)
+	}
+
+	d.path, d.field = path, "protos"
+	if len(a.Protos) != len(b.Protos) {
+		d.report("%d functions -> %d functions", len(a.Protos), len(b.Protos))
+	}
+	// 先对齐指令完全相同的子函数，插入或删除一个函数不会使后面的函数都被认为发生了变化；
+	// 两个对齐位置之间剩下的子函数按照顺序一一比较，多出来的报告为插入或删除
+	matched := matchPairs(len(a.Protos), len(b.Protos), func(i, j int) bool {
+		return sameCode(a.Protos[i], b.Protos[j])
+	})
+	i, j := 0, 0
+	for _, p := range append(matched, pair{len(a.Protos), len(b.Protos)}) {
+		for ; i < p.i && j < p.j; i, j = i+1, j+1 {
+			d.diffSubProto(path, i, j, a.Protos[i], b.Protos[j])
+		}
+		for ; i < p.i; i++ {
+			sub := a.Protos[i]
+			d.path, d.field = fmt.Sprintf("%s/%d", path, i+1), "protos"
+			d.report("- function <%s:%d,%d>", sub.Source, sub.LineDefined, sub.LastLineDefined)
+		}
+		for ; j < p.j; j++ {
+			sub := b.Protos[j]
+			d.path, d.field = fmt.Sprintf("%s/%d", path, j+1), "protos"
+			d.report("+ function <%s:%d,%d>", sub.Source, sub.LineDefined, sub.LastLineDefined)
+		}
+		if p.i < len(a.Protos) {
+			d.diffSubProto(path, p.i, p.j, a.Protos[p.i], b.Protos[p.j])
+		}
+		i, j = p.i+1, p.j+1
+	}
+}
+
+// diffSubProto 比较a的第i个子函数和b的第j个子函数，位置不同时路径写作"main/2->3"
+func (d *differ) diffSubProto(path string, i, j int, a, b *Prototype) {
+	subPath := fmt.Sprintf("%s/%d", path, i+1)
+	if i != j {
+		subPath = fmt.Sprintf("%s->%d", subPath, j+1)
+	}
+	d.diffProto(subPath, a, b)
+}
+
+// sameCode 判断两个函数原型的指令表是否相同
+func sameCode(a, b *Prototype) bool {
+	if len(a.Code) != len(b.Code) {
+		return false
+	}
+	for i := range a.Code {
+		if a.Code[i] != b.Code[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *differ) diffHeader(a, b *Prototype) {
+	d.field = "header"
+	field := func(name string, x, y interface{}) {
+		if x != y {
+			d.report("%s %v -> %v", name, x, y)
+		}
+	}
+	field("version", fmt.Sprintf("0x%02x", a.Version), fmt.Sprintf("0x%02x", b.Version))
+	if !d.ignoreDebug {
+		field("source", strconv.Quote(a.Source), strconv.Quote(b.Source))
+	}
+	field("linedefined", a.LineDefined, b.LineDefined)
+	field("lastlinedefined", a.LastLineDefined, b.LastLineDefined)
+	field("params", a.NumParams, b.NumParams)
+	field("vararg", a.IsVararg, b.IsVararg)
+	field("slots", a.MaxStackSize, b.MaxStackSize)
+}
+
+// diffCode 按照最长公共子序列对齐指令表，报告插入和删除的指令，
+// 对齐的指令再比较行号，指令的位置从1开始编号，和luac -l一致
+func (d *differ) diffCode(a, b *Prototype) {
+	d.field = "code"
+	matched := matchPairs(len(a.Code), len(b.Code), func(i, j int) bool { return a.Code[i] == b.Code[j] })
+	i, j := 0, 0
+	for _, p := range append(matched, pair{len(a.Code), len(b.Code)}) {
+		for ; i < p.i; i++ {
+			d.report("- [%d] %s", i+1, vm.Instruction(a.Code[i]))
+		}
+		for ; j < p.j; j++ {
+			d.report("+ [%d] %s", j+1, vm.Instruction(b.Code[j]))
+		}
+		i, j = p.i+1, p.j+1
+	}
+	if d.ignoreDebug {
+		return
+	}
+	d.field = "lines"
+	for _, p := range matched {
+		lineA, lineB := a.LineForPC(p.i), b.LineForPC(p.j)
+		if lineA != lineB {
+			d.report("[%d] %s line %s -> %s", p.i+1, vm.Instruction(a.Code[p.i]),
+				lineString(lineA), lineString(lineB))
+		}
+	}
+}
+
+func lineString(line int) string {
+	if line < 0 {
+		return "-"
+	}
+	return strconv.Itoa(line)
+}
+
+func (d *differ) diffConstants(a, b *Prototype) {
+	d.field = "constants"
+	diffSlices(d, len(a.Constants), len(b.Constants), func(i int, fromA bool) string {
+		if fromA {
+			return constantString(a.Constants[i])
+		}
+		return constantString(b.Constants[i])
+	})
+}
+
+// constantString 返回带有类型的常量表示，使得整数1和浮点数1可以区分
+func constantString(constant interface{}) string {
+	switch c := constant.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(c)
+	case int64:
+		return strconv.FormatInt(c, 10)
+	case float64:
+		return "F" + strconv.FormatFloat(c, 'g', -1, 64)
+	case string:
+		return strconv.Quote(c)
+	default:
+		return fmt.Sprintf("?%v", c)
+	}
+}
+
+func (d *differ) diffUpvalues(a, b *Prototype) {
+	d.field = "upvalues"
+	upvalue := func(p *Prototype, i int) string {
+		u := p.Upvalues[i]
+		name := ""
+		if !d.ignoreDebug && i < len(p.UpvalueNames) {
+			name = p.UpvalueNames[i] + " "
+		}
+		return fmt.Sprintf("%sinstack=%d idx=%d kind=%d", name, u.Instack, u.Idx, u.Kind)
+	}
+	diffSlices(d, len(a.Upvalues), len(b.Upvalues), func(i int, fromA bool) string {
+		if fromA {
+			return upvalue(a, i)
+		}
+		return upvalue(b, i)
+	})
+}
+
+func (d *differ) diffLocVars(a, b *Prototype) {
+	d.field = "locals"
+	locVar := func(v LocVar) string {
+		return fmt.Sprintf("%s %d %d", v.VarName, v.StartPC+1, v.EndPC+1)
+	}
+	diffSlices(d, len(a.LocVars), len(b.LocVars), func(i int, fromA bool) string {
+		if fromA {
+			return locVar(a.LocVars[i])
+		}
+		return locVar(b.LocVars[i])
+	})
+}
+
+// diffSlices 按照下标比较两个表，item返回第i项的文本表示，fromA为true时取自a
+func diffSlices(d *differ, n, m int, item func(i int, fromA bool) string) {
+	for i := 0; i < n || i < m; i++ {
+		switch {
+		case i >= m:
+			d.report("- [%d] %s", i, item(i, true))
+		case i >= n:
+			d.report("+ [%d] %s", i, item(i, false))
+		default:
+			if x, y := item(i, true), item(i, false); x != y {
+				d.report("[%d] %s -> %s", i, x, y)
+			}
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package binchunk
+
+import (
+	"math/rand"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/depressi0n/myLua/vm"
+)
+
+func diffTestPrototype() *Prototype {
+	p := &Prototype{
+		Version:      LUAC_VERSION,
+		Source:       "@a.lua",
+		MaxStackSize: 2,
+		Code: []uint32{
+			iABx(vm.OP_LOADK, 0, 0),
+			iABC(vm.OP_MOVE, 1, 0, 0, 0),
+			iABC(vm.OP_RETURN0, 0, 0, 0, 0),
+		},
+		LineInfo:     []byte{1, 1, 0},
+		Constants:    []interface{}{int64(1)},
+		Upvalues:     []Upvalue{{Instack: 1}},
+		UpvalueNames: []string{"_ENV"},
+		LocVars:      []LocVar{{VarName: "x", StartPC: 1, EndPC: 3}},
+		Protos: []*Prototype{{
+			Version:     LUAC_VERSION,
+			Source:      "@a.lua",
+			LineDefined: 3,
+			Code:        []uint32{iABC(vm.OP_RETURN0, 0, 0, 0, 0)},
+		}},
+	}
+	return p
+}
+
+func TestDiffEqual(t *testing.T) {
+	file, err := os.Open("binchunk_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	p := Undump(file)
+	if diffs := Diff(p, p, false); len(diffs) != 0 {
+		t.Errorf("got %v, want no differences", diffs)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a, b := diffTestPrototype(), diffTestPrototype()
+	b.Source = "@b.lua"
+	b.Code = []uint32{
+		iABx(vm.OP_LOADK, 0, 0),
+		iABC(vm.OP_MOVE, 1, 0, 0, 0),
+		iABC(vm.OP_MOVE, 0, 0, 1, 0),
+		iABC(vm.OP_RETURN0, 0, 0, 0, 0),
+	}
+	b.LineInfo = []byte{1, 2, 0, 0xff}
+	b.Constants = []interface{}{1.0, "y"}
+	b.LocVars = nil
+	b.Protos = append(b.Protos, &Prototype{Source: "@b.lua", LineDefined: 7, LastLineDefined: 9})
+
+	want := []string{
+		`main: header: source "@a.lua" -> "@b.lua"`,
+		"main: code: + [3] MOVE 0 1 0",
+		"main: lines: [2] MOVE 1 0 0 line 2 -> 3",
+		"main: constants: [0] 1 -> F1",
+		`main: constants: + [1] "y"`,
+		"main: locals: - [0] x 2 4",
+		"main: protos: 1 functions -> 2 functions",
+		"main/2: protos: + function <@b.lua:7,9>",
+	}
+	var got []string
+	for _, d := range Diff(a, b, false) {
+		got = append(got, d.String())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+
+	// 忽略调试信息时只报告指令，常量和子函数的差异
+	got = nil
+	for _, d := range Diff(a, b, true) {
+		got = append(got, d.String())
+	}
+	want = []string{want[1], want[3], want[4], want[6], want[7]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ignoring debug info: got\n%q\nwant\n%q", got, want)
+	}
+}
+
+// lcsLength 用动态规划计算最长公共子序列的长度，用于检查 matchPairs
+func lcsLength(a, b []int) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] >= cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func TestMatchPairs(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for n := 0; n < 2000; n++ {
+		a, b := make([]int, rnd.Intn(20)), make([]int, rnd.Intn(20))
+		for i := range a {
+			a[i] = rnd.Intn(4)
+		}
+		for i := range b {
+			b[i] = rnd.Intn(4)
+		}
+		pairs := matchPairs(len(a), len(b), func(i, j int) bool { return a[i] == b[j] })
+		last := pair{-1, -1}
+		for _, p := range pairs {
+			if p.i <= last.i || p.j <= last.j || a[p.i] != b[p.j] {
+				t.Fatalf("%v %v: invalid pairs %v", a, b, pairs)
+			}
+			last = p
+		}
+		if want := lcsLength(a, b); len(pairs) != want {
+			t.Fatalf("%v %v: got %d pairs, want %d", a, b, len(pairs), want)
+		}
+	}
+}
+
+// TestDiffLargeCode 比较很长的指令表时，内存与指令数成线性关系
+func TestDiffLargeCode(t *testing.T) {
+	a, b := diffTestPrototype(), diffTestPrototype()
+	a.Code, b.Code = nil, nil
+	for i := 0; i < 20000; i++ {
+		a.Code = append(a.Code, iABx(vm.OP_LOADK, 0, i%1000))
+		if i%100 != 0 {
+			b.Code = append(b.Code, iABx(vm.OP_LOADK, 0, i%1000))
+		}
+	}
+	a.LineInfo, b.LineInfo = nil, nil
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	diffs := Diff(a, b, true)
+	runtime.ReadMemStats(&after)
+	if len(diffs) != 200 {
+		t.Errorf("got %d differences, want 200", len(diffs))
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 64<<20 {
+		t.Errorf("allocated %d bytes", alloc)
+	}
+}
+
+// TestDiffInsertedProto 插入一个子函数时，后面的子函数仍然和原来的对齐
+func TestDiffInsertedProto(t *testing.T) {
+	sub := func(n int) *Prototype {
+		return &Prototype{Version: LUAC_VERSION, Source: "@a.lua", LineDefined: n,
+			Code: []uint32{iABx(vm.OP_LOADK, 0, n), iABC(vm.OP_RETURN0, 0, 0, 0, 0)}}
+	}
+	a, b := diffTestPrototype(), diffTestPrototype()
+	a.Protos = []*Prototype{sub(1), sub(2), sub(3)}
+	b.Protos = []*Prototype{sub(1), sub(9), sub(2), sub(4)}
+	var got []string
+	for _, d := range Diff(a, b, true) {
+		got = append(got, d.String())
+	}
+	want := []string{
+		"main: protos: 3 functions -> 4 functions",
+		"main/2: protos: + function <@a.lua:9,0>",
+		"main/3->4: header: linedefined 3 -> 4",
+		"main/3->4: code: - [1] LOADK 0 3",
+		"main/3->4: code: + [1] LOADK 0 4",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,107 @@
+package binchunk
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func FuzzUndump(f *testing.F) {
+	data, err := ioutil.ReadFile("binchunk_test")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(data)
+	var buf bytes.Buffer
+	if err := Dump(&buf, diffTestPrototype(), false); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf.Bytes())
+	for _, version := range []byte{LUAC_VERSION_51, LUAC_VERSION_52, LUAC_VERSION_53} {
+		p := diffTestPrototype()
+		p.Version = version
+		chunk, err := ioutil.ReadAll(newChunkBuilder(version).build(p))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(chunk)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// 按需解析与Undump一样，对任何输入都返回错误而不是panic
+		var lazy *Prototype
+		chunk, lazyErr := NewLazyChunk(bytes.NewReader(data), int64(len(data)))
+		if lazyErr == nil {
+			lazy, lazyErr = chunk.Main().LoadAll()
+		}
+		proto, err := TryUndump(bytes.NewReader(data))
+		if err != nil {
+			if lazyErr == nil {
+				t.Fatalf("Undump failed with %v but lazy loading succeeded", err)
+			}
+			return
+		}
+		// 解析成功的chunk上的其他操作也不能panic
+		Verify(proto)
+		for pc := range proto.Code {
+			proto.LineForPC(pc)
+		}
+		if proto.Version != LUAC_VERSION {
+			return
+		}
+		if lazyErr != nil {
+			t.Fatalf("Undump succeeded but lazy loading failed: %v", lazyErr)
+		}
+		if !reflect.DeepEqual(lazy, proto) {
+			t.Fatalf("LoadAll() = %+v, want %+v", lazy, proto)
+		}
+	})
+}
+
+// TestUndumpHugeCount 检查伪造的长度不会导致分配大量内存
+func TestUndumpHugeCount(t *testing.T) {
+	var header bytes.Buffer
+	w := NewLuaWriter(&header, false)
+	w.dumpHeader()
+	w.dumpByte(0) // nupvals
+	w.dumpInt(0)  // source
+	w.dumpInt(0)  // linedefined
+	w.dumpInt(0)  // lastlinedefined
+	w.dumpBytes([]byte{0, 0, 2})
+	w.Flush()
+
+	for _, prefix := range [][]byte{
+		nil,                        // 指令数量
+		{0x80},                     // 常量数量
+		{0x80, 0x81, TAG_LONG_STR}, // 字符串常量的长度
+		{0x80, 0x80},               // upvalue数量
+		{0x80, 0x80, 0x80},         // 子函数原型数量
+		{0x80, 0x80, 0x80, 0x80},   // 行号信息的长度
+	} {
+		var buf bytes.Buffer
+		w := NewLuaWriter(&buf, false)
+		w.dumpBytes(header.Bytes())
+		w.dumpBytes(prefix)
+		w.dumpInt(1 << 40)
+		w.dumpBytes(make([]byte, 64))
+		w.Flush()
+		data := buf.Bytes()
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := TryUndump(bytes.NewReader(data))
+		_, lazyErr := NewLazyChunk(bytes.NewReader(data), int64(len(data)))
+		runtime.ReadMemStats(&after)
+		if err == nil {
+			t.Errorf("prefix % x: no error", prefix)
+		}
+		if lazyErr == nil {
+			t.Errorf("prefix % x: NewLazyChunk: no error", prefix)
+		}
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			t.Errorf("prefix % x: allocated %d bytes", prefix, n)
+		}
+	}
+}
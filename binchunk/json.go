@@ -0,0 +1,430 @@
+package binchunk
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"unicode/utf8"
+
+	"github.com/depressi0n/myLua/vm"
+)
+
+// jsonPrototype 是函数原型的JSON表示，字段名称和顺序构成稳定的JSON格式：
+//
+//	version      版本号，如"5.4"
+//	code         指令表，Lua5.4的指令解码为操作码名称和具名操作数，
+//	             有符号的sB和sC操作数解码为整数，记录为sb和sc，
+//	             其他版本的指令只记录原始值raw
+//	constants    常量表，每个常量记录类型type和值value，
+//	             非有限的浮点数的值为"inf"，"-inf"或"nan"，
+//	             不是合法UTF-8的字符串用base64记录
+//	lineInfo     Lua5.4的增量行号表，每项是有符号整数，-128表示使用绝对行号
+//	lines        Lua5.1~5.3中每条指令对应的行号
+type jsonPrototype struct {
+	Version         string            `json:"version"`
+	Source          string            `json:"source"`
+	LineDefined     int               `json:"lineDefined"`
+	LastLineDefined int               `json:"lastLineDefined"`
+	NumParams       int               `json:"numParams"`
+	IsVararg        int               `json:"isVararg"`
+	MaxStackSize    int               `json:"maxStackSize"`
+	Code            []jsonInstruction `json:"code"`
+	Constants       []jsonConstant    `json:"constants"`
+	Upvalues        []jsonUpvalue     `json:"upvalues"`
+	Protos          []*jsonPrototype  `json:"protos"`
+	LineInfo        []int             `json:"lineInfo,omitempty"`
+	AbsLineInfo     []jsonAbsLineInfo `json:"absLineInfo,omitempty"`
+	Lines           []int             `json:"lines,omitempty"`
+	LocVars         []jsonLocVar      `json:"locVars,omitempty"`
+	UpvalueNames    []string          `json:"upvalueNames,omitempty"`
+}
+
+// jsonInstruction 只包含指令编码模式中出现的操作数
+type jsonInstruction struct {
+	Op  string  `json:"op,omitempty"`
+	A   *int    `json:"a,omitempty"`
+	K   *bool   `json:"k,omitempty"`
+	B   *int    `json:"b,omitempty"`
+	SB  *int    `json:"sb,omitempty"`
+	C   *int    `json:"c,omitempty"`
+	SC  *int    `json:"sc,omitempty"`
+	Bx  *int    `json:"bx,omitempty"`
+	SBx *int    `json:"sbx,omitempty"`
+	Ax  *int    `json:"ax,omitempty"`
+	SJ  *int    `json:"sj,omitempty"`
+	Raw *uint32 `json:"raw,omitempty"`
+}
+
+type jsonConstant struct {
+	Type   string      `json:"type"`
+	Value  interface{} `json:"value,omitempty"`
+	Base64 string      `json:"base64,omitempty"`
+}
+
+type jsonUpvalue struct {
+	Instack bool `json:"instack"`
+	Idx     int  `json:"idx"`
+	Kind    int  `json:"kind"`
+}
+
+type jsonAbsLineInfo struct {
+	Pc   int `json:"pc"`
+	Line int `json:"line"`
+}
+
+type jsonLocVar struct {
+	Name    string `json:"name"`
+	StartPC int    `json:"startPC"`
+	EndPC   int    `json:"endPC"`
+}
+
+// EncodeJSON 把函数原型及其所有子函数原型编码为JSON写入w
+func EncodeJSON(w io.Writer, p *Prototype) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(toJSON(p))
+}
+
+// DecodeJSON 从r读取 EncodeJSON 生成的JSON，重建函数原型
+func DecodeJSON(r io.Reader) (*Prototype, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	var jp jsonPrototype
+	if err := dec.Decode(&jp); err != nil {
+		return nil, err
+	}
+	return fromJSON(&jp, "main")
+}
+
+func intPtr(x int) *int { return &x }
+
+func toJSON(p *Prototype) *jsonPrototype {
+	jp := &jsonPrototype{
+		Version:         fmt.Sprintf("%d.%d", p.Version>>4, p.Version&0xF),
+		Source:          p.Source,
+		LineDefined:     p.LineDefined,
+		LastLineDefined: p.LastLineDefined,
+		NumParams:       int(p.NumParams),
+		IsVararg:        int(p.IsVararg),
+		MaxStackSize:    int(p.MaxStackSize),
+		Code:            make([]jsonInstruction, len(p.Code)),
+		Constants:       make([]jsonConstant, len(p.Constants)),
+		Upvalues:        make([]jsonUpvalue, len(p.Upvalues)),
+		Protos:          make([]*jsonPrototype, len(p.Protos)),
+		Lines:           p.Lines,
+		UpvalueNames:    p.UpvalueNames,
+	}
+	for pc, code := range p.Code {
+		jp.Code[pc] = instructionToJSON(p.Version, code)
+	}
+	for i, c := range p.Constants {
+		jp.Constants[i] = constantToJSON(c)
+	}
+	for i, u := range p.Upvalues {
+		jp.Upvalues[i] = jsonUpvalue{Instack: u.Instack != 0, Idx: int(u.Idx), Kind: int(u.Kind)}
+	}
+	for i, sub := range p.Protos {
+		jp.Protos[i] = toJSON(sub)
+	}
+	for _, delta := range p.LineInfo {
+		jp.LineInfo = append(jp.LineInfo, int(int8(delta)))
+	}
+	for _, info := range p.AbsLineInfo {
+		jp.AbsLineInfo = append(jp.AbsLineInfo, jsonAbsLineInfo(info))
+	}
+	for _, v := range p.LocVars {
+		jp.LocVars = append(jp.LocVars, jsonLocVar{Name: v.VarName, StartPC: v.StartPC, EndPC: v.EndPC})
+	}
+	return jp
+}
+
+func instructionToJSON(version byte, code uint32) jsonInstruction {
+	i := vm.Instruction(code)
+	if version != LUAC_VERSION || i.Opcode() >= vm.NUM_OPCODES {
+		return jsonInstruction{Raw: &code}
+	}
+	ji := jsonInstruction{Op: i.OpName()}
+	switch i.OpMode() {
+	case vm.OpModeABC:
+		a, k, b, c := i.IABC()
+		hasK := k != 0
+		ji.A, ji.K = &a, &hasK
+		signedB, signedC := signedOperands(i.Opcode())
+		if signedB {
+			ji.SB = intPtr(vm.SC2Int(b))
+		} else {
+			ji.B = &b
+		}
+		if signedC {
+			ji.SC = intPtr(vm.SC2Int(c))
+		} else {
+			ji.C = &c
+		}
+	case vm.OpModeABx:
+		a, bx := i.IABx()
+		ji.A, ji.Bx = &a, &bx
+	case vm.OpModeAsBx:
+		a, sbx := i.IAsBx()
+		ji.A, ji.SBx = &a, &sbx
+	case vm.OpModeAx:
+		ji.Ax = intPtr(i.IAx())
+	case vm.OpModesJ:
+		ji.SJ = intPtr(i.IsJx())
+	}
+	return ji
+}
+
+// signedOperands 返回iABC模式的操作码op的B和C操作数是否是有符号的sB和sC
+func signedOperands(op int) (signedB, signedC bool) {
+	info, _ := vm.OpcodeInfo(op)
+	return info.SignedB, info.SignedC
+}
+
+func constantToJSON(constant interface{}) jsonConstant {
+	switch c := constant.(type) {
+	case nil:
+		return jsonConstant{Type: "nil"}
+	case bool:
+		return jsonConstant{Type: "boolean", Value: c}
+	case int64:
+		return jsonConstant{Type: "integer", Value: c}
+	case float64:
+		switch {
+		case math.IsInf(c, 1):
+			return jsonConstant{Type: "float", Value: "inf"}
+		case math.IsInf(c, -1):
+			return jsonConstant{Type: "float", Value: "-inf"}
+		case math.IsNaN(c):
+			return jsonConstant{Type: "float", Value: "nan"}
+		}
+		return jsonConstant{Type: "float", Value: c}
+	case string:
+		if !utf8.ValidString(c) {
+			return jsonConstant{Type: "string", Base64: base64.StdEncoding.EncodeToString([]byte(c))}
+		}
+		return jsonConstant{Type: "string", Value: c}
+	default:
+		panic(fmt.Sprintf("unknown constant type %T", constant))
+	}
+}
+
+func fromJSON(jp *jsonPrototype, path string) (*Prototype, error) {
+	var major, minor byte
+	if _, err := fmt.Sscanf(jp.Version, "%d.%d", &major, &minor); err != nil || major > 0xF || minor > 0xF {
+		return nil, fmt.Errorf("%s: invalid version %q", path, jp.Version)
+	}
+	p := &Prototype{
+		Version:         major<<4 | minor,
+		Source:          jp.Source,
+		LineDefined:     jp.LineDefined,
+		LastLineDefined: jp.LastLineDefined,
+		Code:            make([]uint32, len(jp.Code)),
+		Constants:       make([]interface{}, len(jp.Constants)),
+		Upvalues:        make([]Upvalue, len(jp.Upvalues)),
+		Protos:          make([]*Prototype, len(jp.Protos)),
+		Lines:           jp.Lines,
+		UpvalueNames:    jp.UpvalueNames,
+	}
+	var err error
+	if p.NumParams, err = byteField(jp.NumParams, path, "numParams"); err != nil {
+		return nil, err
+	}
+	if p.MaxStackSize, err = byteField(jp.MaxStackSize, path, "maxStackSize"); err != nil {
+		return nil, err
+	}
+	if p.IsVararg, err = byteField(jp.IsVararg, path, "isVararg"); err != nil {
+		return nil, err
+	}
+	for pc := range jp.Code {
+		if p.Code[pc], err = instructionFromJSON(&jp.Code[pc]); err != nil {
+			return nil, fmt.Errorf("%s: code[%d]: %v", path, pc, err)
+		}
+	}
+	for i := range jp.Constants {
+		if p.Constants[i], err = constantFromJSON(&jp.Constants[i]); err != nil {
+			return nil, fmt.Errorf("%s: constants[%d]: %v", path, i, err)
+		}
+	}
+	for i, u := range jp.Upvalues {
+		if u.Idx < 0 || u.Idx > math.MaxUint8 || u.Kind < 0 || u.Kind > math.MaxUint8 {
+			return nil, fmt.Errorf("%s: upvalues[%d]: idx or kind out of range", path, i)
+		}
+		p.Upvalues[i] = Upvalue{Idx: byte(u.Idx), Kind: byte(u.Kind)}
+		if u.Instack {
+			p.Upvalues[i].Instack = 1
+		}
+	}
+	for i, sub := range jp.Protos {
+		if sub == nil {
+			return nil, fmt.Errorf("%s: protos[%d] is null", path, i)
+		}
+		if p.Protos[i], err = fromJSON(sub, fmt.Sprintf("%s/%d", path, i+1)); err != nil {
+			return nil, err
+		}
+	}
+	for i, delta := range jp.LineInfo {
+		if delta < math.MinInt8 || delta > math.MaxInt8 {
+			return nil, fmt.Errorf("%s: lineInfo[%d]: %d out of range", path, i, delta)
+		}
+		p.LineInfo = append(p.LineInfo, byte(int8(delta)))
+	}
+	for _, info := range jp.AbsLineInfo {
+		p.AbsLineInfo = append(p.AbsLineInfo, AbsLineInfo(info))
+	}
+	for _, v := range jp.LocVars {
+		p.LocVars = append(p.LocVars, LocVar{VarName: v.Name, StartPC: v.StartPC, EndPC: v.EndPC})
+	}
+	return p, nil
+}
+
+func byteField(x int, path, name string) (byte, error) {
+	if x < 0 || x > math.MaxUint8 {
+		return 0, fmt.Errorf("%s: %s %d out of range", path, name, x)
+	}
+	return byte(x), nil
+}
+
+// instructionFromJSON 按照操作码的编码模式重新编码指令，多余或缺少的操作数都是错误
+func instructionFromJSON(ji *jsonInstruction) (uint32, error) {
+	if ji.Op == "" {
+		if ji.Raw == nil {
+			return 0, fmt.Errorf("missing op or raw")
+		}
+		return *ji.Raw, nil
+	}
+	op, ok := vm.OpcodeByName(ji.Op)
+	if !ok {
+		return 0, fmt.Errorf("unknown op %q", ji.Op)
+	}
+	// 每种编码模式的操作数
+	type operand struct {
+		name  string
+		value *int
+	}
+	a := operand{"a", ji.A}
+	var operands []operand
+	mode := vm.Instruction(op).OpMode()
+	switch mode {
+	case vm.OpModeABC:
+		b, c := operand{"b", ji.B}, operand{"c", ji.C}
+		signedB, signedC := signedOperands(op)
+		if signedB {
+			b = operand{"sb", ji.SB}
+		}
+		if signedC {
+			c = operand{"sc", ji.SC}
+		}
+		operands = []operand{a, b, c}
+	case vm.OpModeABx:
+		operands = []operand{a, {"bx", ji.Bx}}
+	case vm.OpModeAsBx:
+		operands = []operand{a, {"sbx", ji.SBx}}
+	case vm.OpModeAx:
+		operands = []operand{{"ax", ji.Ax}}
+	case vm.OpModesJ:
+		operands = []operand{{"sj", ji.SJ}}
+	}
+
+	given := 0
+	for _, x := range []*int{ji.A, ji.B, ji.SB, ji.C, ji.SC, ji.Bx, ji.SBx, ji.Ax, ji.SJ} {
+		if x != nil {
+			given++
+		}
+	}
+	if given != len(operands) || ji.Raw != nil || (ji.K != nil && mode != vm.OpModeABC) {
+		names := make([]string, len(operands))
+		for i, o := range operands {
+			names[i] = o.name
+		}
+		return 0, fmt.Errorf("%s: want operands %v", ji.Op, names)
+	}
+	values := make([]int, len(operands))
+	for i, o := range operands {
+		if o.value == nil {
+			return 0, fmt.Errorf("%s: missing operand %s", ji.Op, o.name)
+		}
+		values[i] = *o.value
+		if o.name == "sb" || o.name == "sc" {
+			v, err := vm.Int2sC(values[i])
+			if err != nil {
+				e := err.(*vm.OperandError)
+				e.Op, e.Operand = ji.Op, o.name
+				return 0, e
+			}
+			values[i] = v
+		}
+	}
+	var code vm.Instruction
+	var err error
+	switch mode {
+	case vm.OpModeABC:
+		k := 0
+		if ji.K != nil && *ji.K {
+			k = 1
+		}
+		code, err = vm.CreateABCk(op, values[0], values[1], values[2], k)
+	case vm.OpModeABx:
+		code, err = vm.CreateABx(op, values[0], values[1])
+	case vm.OpModeAsBx:
+		code, err = vm.CreateAsBx(op, values[0], values[1])
+	case vm.OpModeAx:
+		code, err = vm.CreateAx(op, values[0])
+	case vm.OpModesJ:
+		code, err = vm.CreatesJ(op, values[0])
+	}
+	return uint32(code), err
+}
+
+func constantFromJSON(jc *jsonConstant) (interface{}, error) {
+	switch jc.Type {
+	case "nil":
+		if jc.Value != nil {
+			return nil, fmt.Errorf("nil with value")
+		}
+		return nil, nil
+	case "boolean":
+		if b, ok := jc.Value.(bool); ok {
+			return b, nil
+		}
+	case "integer":
+		if n, ok := jc.Value.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				return i, nil
+			}
+		}
+	case "float":
+		switch v := jc.Value.(type) {
+		case json.Number:
+			if f, err := v.Float64(); err == nil {
+				return f, nil
+			}
+		case string:
+			switch v {
+			case "inf":
+				return math.Inf(1), nil
+			case "-inf":
+				return math.Inf(-1), nil
+			case "nan":
+				return math.NaN(), nil
+			}
+		}
+	case "string":
+		if jc.Base64 != "" {
+			b, err := base64.StdEncoding.DecodeString(jc.Base64)
+			if err != nil {
+				return nil, err
+			}
+			return string(b), nil
+		}
+		if s, ok := jc.Value.(string); ok {
+			return s, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown constant type %q", jc.Type)
+	}
+	return nil, fmt.Errorf("invalid %s value %v", jc.Type, jc.Value)
+}
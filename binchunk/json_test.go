@@ -0,0 +1,167 @@
+package binchunk
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/depressi0n/myLua/vm"
+)
+
+// jsonRoundTrip 编码再解码函数原型，并检查再次编码的结果不变
+func jsonRoundTrip(t *testing.T, p *Prototype) *Prototype {
+	var first bytes.Buffer
+	if err := EncodeJSON(&first, p); err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeJSON(bytes.NewReader(first.Bytes()))
+	if err != nil {
+		t.Fatalf("%v\n%s", err, first.String())
+	}
+	var second bytes.Buffer
+	if err := EncodeJSON(&second, got); err != nil {
+		t.Fatal(err)
+	}
+	if first.String() != second.String() {
+		t.Errorf("re-encoded JSON differs:\n%s\n%s", first.String(), second.String())
+	}
+	if diffs := Diff(p, got, false); len(diffs) > 0 {
+		t.Errorf("round trip differences: %v", diffs)
+	}
+	return got
+}
+
+func TestJSONRoundTripUndump(t *testing.T) {
+	file, err := os.Open("binchunk_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	jsonRoundTrip(t, Undump(file))
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	p := diffTestPrototype()
+	p.Code = []uint32{
+		iABC(vm.OP_SETFIELD, 0, 1, 0, 255),
+		iABC(vm.OP_ADDI, 1, 0, 0, vm.OFFSET_sC-3),
+		iABC(vm.OP_EQI, 1, 1, vm.OFFSET_sC+5, 1),
+		iABC(vm.OP_MMBINI, 1, 0, vm.OFFSET_sC-127, 6),
+		iABx(vm.OP_LOADI, 1, vm.OFFSET_sBx-7),
+		iABx(vm.OP_CLOSURE, 0, vm.MAXARG_Bx),
+		isJ(vm.OP_JMP, -vm.OFFSET_sJ),
+		uint32(vm.OP_EXTRAARG | vm.MAXARG_Ax<<vm.POS_Ax),
+		uint32(vm.NUM_OPCODES + 3),
+	}
+	p.LineInfo = []byte{1, 0x80, 0xff, 2, 0, 0}
+	p.AbsLineInfo = []AbsLineInfo{{Pc: 1, Line: 300}}
+	p.Constants = []interface{}{nil, false, true, int64(math.MinInt64), 0.1, math.Inf(-1), math.NaN(), "", "\xff\x00<a>"}
+	got := jsonRoundTrip(t, p)
+	for pc := range p.Code {
+		if got.Code[pc] != p.Code[pc] {
+			t.Errorf("code[%d]: got %#08x, want %#08x", pc, got.Code[pc], p.Code[pc])
+		}
+	}
+	// 有符号的操作数解码为整数
+	for pc, want := range map[int]string{
+		1: `{"op":"ADDI","a":1,"k":false,"b":0,"sc":-3}`,
+		2: `{"op":"EQI","a":1,"k":true,"sb":5,"c":1}`,
+		3: `{"op":"MMBINI","a":1,"k":false,"sb":-127,"c":6}`,
+	} {
+		data, err := json.Marshal(instructionToJSON(LUAC_VERSION, p.Code[pc]))
+		if err != nil || string(data) != want {
+			t.Errorf("code[%d]: got %s, want %s", pc, data, want)
+		}
+	}
+	if s := got.Constants[8].(string); s != "\xff\x00<a>" {
+		t.Errorf("got string constant %q", s)
+	}
+
+	old := testPrototype(LUAC_VERSION_51)
+	old.IsVararg = 3
+	jsonRoundTrip(t, old)
+}
+
+func TestDecodeJSONErrors(t *testing.T) {
+	tests := []struct {
+		json string
+		want string
+	}{
+		{`{"version": "x"}`, "invalid version"},
+		{`{"version": "5.4", "maxStackSize": 256}`, "maxStackSize 256 out of range"},
+		{`{"version": "5.4", "code": [{"op": "NOPE"}]}`, `unknown op "NOPE"`},
+		{`{"version": "5.4", "code": [{"op": "MOVE", "a": 1, "b": 2}]}`, "want operands [a b c]"},
+		{`{"version": "5.4", "code": [{"op": "LOADK", "a": 1, "bx": 1, "k": true}]}`, "want operands [a bx]"},
+		{`{"version": "5.4", "code": [{"op": "MOVE", "a": 256, "b": 0, "c": 0}]}`, "operand a 256 out of range"},
+		{`{"version": "5.4", "code": [{"op": "JMP", "sj": -16777216}]}`, "operand sj -16777216 out of range"},
+		{`{"version": "5.4", "code": [{"op": "ADDI", "a": 0, "b": 0, "c": 1}]}`, "ADDI: missing operand sc"},
+		{`{"version": "5.4", "code": [{"op": "GTI", "a": 0, "sb": 129, "c": 0}]}`, "GTI: operand sb 129 out of range"},
+		{`{"version": "5.4", "code": [{}]}`, "missing op or raw"},
+		{`{"version": "5.4", "constants": [{"type": "integer", "value": 1.5}]}`, "invalid integer value"},
+		{`{"version": "5.4", "constants": [{"type": "table"}]}`, `unknown constant type "table"`},
+		{`{"version": "5.4", "protos": [{"version": "5.4", "lineInfo": [200]}]}`, "main/1: lineInfo[0]: 200 out of range"},
+		{`{"version": "5.4", "extra": 1}`, "unknown field"},
+	}
+	for _, test := range tests {
+		_, err := DecodeJSON(strings.NewReader(test.json))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want %q", test.json, err, test.want)
+		}
+	}
+}
+
+func TestEncodeYAML(t *testing.T) {
+	p := &Prototype{
+		Version:      LUAC_VERSION,
+		Source:       "@<a>.lua",
+		MaxStackSize: 2,
+		Code:         []uint32{iABx(vm.OP_LOADK, 0, 0), iABC(vm.OP_RETURN0, 0, 0, 0, 0)},
+		Constants:    []interface{}{"a\"b", 1.5},
+		Protos:       []*Prototype{{Version: LUAC_VERSION, LineDefined: 2, LastLineDefined: 3}},
+	}
+	want := `version: "5.4"
+source: "@<a>.lua"
+lineDefined: 0
+lastLineDefined: 0
+numParams: 0
+isVararg: 0
+maxStackSize: 2
+code:
+  - op: "LOADK"
+    a: 0
+    bx: 0
+  - op: "RETURN0"
+    a: 0
+    k: false
+    b: 0
+    c: 0
+constants:
+  - type: "string"
+    value: "a\"b"
+  - type: "float"
+    value: 1.5
+upvalues: []
+protos:
+  - version: "5.4"
+    source: ""
+    lineDefined: 2
+    lastLineDefined: 3
+    numParams: 0
+    isVararg: 0
+    maxStackSize: 0
+    code: []
+    constants: []
+    upvalues: []
+    protos: []
+`
+	var buf bytes.Buffer
+	if err := EncodeYAML(&buf, p); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
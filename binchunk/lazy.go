@@ -0,0 +1,224 @@
+package binchunk
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// LazyChunk 按需解析Lua5.4的二进制chunk
+//
+// NewLazyChunk 只扫描一遍chunk，记录每个函数原型的位置，不创建指令表、常量表和调试信息，
+// 函数原型在第一次调用 LazyProto.Load 时才解析。底层的 io.ReaderAt 可以是文件，
+// 也可以是 bytes.NewReader 包装的内存映射区域，chunk使用期间不能修改
+type LazyChunk struct {
+	r        io.ReaderAt
+	size     int64
+	settings LuaReader // 头部给出的版本号、字节序和各种大小，每次解析时复制
+	main     *LazyProto
+}
+
+// LazyProto 是一个尚未解析的函数原型
+type LazyProto struct {
+	chunk           *LazyChunk
+	offset          int64 // 函数原型的起始位置
+	protosOffset    int64 // 子函数原型数量的位置，即函数体的结束位置
+	debugOffset     int64 // 调试信息的起始位置
+	end             int64 // 函数原型的结束位置
+	source          string
+	lineDefined     int
+	lastLineDefined int
+	protos          []*LazyProto
+
+	once  sync.Once
+	proto *Prototype
+	err   error
+}
+
+// offsetReader 记录已经读取的字节数
+type offsetReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *offsetReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// NewLazyChunk 检查头部并建立函数原型的索引，size是chunk的长度
+func NewLazyChunk(r io.ReaderAt, size int64) (chunk *LazyChunk, err error) {
+	defer recoverError(&err)
+	c := &LazyChunk{r: r, size: size}
+	lr, pos := c.readerAt(0, size)
+	lr.checkHeader()
+	if lr.version != LUAC_VERSION {
+		return nil, fmt.Errorf("%w: lazy loading needs a Lua 5.4 chunk, got version 0x%02x",
+			ErrUnsupportedVersion, lr.version)
+	}
+	c.settings = *lr
+	c.settings.Reader = nil
+	nupvals := lr.loadByte()
+	var nmain int
+	c.main, nmain = c.index(lr, pos, "")
+	if int(nupvals) != nmain {
+		panic("unmatched nupvals and proto.Upvalues")
+	}
+	return c, nil
+}
+
+// readerAt 返回读取[offset, end)的LuaReader，以及返回当前位置的函数
+func (c *LazyChunk) readerAt(offset, end int64) (*LuaReader, func() int64) {
+	or := &offsetReader{r: io.NewSectionReader(c.r, offset, end-offset)}
+	lr := NewLuaReader(nil)
+	if c.settings.order != nil {
+		*lr = c.settings
+	}
+	lr.Reader = bufio.NewReader(or)
+	return lr, func() int64 { return offset + or.n - int64(lr.Buffered()) }
+}
+
+// skip 跳过n个字节
+func (r *LuaReader) skip(n uint) {
+	if _, err := r.Discard(int(n)); err != nil {
+		panic(err)
+	}
+}
+
+func (r *LuaReader) skipString() {
+	if size := r.loadSize(); size > 0 {
+		r.skip(size - 1)
+	}
+}
+
+// skipItems 跳过n个大小为size的元素，先检查它们没有超出chunk的末尾，
+// 伪造的数量不会使乘法溢出
+func (c *LazyChunk) skipItems(r *LuaReader, pos func() int64, n int, size uint) {
+	if n < 0 || uint64(n) > uint64(c.size-pos())/uint64(size) {
+		panic("truncated precompiled chunk")
+	}
+	r.skip(uint(n) * size)
+}
+
+// index 扫描从当前位置开始的函数原型，返回它的索引和upvalue数量
+func (c *LazyChunk) index(r *LuaReader, pos func() int64, parentSource string) (*LazyProto, int) {
+	p := &LazyProto{chunk: c, offset: pos()}
+	p.source = r.loadString()
+	if p.source == "" {
+		p.source = parentSource
+	}
+	p.lineDefined = r.loadInt()
+	p.lastLineDefined = r.loadInt()
+	r.skip(3) // numparams, is_vararg, maxstacksize
+	c.skipItems(r, pos, r.loadInt(), INSTRUCTION_SIZE)
+	for n := r.loadInt(); n > 0; n-- {
+		switch tag := r.loadByte(); tag {
+		case TAG_NIL, TAG_FALSE, TAG_TRUE:
+		case TAG_NUMFLT:
+			r.skip(r.numberSize)
+		case TAG_NUMINT:
+			r.skip(r.integerSize)
+		case TAG_SHORT_STR, TAG_LONG_STR:
+			r.skipString()
+		default:
+			panic("unknown tag")
+		}
+	}
+	nupvals := r.loadInt()
+	c.skipItems(r, pos, nupvals, 3) // instack, idx, kind
+	p.protosOffset = pos()
+	n, capacity := r.loadCount()
+	p.protos = make([]*LazyProto, 0, capacity)
+	for i := 0; i < n; i++ {
+		sub, _ := c.index(r, pos, p.source)
+		p.protos = append(p.protos, sub)
+	}
+	p.debugOffset = pos()
+	c.skipItems(r, pos, r.loadInt(), 1) // lineinfo
+	for n := r.loadInt(); n > 0; n-- {
+		r.loadInt() // pc
+		r.loadInt() // line
+	}
+	for n := r.loadInt(); n > 0; n-- {
+		r.skipString()
+		r.loadInt() // startpc
+		r.loadInt() // endpc
+	}
+	for n := r.loadInt(); n > 0; n-- {
+		r.skipString()
+	}
+	p.end = pos()
+	return p, nupvals
+}
+
+// Main 返回主函数
+func (c *LazyChunk) Main() *LazyProto {
+	return c.main
+}
+
+// Source 返回函数原型的源文件名，不需要解析函数原型
+func (p *LazyProto) Source() string { return p.source }
+
+// LineDefined 返回函数定义的起始行号，不需要解析函数原型
+func (p *LazyProto) LineDefined() int { return p.lineDefined }
+
+// LastLineDefined 返回函数定义的结束行号，不需要解析函数原型
+func (p *LazyProto) LastLineDefined() int { return p.lastLineDefined }
+
+// NumProtos 返回子函数原型的数量
+func (p *LazyProto) NumProtos() int { return len(p.protos) }
+
+// Proto 返回第i个子函数原型，从0开始编号，与CLOSURE指令的Bx一致
+func (p *LazyProto) Proto(i int) *LazyProto { return p.protos[i] }
+
+// Load 解析函数原型，包括调试信息，结果会被缓存，可以并发调用
+// 返回的函数原型的Protos为nil，子函数原型通过 Proto 按需解析
+func (p *LazyProto) Load() (*Prototype, error) {
+	p.once.Do(func() {
+		p.proto, p.err = p.load()
+	})
+	return p.proto, p.err
+}
+
+func (p *LazyProto) load() (proto *Prototype, err error) {
+	defer recoverError(&err)
+	r, _ := p.chunk.readerAt(p.offset, p.protosOffset)
+	r.skipString()
+	proto = &Prototype{
+		Version:         LUAC_VERSION,
+		Source:          p.source,
+		LineDefined:     r.loadInt(),
+		LastLineDefined: r.loadInt(),
+		NumParams:       r.loadByte(),
+		IsVararg:        r.loadByte(),
+		MaxStackSize:    r.loadByte(),
+		Code:            r.loadCode(),
+		Constants:       r.loadConstants(),
+		Upvalues:        r.loadUpvalues(),
+	}
+	r, _ = p.chunk.readerAt(p.debugOffset, p.end)
+	proto.LineInfo = r.loadLineInfo()
+	proto.AbsLineInfo = r.loadAbsLineInfo()
+	proto.LocVars = r.loadLocVars()
+	proto.UpvalueNames = r.loadUpvalueNames()
+	return proto, nil
+}
+
+// LoadAll 解析函数原型及其所有子函数原型，结果与 Undump 相同
+func (p *LazyProto) LoadAll() (*Prototype, error) {
+	proto, err := p.Load()
+	if err != nil {
+		return nil, err
+	}
+	// 复制一份，不修改缓存的函数原型
+	all := *proto
+	all.Protos = make([]*Prototype, len(p.protos))
+	for i, sub := range p.protos {
+		if all.Protos[i], err = sub.LoadAll(); err != nil {
+			return nil, err
+		}
+	}
+	return &all, nil
+}
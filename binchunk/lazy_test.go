@@ -0,0 +1,114 @@
+package binchunk
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// countReaderAt 统计读取的字节数
+type countReaderAt struct {
+	r io.ReaderAt
+	n int
+}
+
+func (c *countReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n += n
+	return n, err
+}
+
+func TestLazyChunk(t *testing.T) {
+	data, err := ioutil.ReadFile("binchunk_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Undump(bytes.NewReader(data))
+	chunk, err := NewLazyChunk(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := chunk.Main().LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() = %+v, want %+v", got, want)
+	}
+}
+
+func TestLazyChunkOnDemand(t *testing.T) {
+	p := diffTestPrototype()
+	p.Protos[0].Source = "@other.lua"
+	p.Protos = append(p.Protos, &Prototype{Version: LUAC_VERSION, Source: p.Source, LineDefined: 9,
+		Code: p.Code, Constants: []interface{}{"a long string constant that is never loaded"}})
+	var buf bytes.Buffer
+	if err := Dump(&buf, p, false); err != nil {
+		t.Fatal(err)
+	}
+	want := Undump(bytes.NewReader(buf.Bytes()))
+
+	r := &countReaderAt{r: bytes.NewReader(buf.Bytes())}
+	chunk, err := NewLazyChunk(r, int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := chunk.Main()
+	if main.NumProtos() != len(want.Protos) {
+		t.Fatalf("NumProtos() = %d, want %d", main.NumProtos(), len(want.Protos))
+	}
+	for i, sub := range want.Protos {
+		lp := main.Proto(i)
+		if lp.Source() != sub.Source || lp.LineDefined() != sub.LineDefined || lp.LastLineDefined() != sub.LastLineDefined {
+			t.Errorf("proto %d: got %q:%d-%d, want %q:%d-%d", i, lp.Source(), lp.LineDefined(), lp.LastLineDefined(),
+				sub.Source, sub.LineDefined, sub.LastLineDefined)
+		}
+	}
+
+	r.n = 0
+	sub, err := main.Proto(0).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.n >= buf.Len() {
+		t.Errorf("loading one function read %d bytes of %d", r.n, buf.Len())
+	}
+	wantSub := *want.Protos[0]
+	wantSub.Protos = nil
+	if !reflect.DeepEqual(sub, &wantSub) {
+		t.Errorf("Load() = %+v, want %+v", sub, &wantSub)
+	}
+
+	// 第二次调用使用缓存的结果
+	r.n = 0
+	if again, _ := main.Proto(0).Load(); again != sub || r.n != 0 {
+		t.Errorf("second Load() read %d bytes and returned %p, want cached %p", r.n, again, sub)
+	}
+}
+
+func TestLazyChunkErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Dump(&buf, diffTestPrototype(), false); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	for _, n := range []int{0, 10, len(data) / 2, len(data) - 1} {
+		if _, err := NewLazyChunk(bytes.NewReader(data[:n]), int64(n)); err == nil {
+			t.Errorf("truncated to %d bytes: no error", n)
+		}
+	}
+
+	p := diffTestPrototype()
+	p.Version = LUAC_VERSION_53
+	chunk53, err := ioutil.ReadAll(newChunkBuilder(LUAC_VERSION_53).build(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewLazyChunk(bytes.NewReader(chunk53), int64(len(chunk53)))
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Lua 5.3 chunk: got %v, want ErrUnsupportedVersion", err)
+	}
+}
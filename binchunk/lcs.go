@@ -0,0 +1,87 @@
+package binchunk
+
+// pair 是最长公共子序列中对齐的两个位置
+type pair struct{ i, j int }
+
+// matchPairs 返回长度为n和m的两个序列的一个最长公共子序列，按照位置排列，
+// eq(i, j)判断第一个序列的第i项和第二个序列的第j项是否相同。
+// 使用Myers算法的线性空间版本，时间为O((n+m)D)，D是两个序列的差异数量，
+// 空间为O(n+m)，可以比较很长的指令表
+func matchPairs(n, m int, eq func(i, j int) bool) []pair {
+	var pairs []pair
+	var match func(a0, a1, b0, b1 int)
+	match = func(a0, a1, b0, b1 int) {
+		for a0 < a1 && b0 < b1 && eq(a0, b0) {
+			pairs = append(pairs, pair{a0, b0})
+			a0++
+			b0++
+		}
+		suffix := 0
+		for a0 < a1 && b0 < b1 && eq(a1-1, b1-1) {
+			a1--
+			b1--
+			suffix++
+		}
+		if a0 < a1 && b0 < b1 {
+			x, y := middleSnake(a0, a1, b0, b1, eq)
+			match(a0, x, b0, y)
+			match(x, a1, y, b1)
+		}
+		for k := 0; k < suffix; k++ {
+			pairs = append(pairs, pair{a1 + k, b1 + k})
+		}
+	}
+	match(0, n, 0, m)
+	return pairs
+}
+
+// middleSnake 同时从两端搜索最短编辑路径，返回路径中间的一个点，
+// 调用者保证两个序列都不为空，并且首尾的项都不相同，所以返回的点不会是两端
+func middleSnake(a0, a1, b0, b1 int, eq func(i, j int) bool) (int, int) {
+	n, m := a1-a0, b1-b0
+	delta := n - m
+	odd := delta&1 != 0
+	max := (n + m + 1) / 2
+	off := max + 1
+	// vf[off+k]是从起点出发、在对角线k(x-y=k)上走到的最远的x，
+	// vb[off+k]是从终点反向出发、在反向的对角线k上走到的最远的距离
+	vf := make([]int, 2*off+1)
+	vb := make([]int, 2*off+1)
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && vf[off+k-1] < vf[off+k+1] {
+				x = vf[off+k+1]
+			} else {
+				x = vf[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && eq(a0+x, b0+y) {
+				x++
+				y++
+			}
+			vf[off+k] = x
+			if kb := delta - k; odd && kb >= -(d-1) && kb <= d-1 && x+vb[off+kb] >= n {
+				return a0 + x, b0 + y
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && vb[off+k-1] < vb[off+k+1] {
+				x = vb[off+k+1]
+			} else {
+				x = vb[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && eq(a1-1-x, b1-1-y) {
+				x++
+				y++
+			}
+			vb[off+k] = x
+			if kf := delta - k; !odd && kf >= -d && kf <= d && x+vf[off+kf] >= n {
+				return a1 - x, b1 - y
+			}
+		}
+	}
+	panic("middleSnake: no overlap")
+}
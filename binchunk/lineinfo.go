@@ -0,0 +1,90 @@
+package binchunk
+
+// Lua5.4中行号信息的相关常量
+const (
+	ABSLINEINFO = -0x80 // LineInfo中的标记，表示该指令的行号记录在AbsLineInfo中
+	MAXIWTHABS  = 128   // 两个绝对行号之间最多的指令数量
+)
+
+// LineForPC 返回第pc条指令（从0开始计数）对应的源代码行号，
+// 没有调试信息或pc越界时返回-1
+//
+// Lua5.4中LineInfo记录每条指令相对前一条指令的行号增量（有符号字节），
+// 增量无法用一个字节表示或者距离上一个绝对行号超过 MAXIWTHABS 条指令时，
+// 在AbsLineInfo中记录绝对行号，算法与Lua5.4的luaG_getfuncline相同：
+// 先找到不超过pc的最近的绝对行号，再依次累加之后的行号增量。
+// Lua5.1~5.3的函数原型只有Lines，根据填充的是哪个表选择算法，不依赖Version，
+// 这样手工构造的函数原型即使没有设置Version也能得到正确的行号
+func (p *Prototype) LineForPC(pc int) int {
+	if len(p.LineInfo) == 0 && len(p.Lines) > 0 {
+		if pc < 0 || pc >= len(p.Lines) {
+			return -1
+		}
+		return p.Lines[pc]
+	}
+	if pc < 0 || pc >= len(p.LineInfo) {
+		return -1
+	}
+	basePC, baseLine := p.baseLine(pc)
+	for basePC++; basePC <= pc; basePC++ {
+		baseLine += int(int8(p.LineInfo[basePC]))
+	}
+	return baseLine
+}
+
+// baseLine 返回不超过pc的最近的绝对行号所在的指令位置及其行号，
+// 没有这样的绝对行号时返回-1和函数定义的行号
+func (p *Prototype) baseLine(pc int) (int, int) {
+	abs := p.AbsLineInfo
+	if len(abs) == 0 || pc < abs[0].Pc {
+		return -1, p.LineDefined
+	}
+	// 每 MAXIWTHABS 条指令至少有一个绝对行号，据此估计一个下界，
+	// 被篡改的chunk可能不满足这个条件，因此需要把估计值修正到合法范围
+	i := pc/MAXIWTHABS - 1
+	if i >= len(abs) {
+		i = len(abs) - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	for i > 0 && abs[i].Pc > pc {
+		i--
+	}
+	for i+1 < len(abs) && pc >= abs[i+1].Pc {
+		i++
+	}
+	return abs[i].Pc, abs[i].Line
+}
+
+// PCsForLine 返回对应源代码第line行的所有指令位置（从0开始计数），按升序排列
+func (p *Prototype) PCsForLine(line int) []int {
+	var pcs []int
+	for pc := range p.Code {
+		if p.LineForPC(pc) == line {
+			pcs = append(pcs, pc)
+		}
+	}
+	return pcs
+}
+
+// SetLines 根据每条指令对应的行号生成LineInfo和AbsLineInfo，
+// 算法与Lua5.4中luaK的savelineinfo相同：行号增量超出一个字节，
+// 或者距离上一个绝对行号已有 MAXIWTHABS 条指令时记录绝对行号
+func (p *Prototype) SetLines(lines []int) {
+	const limLineDiff = 0x80
+	p.LineInfo = make([]byte, len(lines))
+	p.AbsLineInfo = nil
+	previous, withAbs := p.LineDefined, 0
+	for pc, line := range lines {
+		diff := line - previous
+		if diff >= limLineDiff || diff <= -limLineDiff || withAbs >= MAXIWTHABS {
+			p.AbsLineInfo = append(p.AbsLineInfo, AbsLineInfo{Pc: pc, Line: line})
+			diff = ABSLINEINFO
+			withAbs = 0
+		}
+		withAbs++
+		p.LineInfo[pc] = byte(int8(diff))
+		previous = line
+	}
+}
@@ -0,0 +1,95 @@
+package binchunk
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLineForPC(t *testing.T) {
+	var lines []int
+	line := 10
+	for pc := 0; pc < 1000; pc++ {
+		switch {
+		case pc%97 == 0:
+			line += 300 // 超出一个字节的增量
+		case pc%53 == 0:
+			line -= 200
+		case pc%3 == 0:
+			line++
+		case pc%11 == 0:
+			line -= 2
+		}
+		lines = append(lines, line)
+	}
+	p := &Prototype{Version: LUAC_VERSION, LineDefined: 10, Code: make([]uint32, len(lines))}
+	p.SetLines(lines)
+	if len(p.AbsLineInfo) < len(lines)/MAXIWTHABS {
+		t.Fatalf("only %d absolute line infos", len(p.AbsLineInfo))
+	}
+	for pc, want := range lines {
+		if got := p.LineForPC(pc); got != want {
+			t.Fatalf("LineForPC(%d) = %d, want %d", pc, got, want)
+		}
+	}
+	for _, pc := range []int{-1, len(lines)} {
+		if got := p.LineForPC(pc); got != -1 {
+			t.Errorf("LineForPC(%d) = %d, want -1", pc, got)
+		}
+	}
+	for _, want := range []int{lines[0], lines[500], lines[len(lines)-1]} {
+		var pcs []int
+		for pc, line := range lines {
+			if line == want {
+				pcs = append(pcs, pc)
+			}
+		}
+		if got := p.PCsForLine(want); !reflect.DeepEqual(got, pcs) {
+			t.Errorf("PCsForLine(%d) = %v, want %v", want, got, pcs)
+		}
+	}
+	if got := p.PCsForLine(1 << 20); got != nil {
+		t.Errorf("PCsForLine(1<<20) = %v, want nil", got)
+	}
+}
+
+// TestLineForPCNoVersion 手工构造、没有设置Version的Lua5.4函数原型
+func TestLineForPCNoVersion(t *testing.T) {
+	p := &Prototype{LineDefined: 3, Code: make([]uint32, 3)}
+	p.SetLines([]int{4, 200, 5})
+	for pc, want := range []int{4, 200, 5} {
+		if got := p.LineForPC(pc); got != want {
+			t.Errorf("LineForPC(%d) = %d, want %d", pc, got, want)
+		}
+	}
+}
+
+func TestLineForPCUndump(t *testing.T) {
+	file, err := os.Open("binchunk_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	proto := Undump(file)
+	for pc := range proto.Code {
+		if got := proto.LineForPC(pc); got != 1 {
+			t.Errorf("LineForPC(%d) = %d, want 1", pc, got)
+		}
+	}
+	if got := proto.PCsForLine(1); len(got) != len(proto.Code) {
+		t.Errorf("PCsForLine(1) = %v, want all %d instructions", got, len(proto.Code))
+	}
+}
+
+func TestLineForPCStripped(t *testing.T) {
+	p := &Prototype{Version: LUAC_VERSION, Code: []uint32{0, 0}}
+	if got := p.LineForPC(0); got != -1 {
+		t.Errorf("LineForPC(0) = %d, want -1", got)
+	}
+	old := testPrototype(LUAC_VERSION_53)
+	for pc, want := range old.Lines {
+		if got := old.LineForPC(pc); got != want {
+			t.Errorf("Lua 5.3 LineForPC(%d) = %d, want %d", pc, got, want)
+		}
+	}
+}
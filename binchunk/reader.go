@@ -2,6 +2,7 @@ package binchunk
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"io"
 	"math"
@@ -10,6 +11,18 @@ import (
 
 type LuaReader struct {
 	*bufio.Reader
+	version byte // 二进制chunk的版本号，由checkHeader设置
+
+	// 字节序以及lua_Integer和lua_Number的大小，由头部给出
+	// 嵌入式平台上的luac可能使用大端序和4字节的整数与浮点数
+	order       binary.ByteOrder
+	integerSize uint
+	numberSize  uint
+	integral    bool // Lua5.1和Lua5.2中lua_Number是否是整数类型
+
+	// Lua5.1~5.3中int和size_t以固定长度存储，长度由头部给出
+	intSize   uint
+	sizetSize uint
 }
 
 var (
@@ -18,14 +31,35 @@ var (
 )
 
 func NewLuaReader(reader io.Reader) *LuaReader {
-	return &LuaReader{bufio.NewReader(reader)}
+	return &LuaReader{
+		Reader:      bufio.NewReader(reader),
+		version:     LUAC_VERSION,
+		order:       litterEndian,
+		integerSize: LUA_INTEGER_SIZE,
+		numberSize:  LUA_NUMBER_SIZE,
+	}
 }
+
+// maxPrealloc 按照chunk中记录的长度预先分配的最大元素个数，
+// 更长的表和字符串随着读取逐步扩容，伪造的长度不会导致分配大量内存
+const maxPrealloc = 256
+
 func (r *LuaReader) loadBytes(n uint) []byte {
-	buf := make([]byte, n)
-	if cnt, err := r.Read(buf); err != nil || uint(cnt) != n {
+	if n <= maxPrealloc {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			panic(err)
+		}
+		return buf
+	}
+	var buf bytes.Buffer
+	if int64(n) < 0 {
+		panic("string too long")
+	}
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
 		panic(err)
 	}
-	return buf
+	return buf.Bytes()
 }
 
 func (r *LuaReader) loadByte() byte {
@@ -35,18 +69,18 @@ func (r *LuaReader) loadByte() byte {
 	}
 	return b
 }
-func (r *LuaReader) loadUint64() uint64 {
-	return litterEndian.Uint64(r.loadBytes(LUA_INTEGER_SIZE))
-}
 func (r *LuaReader) loadUint32() uint32 {
-	return litterEndian.Uint32(r.loadBytes(uint(unsafe.Sizeof(uint32(0)))))
+	return r.order.Uint32(r.loadBytes(uint(unsafe.Sizeof(uint32(0)))))
 }
 func (r *LuaReader) loadLuaInteger() int64 {
-	return int64(r.loadUint64())
+	return signExtend(r.loadFixed(r.integerSize), r.integerSize)
 }
 
 func (r *LuaReader) loadLuaNumber() float64 {
-	return math.Float64frombits(r.loadUint64())
+	if r.numberSize == 4 {
+		return float64(math.Float32frombits(uint32(r.loadFixed(4))))
+	}
+	return math.Float64frombits(r.loadFixed(r.numberSize))
 }
 
 // Lua5.4 引入LEB128编码的变种，使用大端序
@@ -69,16 +103,78 @@ func (r *LuaReader) loadUnsigned(limit uint) uint {
 	}
 	return x
 }
+
+// loadFixed 按照头部给出的字节序读取以固定长度n存储的整数
+func (r *LuaReader) loadFixed(n uint) uint64 {
+	return decodeFixed(r.order, r.loadBytes(n))
+}
+
+// decodeFixed 按照字节序order解码4字节或8字节的整数
+func decodeFixed(order binary.ByteOrder, buf []byte) uint64 {
+	switch len(buf) {
+	case 4:
+		return uint64(order.Uint32(buf))
+	case 8:
+		return order.Uint64(buf)
+	default:
+		panic("unsupported integer size")
+	}
+}
+
+// signExtend 将n字节的整数符号扩展为int64
+func signExtend(x uint64, n uint) int64 {
+	if n == 4 {
+		return int64(int32(x))
+	}
+	return int64(x)
+}
+
+// loadCount 读取表的长度，返回长度和预先分配的容量
+func (r *LuaReader) loadCount() (int, int) {
+	n := r.loadInt()
+	if n < 0 {
+		panic("negative count")
+	}
+	if n > maxPrealloc {
+		return n, maxPrealloc
+	}
+	return n, n
+}
+
 func (r *LuaReader) loadInt() int {
+	if r.version != LUAC_VERSION_54 {
+		return int(signExtend(r.loadFixed(r.intSize), r.intSize))
+	}
 	return int(r.loadUnsigned(math.MaxInt))
 }
+
+// loadSize 读取字符串长度
+// Lua5.4使用变长编码，Lua5.3中小于0xFF的长度只占一个字节，Lua5.1和Lua5.2使用size_t
+func (r *LuaReader) loadSize() uint {
+	switch r.version {
+	case LUAC_VERSION_54:
+		return r.loadUnsigned(math.MaxInt)
+	case LUAC_VERSION_53:
+		if size := r.loadByte(); size != 0xFF {
+			return uint(size)
+		}
+	}
+	return uint(r.loadFixed(r.sizetSize))
+}
+
 func (r *LuaReader) loadString() string {
 	var res string
-	size := r.loadUnsigned(math.MaxInt)
+	size := r.loadSize()
 	if size == 0 {
 		return res
 	}
 	size--
+	// Lua5.1和Lua5.2中字符串以'\0'结尾，长度中包含了'\0'
+	if r.version <= LUAC_VERSION_52 {
+		res = string(r.loadBytes(size))
+		r.loadByte()
+		return res
+	}
 	if size <= LUAI_MAXSHORTLEN { // short string
 		res = string(r.loadBytes(size))
 	} else { // long string
@@ -88,89 +184,106 @@ func (r *LuaReader) loadString() string {
 }
 
 func (r *LuaReader) loadCode() []uint32 {
-	code := make([]uint32, r.loadInt())
-	for i := range code {
-		code[i] = r.loadUint32()
+	n, c := r.loadCount()
+	code := make([]uint32, 0, c)
+	for i := 0; i < n; i++ {
+		code = append(code, r.loadUint32())
 	}
 	return code
 }
 
 func (r *LuaReader) loadConstants() []interface{} {
-	constants := make([]interface{}, r.loadInt())
-	for i := 0; i < len(constants); i++ {
-		tag := r.loadByte()
-		switch tag {
-		case TAG_NIL:
-			constants[i] = nil
-		case TAG_FALSE:
-			constants[i] = false
-		case TAG_TRUE:
-			constants[i] = true
-		case TAG_NUMBER:
-			constants[i] = r.loadLuaNumber()
-		case TAG_INTERER:
-			constants[i] = r.loadLuaInteger()
-		case TAG_SHORT_STR, TAG_LONG_STR:
-			constants[i] = r.loadString()
-		default:
-			panic("unknown tag")
-		}
+	n, c := r.loadCount()
+	constants := make([]interface{}, 0, c)
+	for i := 0; i < n; i++ {
+		constants = append(constants, r.loadConstant(r.loadByte()))
 	}
 	return constants
 }
 
+// loadConstant 读取标记为tag的常量
+func (r *LuaReader) loadConstant(tag byte) interface{} {
+	switch r.version {
+	case LUAC_VERSION_51, LUAC_VERSION_52:
+		return r.loadConstant51(tag)
+	case LUAC_VERSION_53:
+		return r.loadConstant53(tag)
+	}
+	switch tag {
+	case TAG_NIL:
+		return nil
+	case TAG_FALSE:
+		return false
+	case TAG_TRUE:
+		return true
+	case TAG_NUMFLT:
+		return r.loadLuaNumber()
+	case TAG_NUMINT:
+		return r.loadLuaInteger()
+	case TAG_SHORT_STR, TAG_LONG_STR:
+		return r.loadString()
+	default:
+		panic("unknown tag")
+	}
+}
+
 func (r *LuaReader) loadUpvalues() []Upvalue {
-	upvalues := make([]Upvalue, r.loadInt())
-	for i := range upvalues {
-		upvalues[i] = Upvalue{
+	n, c := r.loadCount()
+	upvalues := make([]Upvalue, 0, c)
+	for i := 0; i < n; i++ {
+		upvalue := Upvalue{
 			Instack: r.loadByte(),
 			Idx:     r.loadByte(),
-			Kind:    r.loadByte(),
 		}
+		// Lua5.4才有upvalue的类型
+		if r.version == LUAC_VERSION_54 {
+			upvalue.Kind = r.loadByte()
+		}
+		upvalues = append(upvalues, upvalue)
 	}
 	return upvalues
 }
 
 func (r *LuaReader) loadProtos(parentSource string) []*Prototype {
-	protos := make([]*Prototype, r.loadInt())
-	for i := range protos {
-		protos[i] = r.loadProto(parentSource)
+	n, c := r.loadCount()
+	protos := make([]*Prototype, 0, c)
+	for i := 0; i < n; i++ {
+		protos = append(protos, r.loadProto(parentSource))
 	}
 	return protos
 }
 
 func (r *LuaReader) loadLineInfo() []byte {
-	lineInfo := make([]byte, r.loadInt())
-	for i := 0; i < len(lineInfo); i++ {
-		lineInfo[i] = r.loadByte()
-	}
-	return lineInfo
+	n, _ := r.loadCount()
+	return r.loadBytes(uint(n))
 }
 func (r *LuaReader) loadAbsLineInfo() []AbsLineInfo {
-	lineInfo := make([]AbsLineInfo, r.loadInt())
-	for i := range lineInfo {
-		lineInfo[i].Line = r.loadInt()
-		lineInfo[i].Pc = r.loadInt()
+	n, c := r.loadCount()
+	lineInfo := make([]AbsLineInfo, 0, c)
+	for i := 0; i < n; i++ {
+		lineInfo = append(lineInfo, AbsLineInfo{Pc: r.loadInt(), Line: r.loadInt()})
 	}
 	return lineInfo
 }
 
 func (r *LuaReader) loadLocVars() []LocVar {
-	locVars := make([]LocVar, r.loadInt())
-	for i := range locVars {
-		locVars[i] = LocVar{
+	n, c := r.loadCount()
+	locVars := make([]LocVar, 0, c)
+	for i := 0; i < n; i++ {
+		locVars = append(locVars, LocVar{
 			VarName: r.loadString(),
 			StartPC: r.loadInt(),
 			EndPC:   r.loadInt(),
-		}
+		})
 	}
 	return locVars
 }
 
 func (r *LuaReader) loadUpvalueNames() []string {
-	names := make([]string, r.loadInt())
-	for i := range names {
-		names[i] = r.loadString()
+	n, c := r.loadCount()
+	names := make([]string, 0, c)
+	for i := 0; i < n; i++ {
+		names = append(names, r.loadString())
 	}
 	return names
 }
@@ -0,0 +1,182 @@
+package binchunk
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/depressi0n/myLua/vm"
+)
+
+// Stats 统计函数原型树的内容，字节数按照 Dump 输出的Lua5.4格式计算
+type Stats struct {
+	TotalBytes    int // 整个二进制chunk的大小，包括头部
+	HeaderBytes   int
+	CodeBytes     int // 所有指令表的大小
+	ConstantBytes int // 所有常量表的大小
+	DebugBytes    int // 调试信息的大小，即luac -s可以节省的字节数
+
+	Functions        []FunctionStats
+	Opcodes          []OpcodeCount            // 按照数量从多到少排列
+	Constants        map[string]*ConstantStat // 按照类型统计常量，类型名称为"nil"，"boolean"，"integer"，"float"和"string"
+	DuplicateStrings []DuplicateString        // 出现在多个函数常量表中的字符串，按照浪费的字节数从多到少排列
+}
+
+// FunctionStats 描述一个函数原型
+type FunctionStats struct {
+	Path         string // 函数在原型树中的路径，与 Difference 的Func相同
+	Source       string
+	LineDefined  int
+	Instructions int
+	Constants    int
+	MaxStackSize int
+	CodeBytes    int
+	DebugBytes   int
+}
+
+type OpcodeCount struct {
+	Name  string
+	Count int
+}
+
+type ConstantStat struct {
+	Count int
+	Bytes int // 常量在常量表中占用的字节数，包括类型标记
+}
+
+// DuplicateString 描述在多个常量表中重复存储的字符串
+type DuplicateString struct {
+	Value       string
+	Count       int // 出现的次数
+	WastedBytes int // 重复存储多占用的字节数
+}
+
+// countWriter 只统计写入的字节数
+type countWriter int
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	*c += countWriter(len(p))
+	return len(p), nil
+}
+
+// dumpSize 返回dump输出的字节数
+func dumpSize(dump func(w *LuaWriter)) int {
+	var n countWriter
+	w := NewLuaWriter(&n, false)
+	dump(w)
+	w.Flush()
+	return int(n)
+}
+
+// CollectStats 统计Lua5.4的函数原型树
+func CollectStats(proto *Prototype) (stats *Stats, err error) {
+	if err := proto.CheckExecutable(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(dumpError)
+			if !ok {
+				panic(r)
+			}
+			stats, err = nil, e.err
+		}
+	}()
+	stats = &Stats{Constants: map[string]*ConstantStat{}}
+	stats.HeaderBytes = dumpSize(func(w *LuaWriter) {
+		w.dumpHeader()
+		w.dumpByte(byte(len(proto.Upvalues)))
+	})
+	stats.TotalBytes = stats.HeaderBytes + dumpSize(func(w *LuaWriter) { w.dumpProto(proto, "") })
+
+	opcodes := map[string]int{}
+	stringCounts := map[string]int{} // 字符串常量出现的次数
+	var walk func(p *Prototype, path, parentSource string)
+	walk = func(p *Prototype, path, parentSource string) {
+		fs := FunctionStats{
+			Path:         path,
+			Source:       p.Source,
+			LineDefined:  p.LineDefined,
+			Instructions: len(p.Code),
+			Constants:    len(p.Constants),
+			MaxStackSize: int(p.MaxStackSize),
+			CodeBytes:    dumpSize(func(w *LuaWriter) { w.dumpCode(p.Code) }),
+			DebugBytes: dumpSize(func(w *LuaWriter) { w.dumpDebug(p) }) -
+				dumpSize(func(w *LuaWriter) { w.strip = true; w.dumpDebug(p) }),
+		}
+		// 与外围函数相同的源文件名不会重复输出
+		if p.Source != parentSource {
+			fs.DebugBytes += dumpSize(func(w *LuaWriter) { w.dumpString(p.Source, false) }) - 1
+		}
+		stats.Functions = append(stats.Functions, fs)
+		stats.CodeBytes += fs.CodeBytes
+		stats.DebugBytes += fs.DebugBytes
+		stats.ConstantBytes += dumpSize(func(w *LuaWriter) { w.dumpConstants(p.Constants) })
+
+		for _, c := range p.Code {
+			i := vm.Instruction(c)
+			if i.Opcode() < vm.NUM_OPCODES {
+				opcodes[i.OpName()]++
+			} else {
+				opcodes[fmt.Sprintf("OP_%d", i.Opcode())]++
+			}
+		}
+		for _, c := range p.Constants {
+			typ := constantType(c)
+			stat := stats.Constants[typ]
+			if stat == nil {
+				stat = &ConstantStat{}
+				stats.Constants[typ] = stat
+			}
+			stat.Count++
+			stat.Bytes += dumpSize(func(w *LuaWriter) { w.dumpConstants([]interface{}{c}) }) - 1
+			if s, ok := c.(string); ok {
+				stringCounts[s]++
+			}
+		}
+		for i, sub := range p.Protos {
+			walk(sub, fmt.Sprintf("%s/%d", path, i+1), p.Source)
+		}
+	}
+	walk(proto, "main", "")
+
+	for name, count := range opcodes {
+		stats.Opcodes = append(stats.Opcodes, OpcodeCount{name, count})
+	}
+	sort.Slice(stats.Opcodes, func(i, j int) bool {
+		a, b := stats.Opcodes[i], stats.Opcodes[j]
+		return a.Count > b.Count || a.Count == b.Count && a.Name < b.Name
+	})
+	for s, count := range stringCounts {
+		if count < 2 {
+			continue
+		}
+		size := dumpSize(func(w *LuaWriter) { w.dumpConstants([]interface{}{s}) }) - 1
+		stats.DuplicateStrings = append(stats.DuplicateStrings, DuplicateString{
+			Value:       s,
+			Count:       count,
+			WastedBytes: (count - 1) * size,
+		})
+	}
+	sort.Slice(stats.DuplicateStrings, func(i, j int) bool {
+		a, b := stats.DuplicateStrings[i], stats.DuplicateStrings[j]
+		return a.WastedBytes > b.WastedBytes || a.WastedBytes == b.WastedBytes && a.Value < b.Value
+	})
+	return stats, nil
+}
+
+func constantType(constant interface{}) string {
+	switch constant.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case int64:
+		return "integer"
+	case float64:
+		return "float"
+	case string:
+		return "string"
+	default:
+		return fmt.Sprintf("%T", constant)
+	}
+}
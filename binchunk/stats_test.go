@@ -0,0 +1,71 @@
+package binchunk
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/depressi0n/myLua/vm"
+)
+
+func TestCollectStats(t *testing.T) {
+	p := diffTestPrototype()
+	p.Constants = []interface{}{"print", int64(1), 2.5, true}
+	p.Protos[0].Constants = []interface{}{"print", "x"}
+	p.Protos[0].Source = "@b.lua"
+	p.Protos = append(p.Protos, &Prototype{
+		Version:      LUAC_VERSION,
+		Source:       p.Source,
+		MaxStackSize: 9,
+		Code:         []uint32{iABC(vm.OP_MOVE, 0, 0, 1, 0), iABC(vm.OP_RETURN0, 0, 0, 0, 0)},
+		Constants:    []interface{}{"print"},
+	})
+	stats, err := CollectStats(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var full, stripped bytes.Buffer
+	if err := Dump(&full, p, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := Dump(&stripped, p, true); err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalBytes != full.Len() {
+		t.Errorf("TotalBytes = %d, want %d", stats.TotalBytes, full.Len())
+	}
+	if stats.TotalBytes-stats.DebugBytes != stripped.Len() {
+		t.Errorf("DebugBytes = %d, want %d", stats.DebugBytes, full.Len()-stripped.Len())
+	}
+	if stats.CodeBytes != 4*(3+1+2)+3 {
+		t.Errorf("CodeBytes = %d", stats.CodeBytes)
+	}
+
+	wantOpcodes := []OpcodeCount{{"RETURN0", 3}, {"MOVE", 2}, {"LOADK", 1}}
+	if !reflect.DeepEqual(stats.Opcodes, wantOpcodes) {
+		t.Errorf("Opcodes = %v, want %v", stats.Opcodes, wantOpcodes)
+	}
+	wantConstants := map[string]*ConstantStat{
+		"string":  {Count: 4, Bytes: 3*7 + 3},
+		"integer": {Count: 1, Bytes: 9},
+		"float":   {Count: 1, Bytes: 9},
+		"boolean": {Count: 1, Bytes: 1},
+	}
+	for typ, want := range wantConstants {
+		if got := stats.Constants[typ]; got == nil || *got != *want {
+			t.Errorf("Constants[%s] = %v, want %v", typ, got, want)
+		}
+	}
+	wantDuplicates := []DuplicateString{{Value: "print", Count: 3, WastedBytes: 2 * 7}}
+	if !reflect.DeepEqual(stats.DuplicateStrings, wantDuplicates) {
+		t.Errorf("DuplicateStrings = %v, want %v", stats.DuplicateStrings, wantDuplicates)
+	}
+	var paths []string
+	for _, f := range stats.Functions {
+		paths = append(paths, f.Path)
+	}
+	if !reflect.DeepEqual(paths, []string{"main", "main/1", "main/2"}) || stats.Functions[2].MaxStackSize != 9 {
+		t.Errorf("Functions = %+v", stats.Functions)
+	}
+}
@@ -1,13 +1,24 @@
 package binchunk
 
+import "fmt"
+
 func (r *LuaReader) checkHeader() {
 	// signature [4]byte // 魔数，快速识别文件格式，0x1B4C7561
 	if string(r.loadBytes(uint(len(LUA_SIGNATURE)))) != LUA_SIGNATURE {
 		panic("unmatched signature")
 	}
 	//	version   byte    // 大版本号，小版本号，发布号
-	if r.loadByte() != LUAC_VERSION {
-		panic("unmatched version")
+	r.version = r.loadByte()
+	switch r.version {
+	case LUAC_VERSION_54:
+	case LUAC_VERSION_53:
+		r.checkHeader53()
+		return
+	case LUAC_VERSION_51, LUAC_VERSION_52:
+		r.checkHeader51()
+		return
+	default:
+		panic(fmt.Sprintf("unsupported version 0x%02x", r.version))
 	}
 	//	format    byte    // 格式号
 	if r.loadByte() != LUAC_FORMAT {
@@ -21,16 +32,30 @@ func (r *LuaReader) checkHeader() {
 	if r.loadByte() != INSTRUCTION_SIZE {
 		panic("unmatched instruction size")
 	}
+	r.checkSizesAndProbes()
+}
+
+// checkSizesAndProbes 读取Lua5.3和Lua5.4头部中lua_Integer和lua_Number的大小，
+// 并根据luacInt确定字节序，再用luacNum检查浮点数格式
+func (r *LuaReader) checkSizesAndProbes() {
 	//	luaIntegerSize byte    // 8
-	if r.loadByte() != LUA_INTEGER_SIZE {
+	r.integerSize = uint(r.loadByte())
+	if r.integerSize != 4 && r.integerSize != 8 {
 		panic("unmatched Lua_Integer size")
 	}
 	//	luaNumberSize  byte    // 8
-	if r.loadByte() != LUA_NUMBER_SIZE {
+	r.numberSize = uint(r.loadByte())
+	if r.numberSize != 4 && r.numberSize != 8 {
 		panic("unmatched Lua_Number size")
 	}
 	//	luacInt        int64   // 8，0x5678，检查大小端模式
-	if r.loadLuaInteger() != LUAC_INT {
+	luacInt := r.loadBytes(r.integerSize)
+	switch {
+	case signExtend(decodeFixed(litterEndian, luacInt), r.integerSize) == LUAC_INT:
+		r.order = litterEndian
+	case signExtend(decodeFixed(bigEndian, luacInt), r.integerSize) == LUAC_INT:
+		r.order = bigEndian
+	default:
 		panic("unmatched endianness")
 	}
 	//	luacNum        float64 // 8，存储浮点数370.5
@@ -39,11 +64,20 @@ func (r *LuaReader) checkHeader() {
 	}
 }
 func (r *LuaReader) loadProto(parentSource string) *Prototype {
+	switch r.version {
+	case LUAC_VERSION_53:
+		return r.loadProto53(parentSource)
+	case LUAC_VERSION_52:
+		return r.loadProto52(parentSource)
+	case LUAC_VERSION_51:
+		return r.loadProto51(parentSource)
+	}
 	source := r.loadString()
 	if source == "" {
 		source = parentSource
 	}
 	return &Prototype{
+		Version:         r.version,
 		Source:          source,
 		LineDefined:     r.loadInt(),
 		LastLineDefined: r.loadInt(),
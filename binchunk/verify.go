@@ -0,0 +1,333 @@
+package binchunk
+
+import (
+	"fmt"
+
+	"github.com/depressi0n/myLua/vm"
+)
+
+// VerifyError 描述静态检查发现的非法指令，Pc从0开始计数
+type VerifyError struct {
+	Source string // 函数原型所在的源文件名
+	Line   int    // 函数原型定义的起始行号
+	Pc     int    // 非法指令的位置，-1表示问题与具体指令无关
+	OpName string // 非法指令的操作码名称
+	Msg    string
+}
+
+func (e *VerifyError) Error() string {
+	if e.Pc < 0 {
+		return fmt.Sprintf("verify %s:%d: %s", e.Source, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("verify %s:%d: pc %d (%s): %s", e.Source, e.Line, e.Pc+1, e.OpName, e.Msg)
+}
+
+// Verify 在执行前静态检查函数原型及其所有子函数原型，
+// 确保每条指令的操作数都没有超出函数原型的限制：
+// 寄存器索引小于MaxStackSize，常量、upvalue和子函数原型索引不越界，
+// 跳转目标落在指令表内，需要额外参数或跳转的指令后面紧跟相应的指令。
+// 只有Lua5.4的函数原型可以检查，其他版本返回 CheckExecutable 的错误
+func Verify(p *Prototype) error {
+	if err := p.CheckExecutable(); err != nil {
+		return err
+	}
+	return verify(p, nil)
+}
+
+func verify(p, parent *Prototype) error {
+	v := &verifier{proto: p}
+	if err := v.verifyProto(parent); err != nil {
+		return err
+	}
+	for _, sub := range p.Protos {
+		if sub == nil {
+			return v.errorf(-1, "nil sub function prototype")
+		}
+		if err := verify(sub, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type verifier struct {
+	proto *Prototype
+	pc    int
+}
+
+func (v *verifier) errorf(pc int, format string, a ...interface{}) error {
+	err := &VerifyError{
+		Source: v.proto.Source,
+		Line:   v.proto.LineDefined,
+		Pc:     pc,
+		Msg:    fmt.Sprintf(format, a...),
+	}
+	if pc >= 0 {
+		err.OpName = v.opName(pc)
+	}
+	return err
+}
+
+func (v *verifier) opName(pc int) string {
+	i := vm.Instruction(v.proto.Code[pc])
+	if i.Opcode() >= vm.NUM_OPCODES {
+		return fmt.Sprintf("OP_%d", i.Opcode())
+	}
+	return i.OpName()
+}
+
+// verifyProto 检查函数原型本身以及指令表
+func (v *verifier) verifyProto(parent *Prototype) error {
+	p := v.proto
+	if p.NumParams > p.MaxStackSize {
+		return v.errorf(-1, "%d params exceed stack size %d", p.NumParams, p.MaxStackSize)
+	}
+	// 子函数的upvalue要么捕获外围函数的寄存器，要么引用外围函数的upvalue
+	if parent != nil {
+		for idx, u := range p.Upvalues {
+			if u.Instack != 0 && int(u.Idx) >= int(parent.MaxStackSize) {
+				return v.errorf(-1, "upvalue %d refers to register %d beyond enclosing stack size %d",
+					idx, u.Idx, parent.MaxStackSize)
+			}
+			if u.Instack == 0 && int(u.Idx) >= len(parent.Upvalues) {
+				return v.errorf(-1, "upvalue %d refers to enclosing upvalue %d of %d",
+					idx, u.Idx, len(parent.Upvalues))
+			}
+		}
+	}
+	if len(p.Code) == 0 {
+		return v.errorf(-1, "empty code")
+	}
+	for v.pc = range p.Code {
+		if err := v.verifyInstruction(vm.Instruction(p.Code[v.pc])); err != nil {
+			return err
+		}
+	}
+	// 最后一条指令必须是返回指令，否则会执行到指令表之外
+	last := len(p.Code) - 1
+	switch vm.Instruction(p.Code[last]).Opcode() {
+	case vm.OP_RETURN, vm.OP_RETURN0, vm.OP_RETURN1:
+	default:
+		return v.errorf(last, "code does not end with a return")
+	}
+	return nil
+}
+
+func (v *verifier) verifyInstruction(i vm.Instruction) error {
+	op := i.Opcode()
+	if op >= vm.NUM_OPCODES {
+		return v.errorf(v.pc, "invalid opcode %d", op)
+	}
+	if err := v.verifyOperands(i); err != nil {
+		return err
+	}
+	// 测试指令条件不成立时跳过下一条指令，下一条指令必须是JMP
+	if i.Info().IsTest {
+		next := v.pc + 1
+		if next >= len(v.proto.Code) || vm.Instruction(v.proto.Code[next]).Opcode() != vm.OP_JMP {
+			return v.errorf(v.pc, "test instruction not followed by JMP")
+		}
+	}
+	return nil
+}
+
+func (v *verifier) verifyOperands(i vm.Instruction) error {
+	op := i.Opcode()
+	a, k, b, c := i.IABC()
+	switch op {
+	case vm.OP_LOADI, vm.OP_LOADF, vm.OP_LOADFALSE, vm.OP_LOADTRUE,
+		vm.OP_CLOSE, vm.OP_TBC, vm.OP_RETURN1,
+		vm.OP_EQI, vm.OP_LTI, vm.OP_LEI, vm.OP_GTI, vm.OP_GEI, vm.OP_TEST,
+		vm.OP_MMBINI:
+		return v.register(a)
+	case vm.OP_MOVE, vm.OP_GETI, vm.OP_ADDI, vm.OP_SHRI, vm.OP_SHLI,
+		vm.OP_UNM, vm.OP_BNOT, vm.OP_NOT, vm.OP_LEN,
+		vm.OP_EQ, vm.OP_LT, vm.OP_LE, vm.OP_TESTSET, vm.OP_MMBIN:
+		return v.registers(a, b)
+	case vm.OP_LOADK:
+		_, bx := i.IABx()
+		return v.first(v.register(a), v.constant(bx))
+	case vm.OP_LOADKX:
+		return v.first(v.register(a), v.extraArg(true))
+	case vm.OP_LFALSESKIP:
+		return v.first(v.register(a), v.target(v.pc+2))
+	case vm.OP_LOADNIL:
+		return v.registerRange(a, b+1)
+	case vm.OP_GETUPVAL, vm.OP_SETUPVAL:
+		return v.first(v.register(a), v.upvalue(b))
+	case vm.OP_GETTABUP:
+		return v.first(v.register(a), v.upvalue(b), v.constant(c))
+	case vm.OP_GETTABLE, vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD, vm.OP_POW,
+		vm.OP_DIV, vm.OP_IDIV, vm.OP_BAND, vm.OP_BOR, vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR:
+		return v.registers(a, b, c)
+	case vm.OP_GETFIELD, vm.OP_ADDK, vm.OP_SUBK, vm.OP_MULK, vm.OP_MODK, vm.OP_POWK,
+		vm.OP_DIVK, vm.OP_IDIVK, vm.OP_BANDK, vm.OP_BORK, vm.OP_BXORK:
+		return v.first(v.registers(a, b), v.constant(c))
+	case vm.OP_SETTABUP:
+		return v.first(v.upvalue(a), v.constant(b), v.rk(k, c))
+	case vm.OP_SETTABLE:
+		return v.first(v.registers(a, b), v.rk(k, c))
+	case vm.OP_SETI:
+		return v.first(v.register(a), v.rk(k, c))
+	case vm.OP_SETFIELD:
+		return v.first(v.register(a), v.constant(b), v.rk(k, c))
+	case vm.OP_NEWTABLE:
+		// 编译器总是在NEWTABLE之后生成EXTRAARG
+		return v.first(v.register(a), v.extraArg(false))
+	case vm.OP_SELF:
+		return v.first(v.registerRange(a, 2), v.register(b), v.rk(k, c))
+	case vm.OP_MMBINK:
+		return v.first(v.register(a), v.constant(b))
+	case vm.OP_CONCAT:
+		return v.registerRange(a, b)
+	case vm.OP_JMP:
+		return v.jump(i)
+	case vm.OP_EQK:
+		return v.first(v.register(a), v.constant(b))
+	case vm.OP_CALL:
+		return v.first(v.register(a), v.registerRange(a, b), v.registerRange(a, c-1))
+	case vm.OP_TAILCALL:
+		return v.first(v.register(a), v.registerRange(a, b))
+	case vm.OP_RETURN:
+		return v.registerRange(a, b-1)
+	case vm.OP_RETURN0:
+		return nil
+	case vm.OP_FORLOOP, vm.OP_TFORLOOP:
+		n := 4
+		if op == vm.OP_TFORLOOP {
+			n = 5
+		}
+		return v.first(v.registerRange(a, n), v.jump(i))
+	case vm.OP_FORPREP, vm.OP_TFORPREP:
+		return v.first(v.registerRange(a, 4), v.jump(i))
+	case vm.OP_TFORCALL:
+		return v.registerRange(a, 4+c)
+	case vm.OP_SETLIST:
+		return v.first(v.registerRange(a, b+1), v.conditionalExtraArg(k))
+	case vm.OP_CLOSURE:
+		_, bx := i.IABx()
+		return v.first(v.register(a), v.subProto(bx))
+	case vm.OP_VARARG:
+		return v.first(v.register(a), v.registerRange(a, c-1))
+	case vm.OP_VARARGPREP:
+		if v.proto.IsVararg == 0 {
+			return v.errorf(v.pc, "function is not vararg")
+		}
+		return nil
+	case vm.OP_EXTRAARG:
+		return v.orphanExtraArg()
+	}
+	return nil
+}
+
+// first 返回第一个不为nil的错误
+func (v *verifier) first(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *verifier) register(r int) error {
+	if r >= int(v.proto.MaxStackSize) {
+		return v.errorf(v.pc, "register %d out of stack size %d", r, v.proto.MaxStackSize)
+	}
+	return nil
+}
+
+func (v *verifier) registers(rs ...int) error {
+	for _, r := range rs {
+		if err := v.register(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerRange 检查从r开始的n个寄存器，n不大于0时表示数量由栈顶决定，不做检查
+func (v *verifier) registerRange(r, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	return v.register(r + n - 1)
+}
+
+func (v *verifier) constant(idx int) error {
+	if idx >= len(v.proto.Constants) {
+		return v.errorf(v.pc, "constant %d out of %d constants", idx, len(v.proto.Constants))
+	}
+	return nil
+}
+
+// rk 检查RK(x)操作数，k为1时x是常量索引，否则是寄存器索引
+func (v *verifier) rk(k, x int) error {
+	if k != 0 {
+		return v.constant(x)
+	}
+	return v.register(x)
+}
+
+func (v *verifier) upvalue(idx int) error {
+	if idx >= len(v.proto.Upvalues) {
+		return v.errorf(v.pc, "upvalue %d out of %d upvalues", idx, len(v.proto.Upvalues))
+	}
+	return nil
+}
+
+func (v *verifier) subProto(idx int) error {
+	if idx >= len(v.proto.Protos) {
+		return v.errorf(v.pc, "function prototype %d out of %d prototypes", idx, len(v.proto.Protos))
+	}
+	return nil
+}
+
+// target 检查跳转目标是否落在指令表内
+func (v *verifier) target(pc int) error {
+	if pc < 0 || pc >= len(v.proto.Code) {
+		return v.errorf(v.pc, "jump target %d out of code range [1, %d]", pc+1, len(v.proto.Code))
+	}
+	return nil
+}
+
+// jump 检查跳转指令的目标
+func (v *verifier) jump(i vm.Instruction) error {
+	target, _ := i.JumpTarget(v.pc)
+	return v.target(target)
+}
+
+// extraArg 检查下一条指令是否是EXTRAARG，isConstant为true时Ax是常量索引
+func (v *verifier) extraArg(isConstant bool) error {
+	next := v.pc + 1
+	if next >= len(v.proto.Code) || vm.Instruction(v.proto.Code[next]).Opcode() != vm.OP_EXTRAARG {
+		return v.errorf(v.pc, "missing EXTRAARG")
+	}
+	if isConstant {
+		return v.constant(vm.Instruction(v.proto.Code[next]).IAx())
+	}
+	return nil
+}
+
+func (v *verifier) conditionalExtraArg(k int) error {
+	if k == 0 {
+		return nil
+	}
+	return v.extraArg(false)
+}
+
+// orphanExtraArg 检查EXTRAARG是否紧跟在需要额外参数的指令之后
+func (v *verifier) orphanExtraArg() error {
+	if v.pc > 0 {
+		prev := vm.Instruction(v.proto.Code[v.pc-1])
+		switch prev.Opcode() {
+		case vm.OP_LOADKX, vm.OP_NEWTABLE:
+			return nil
+		case vm.OP_SETLIST:
+			if _, k, _, _ := prev.IABC(); k != 0 {
+				return nil
+			}
+		}
+	}
+	return v.errorf(v.pc, "EXTRAARG without a preceding instruction")
+}
@@ -0,0 +1,137 @@
+package binchunk
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/depressi0n/myLua/vm"
+)
+
+func iABC(op, a, k, b, c int) uint32 {
+	return uint32(op | a<<vm.POS_A | k<<vm.POS_k | b<<vm.POS_B | c<<vm.POS_C)
+}
+
+func iABx(op, a, bx int) uint32 {
+	return uint32(op | a<<vm.POS_A | bx<<vm.POS_Bx)
+}
+
+func isJ(op, sj int) uint32 {
+	return uint32(op | (sj+vm.OFFSET_sJ)<<vm.POS_sJ)
+}
+
+func TestVerifyUndump(t *testing.T) {
+	file, err := os.Open("binchunk_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := Verify(Undump(file)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ret := iABC(vm.OP_RETURN0, 0, 0, 0, 0)
+	tests := []struct {
+		name string
+		code []uint32
+		pc   int    // 期望出错的位置，-1表示没有错误
+		msg  string // 期望错误信息中包含的内容
+	}{
+		{"valid", []uint32{
+			iABx(vm.OP_LOADK, 1, 0),
+			iABC(vm.OP_GETTABUP, 0, 0, 0, 0),
+			iABC(vm.OP_EQ, 0, 0, 1, 0),
+			isJ(vm.OP_JMP, 1),
+			iABx(vm.OP_CLOSURE, 0, 0),
+			ret,
+		}, -1, ""},
+		{"register", []uint32{iABC(vm.OP_MOVE, 0, 0, 2, 0), ret}, 0, "register 2"},
+		{"constant", []uint32{iABx(vm.OP_LOADK, 0, 1), ret}, 0, "constant 1"},
+		{"rk constant", []uint32{iABC(vm.OP_SETFIELD, 0, 1, 0, 3), ret}, 0, "constant 3"},
+		{"upvalue", []uint32{iABC(vm.OP_GETUPVAL, 0, 0, 1, 0), ret}, 0, "upvalue 1"},
+		{"proto", []uint32{iABx(vm.OP_CLOSURE, 0, 1), ret}, 0, "prototype 1"},
+		{"jump forward", []uint32{isJ(vm.OP_JMP, 1), ret}, 0, "jump target 3"},
+		{"jump backward", []uint32{ret, isJ(vm.OP_JMP, -3), ret}, 1, "jump target 0"},
+		{"forloop", []uint32{iABC(vm.OP_LOADNIL, 0, 0, 0, 0), iABx(vm.OP_FORLOOP, 0, 1), ret}, 1, "register 3"},
+		{"call range", []uint32{iABC(vm.OP_CALL, 0, 0, 3, 1), ret}, 0, "register 2"},
+		{"loadkx", []uint32{iABx(vm.OP_LOADKX, 0, 0), ret}, 0, "missing EXTRAARG"},
+		{"extraarg", []uint32{iABx(vm.OP_LOADKX, 0, 0), uint32(vm.OP_EXTRAARG | 5<<vm.POS_Ax), ret}, 0, "constant 5"},
+		{"orphan extraarg", []uint32{uint32(vm.OP_EXTRAARG), ret}, 0, "EXTRAARG"},
+		{"test without jump", []uint32{iABC(vm.OP_TEST, 0, 0, 0, 0), ret}, 0, "JMP"},
+		{"invalid opcode", []uint32{uint32(vm.NUM_OPCODES), ret}, 0, "invalid opcode"},
+		{"no return", []uint32{iABC(vm.OP_MOVE, 0, 0, 1, 0)}, 0, "return"},
+	}
+	for _, test := range tests {
+		p := &Prototype{
+			Version:      LUAC_VERSION,
+			Source:       "@test.lua",
+			MaxStackSize: 2,
+			Code:         test.code,
+			Constants:    []interface{}{"x"},
+			Upvalues:     []Upvalue{{Instack: 1}},
+			Protos: []*Prototype{{
+				Version:      LUAC_VERSION,
+				MaxStackSize: 2,
+				Code:         []uint32{ret},
+				Upvalues:     []Upvalue{{Instack: 1, Idx: 1}, {Idx: 0}},
+			}},
+		}
+		err := Verify(p)
+		if test.pc < 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.name, err)
+			}
+			continue
+		}
+		var verr *VerifyError
+		if !errors.As(err, &verr) {
+			t.Errorf("%s: got %v, want VerifyError", test.name, err)
+			continue
+		}
+		if verr.Pc != test.pc || !strings.Contains(verr.Msg, test.msg) {
+			t.Errorf("%s: got pc %d %q, want pc %d %q", test.name, verr.Pc, verr.Msg, test.pc, test.msg)
+		}
+	}
+}
+
+func TestVerifyNested(t *testing.T) {
+	ret := iABC(vm.OP_RETURN0, 0, 0, 0, 0)
+	sub := &Prototype{
+		Version:      LUAC_VERSION,
+		Source:       "@test.lua",
+		LineDefined:  3,
+		MaxStackSize: 1,
+		Code:         []uint32{iABC(vm.OP_GETUPVAL, 0, 0, 0, 0), ret},
+		Upvalues:     []Upvalue{{Instack: 1, Idx: 4}},
+	}
+	p := &Prototype{
+		Version:      LUAC_VERSION,
+		Source:       "@test.lua",
+		MaxStackSize: 2,
+		Code:         []uint32{iABx(vm.OP_CLOSURE, 0, 0), ret},
+		Protos:       []*Prototype{sub},
+	}
+	err := Verify(p)
+	want := "verify @test.lua:3: upvalue 0 refers to register 4 beyond enclosing stack size 2"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+
+	sub.Upvalues[0].Idx = 1
+	sub.Code[0] = iABC(vm.OP_GETUPVAL, 1, 0, 0, 0)
+	err = Verify(p)
+	want = "verify @test.lua:3: pc 1 (GETUPVAL): register 1 out of stack size 1"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+}
+
+func TestVerifyUnsupportedVersion(t *testing.T) {
+	err := Verify(testPrototype(LUAC_VERSION_53))
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("got %v, want ErrUnsupportedVersion", err)
+	}
+}
@@ -0,0 +1,192 @@
+package binchunk
+
+// Lua5.1~5.3的二进制chunk格式
+// 与Lua5.4相比，int和size_t以固定长度存储，行号表直接记录每条指令的行号，
+// 各个部分的顺序也有所不同
+
+// checkHeader51 检查Lua5.1和Lua5.2的头部，版本号之后的部分
+func (r *LuaReader) checkHeader51() {
+	//	format    byte    // 格式号
+	if r.loadByte() != LUAC_FORMAT {
+		panic("unmatched format")
+	}
+	//	endianness byte   // 0表示大端，1表示小端
+	switch r.loadByte() {
+	case LUAC_ENDIANNESS_LITTLE:
+		r.order = litterEndian
+	case LUAC_ENDIANNESS_BIG:
+		r.order = bigEndian
+	default:
+		panic("unmatched endianness")
+	}
+	r.intSize = uint(r.loadByte())
+	r.sizetSize = uint(r.loadByte())
+	if r.loadByte() != INSTRUCTION_SIZE {
+		panic("unmatched instruction size")
+	}
+	r.numberSize = uint(r.loadByte())
+	if r.numberSize != 4 && r.numberSize != 8 {
+		panic("unmatched Lua_Number size")
+	}
+	//	integral  byte    // 0表示lua_Number是浮点数，1表示lua_Number是整数
+	r.integral = r.loadByte() != 0
+	// Lua5.2在头部末尾增加了LUAC_DATA
+	if r.version == LUAC_VERSION_52 && string(r.loadBytes(uint(len(LUAC_DATA)))) != LUAC_DATA {
+		panic("unmatched luac data")
+	}
+}
+
+// checkHeader53 检查Lua5.3的头部，版本号之后的部分
+func (r *LuaReader) checkHeader53() {
+	if r.loadByte() != LUAC_FORMAT {
+		panic("unmatched format")
+	}
+	if string(r.loadBytes(uint(len(LUAC_DATA)))) != LUAC_DATA {
+		panic("unmatched luac data")
+	}
+	r.intSize = uint(r.loadByte())
+	r.sizetSize = uint(r.loadByte())
+	if r.loadByte() != INSTRUCTION_SIZE {
+		panic("unmatched instruction size")
+	}
+	r.checkSizesAndProbes()
+}
+
+// loadConstant51 读取Lua5.1和Lua5.2中标记为tag的常量
+func (r *LuaReader) loadConstant51(tag byte) interface{} {
+	switch tag {
+	case TAG_NIL_51:
+		return nil
+	case TAG_BOOLEAN_51:
+		return r.loadByte() != 0
+	case TAG_NUMBER_51:
+		// 整数类型的lua_Number解析为整数
+		if r.integral {
+			return signExtend(r.loadFixed(r.numberSize), r.numberSize)
+		}
+		return r.loadLuaNumber()
+	case TAG_STRING_51:
+		return r.loadString()
+	default:
+		panic("unknown tag")
+	}
+}
+
+// loadConstant53 读取Lua5.3中标记为tag的常量
+// Lua5.3中布尔值只有一个标记，值单独用一个字节存储
+func (r *LuaReader) loadConstant53(tag byte) interface{} {
+	switch tag {
+	case TAG_NIL:
+		return nil
+	case TAG_BOOLEAN:
+		return r.loadByte() != 0
+	case TAG_NUMBER:
+		return r.loadLuaNumber()
+	case TAG_INTERER:
+		return r.loadLuaInteger()
+	case TAG_SHORT_STR, TAG_LONG_STR:
+		return r.loadString()
+	default:
+		panic("unknown tag")
+	}
+}
+
+// loadLines 读取Lua5.1~5.3中每条指令对应的行号
+func (r *LuaReader) loadLines() []int {
+	n, c := r.loadCount()
+	lines := make([]int, 0, c)
+	for i := 0; i < n; i++ {
+		lines = append(lines, r.loadInt())
+	}
+	return lines
+}
+
+// loadProto53 读取Lua5.3的函数原型
+// 常量表之后依次是upvalue表和子函数原型
+func (r *LuaReader) loadProto53(parentSource string) *Prototype {
+	source := r.loadString()
+	if source == "" {
+		source = parentSource
+	}
+	return &Prototype{
+		Version:         r.version,
+		Source:          source,
+		LineDefined:     r.loadInt(),
+		LastLineDefined: r.loadInt(),
+		NumParams:       r.loadByte(),
+		IsVararg:        r.loadByte(),
+		MaxStackSize:    r.loadByte(),
+		Code:            r.loadCode(),
+		Constants:       r.loadConstants(),
+		Upvalues:        r.loadUpvalues(),
+		Protos:          r.loadProtos(source),
+		// Debug information
+		Lines:        r.loadLines(),
+		LocVars:      r.loadLocVars(),
+		UpvalueNames: r.loadUpvalueNames(),
+	}
+}
+
+// loadProto52 读取Lua5.2的函数原型
+// 子函数原型紧跟在常量表之后，源文件名放在调试信息中
+func (r *LuaReader) loadProto52(parentSource string) *Prototype {
+	proto := &Prototype{
+		Version:         r.version,
+		LineDefined:     r.loadInt(),
+		LastLineDefined: r.loadInt(),
+		NumParams:       r.loadByte(),
+		IsVararg:        r.loadByte(),
+		MaxStackSize:    r.loadByte(),
+		Code:            r.loadCode(),
+		Constants:       r.loadConstants(),
+	}
+	// 子函数的源文件名在其调试信息中，读取之后再补全
+	proto.Protos = r.loadProtos("")
+	proto.Upvalues = r.loadUpvalues()
+	proto.Source = r.loadString()
+	if proto.Source == "" {
+		proto.Source = parentSource
+	}
+	fillSource(proto.Protos, proto.Source)
+	proto.Lines = r.loadLines()
+	proto.LocVars = r.loadLocVars()
+	proto.UpvalueNames = r.loadUpvalueNames()
+	return proto
+}
+
+// loadProto51 读取Lua5.1的函数原型
+// Lua5.1的upvalue由CLOSURE之后的伪指令描述，二进制chunk中只记录了upvalue的数量，
+// 因此Upvalues中只有数量是有意义的
+func (r *LuaReader) loadProto51(parentSource string) *Prototype {
+	source := r.loadString()
+	if source == "" {
+		source = parentSource
+	}
+	return &Prototype{
+		Version:         r.version,
+		Source:          source,
+		LineDefined:     r.loadInt(),
+		LastLineDefined: r.loadInt(),
+		Upvalues:        make([]Upvalue, r.loadByte()),
+		NumParams:       r.loadByte(),
+		IsVararg:        r.loadByte(),
+		MaxStackSize:    r.loadByte(),
+		Code:            r.loadCode(),
+		Constants:       r.loadConstants(),
+		Protos:          r.loadProtos(source),
+		// Debug information
+		Lines:        r.loadLines(),
+		LocVars:      r.loadLocVars(),
+		UpvalueNames: r.loadUpvalueNames(),
+	}
+}
+
+// fillSource 将没有源文件名的函数原型的源文件名设置为source
+func fillSource(protos []*Prototype, source string) {
+	for _, p := range protos {
+		if p.Source == "" {
+			p.Source = source
+			fillSource(p.Protos, source)
+		}
+	}
+}
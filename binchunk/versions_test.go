@@ -0,0 +1,395 @@
+package binchunk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// chunkBuilder 按照指定版本的格式构造二进制chunk，用于生成测试数据
+type chunkBuilder struct {
+	bytes.Buffer
+	version     byte
+	order       binary.ByteOrder
+	integerSize int
+	numberSize  int
+	integral    bool
+	intSize     int
+	sizetSize   int
+}
+
+func newChunkBuilder(version byte) *chunkBuilder {
+	return &chunkBuilder{
+		version:     version,
+		order:       binary.LittleEndian,
+		integerSize: LUA_INTEGER_SIZE,
+		numberSize:  LUA_NUMBER_SIZE,
+		intSize:     4,
+		sizetSize:   8,
+	}
+}
+
+func (b *chunkBuilder) fixed(x uint64, size int) {
+	buf := make([]byte, size)
+	if size == 4 {
+		b.order.PutUint32(buf, uint32(x))
+	} else {
+		b.order.PutUint64(buf, x)
+	}
+	b.Write(buf)
+}
+
+func (b *chunkBuilder) int(x int) {
+	if b.version == LUAC_VERSION_54 {
+		b.unsigned(uint(x))
+		return
+	}
+	b.fixed(uint64(x), b.intSize)
+}
+
+func (b *chunkBuilder) unsigned(x uint) {
+	var buf []byte
+	for {
+		buf = append([]byte{byte(x & 0x7F)}, buf...)
+		if x >>= 7; x == 0 {
+			break
+		}
+	}
+	buf[len(buf)-1] |= 0x80
+	b.Write(buf)
+}
+
+func (b *chunkBuilder) string(s string, null bool) {
+	size := len(s) + 1
+	if null {
+		size = 0
+	}
+	switch b.version {
+	case LUAC_VERSION_54:
+		b.unsigned(uint(size))
+	case LUAC_VERSION_53:
+		if size < 0xFF {
+			b.WriteByte(byte(size))
+		} else {
+			b.WriteByte(0xFF)
+			b.fixed(uint64(size), b.sizetSize)
+		}
+	default:
+		b.fixed(uint64(size), b.sizetSize)
+	}
+	if !null {
+		b.WriteString(s)
+		if b.version <= LUAC_VERSION_52 {
+			b.WriteByte(0)
+		}
+	}
+}
+
+func (b *chunkBuilder) number(f float64) {
+	if b.numberSize == 4 {
+		b.fixed(uint64(math.Float32bits(float32(f))), 4)
+	} else {
+		b.fixed(math.Float64bits(f), 8)
+	}
+}
+
+func (b *chunkBuilder) byteOrderFlag() byte {
+	if b.order == binary.BigEndian {
+		return LUAC_ENDIANNESS_BIG
+	}
+	return LUAC_ENDIANNESS_LITTLE
+}
+
+func (b *chunkBuilder) integralFlag() byte {
+	if b.integral {
+		return 1
+	}
+	return 0
+}
+
+func (b *chunkBuilder) header() {
+	b.WriteString(LUA_SIGNATURE)
+	b.WriteByte(b.version)
+	b.WriteByte(LUAC_FORMAT)
+	switch b.version {
+	case LUAC_VERSION_51, LUAC_VERSION_52:
+		b.Write([]byte{b.byteOrderFlag(), byte(b.intSize), byte(b.sizetSize), INSTRUCTION_SIZE, byte(b.numberSize), b.integralFlag()})
+		if b.version == LUAC_VERSION_52 {
+			b.WriteString(LUAC_DATA)
+		}
+	case LUAC_VERSION_53:
+		b.WriteString(LUAC_DATA)
+		b.Write([]byte{byte(b.intSize), byte(b.sizetSize), INSTRUCTION_SIZE, byte(b.integerSize), byte(b.numberSize)})
+		b.fixed(LUAC_INT, b.integerSize)
+		b.number(LUAC_NUM)
+	case LUAC_VERSION_54:
+		b.WriteString(LUAC_DATA)
+		b.Write([]byte{INSTRUCTION_SIZE, byte(b.integerSize), byte(b.numberSize)})
+		b.fixed(LUAC_INT, b.integerSize)
+		b.number(LUAC_NUM)
+	}
+	// Lua5.3和Lua5.4的头部之后是主函数的upvalue数量
+}
+
+func (b *chunkBuilder) constants(constants []interface{}) {
+	b.int(len(constants))
+	for _, c := range constants {
+		switch c := c.(type) {
+		case nil:
+			b.WriteByte(TAG_NIL)
+		case bool:
+			if b.version == LUAC_VERSION_54 {
+				if c {
+					b.WriteByte(TAG_TRUE)
+				} else {
+					b.WriteByte(TAG_FALSE)
+				}
+				continue
+			}
+			b.WriteByte(TAG_BOOLEAN)
+			if c {
+				b.WriteByte(1)
+			} else {
+				b.WriteByte(0)
+			}
+		case float64:
+			if b.version == LUAC_VERSION_54 {
+				b.WriteByte(TAG_NUMFLT)
+			} else {
+				b.WriteByte(TAG_NUMBER)
+			}
+			b.number(c)
+		case int64:
+			if b.integral {
+				b.WriteByte(TAG_NUMBER_51)
+				b.fixed(uint64(c), b.numberSize)
+				continue
+			}
+			if b.version == LUAC_VERSION_54 {
+				b.WriteByte(TAG_NUMINT)
+			} else {
+				b.WriteByte(TAG_INTERER)
+			}
+			b.fixed(uint64(c), b.integerSize)
+		case string:
+			if b.version <= LUAC_VERSION_52 || len(c) <= LUAI_MAXSHORTLEN {
+				b.WriteByte(TAG_SHORT_STR)
+			} else {
+				b.WriteByte(TAG_LONG_STR)
+			}
+			b.string(c, false)
+		}
+	}
+}
+
+// proto 按照版本格式写入函数原型，parentSource与Source相同时不写入源文件名
+func (b *chunkBuilder) proto(p *Prototype, parentSource string) {
+	source := func() { b.string(p.Source, p.Source == parentSource) }
+	code := func() {
+		b.int(len(p.Code))
+		for _, c := range p.Code {
+			b.fixed(uint64(c), 4)
+		}
+	}
+	protos := func() {
+		b.int(len(p.Protos))
+		for _, child := range p.Protos {
+			b.proto(child, p.Source)
+		}
+	}
+	upvalues := func() {
+		b.int(len(p.Upvalues))
+		for _, u := range p.Upvalues {
+			b.Write([]byte{u.Instack, u.Idx})
+			if b.version == LUAC_VERSION_54 {
+				b.WriteByte(u.Kind)
+			}
+		}
+	}
+	debug := func() {
+		if b.version == LUAC_VERSION_54 {
+			b.int(len(p.LineInfo))
+			b.Write(p.LineInfo)
+			b.int(len(p.AbsLineInfo))
+			for _, info := range p.AbsLineInfo {
+				b.int(info.Pc)
+				b.int(info.Line)
+			}
+		} else {
+			b.int(len(p.Lines))
+			for _, line := range p.Lines {
+				b.int(line)
+			}
+		}
+		b.int(len(p.LocVars))
+		for _, v := range p.LocVars {
+			b.string(v.VarName, false)
+			b.int(v.StartPC)
+			b.int(v.EndPC)
+		}
+		b.int(len(p.UpvalueNames))
+		for _, name := range p.UpvalueNames {
+			b.string(name, false)
+		}
+	}
+	switch b.version {
+	case LUAC_VERSION_51:
+		source()
+		b.int(p.LineDefined)
+		b.int(p.LastLineDefined)
+		b.Write([]byte{byte(len(p.Upvalues)), p.NumParams, p.IsVararg, p.MaxStackSize})
+		code()
+		b.constants(p.Constants)
+		protos()
+		debug()
+	case LUAC_VERSION_52:
+		b.int(p.LineDefined)
+		b.int(p.LastLineDefined)
+		b.Write([]byte{p.NumParams, p.IsVararg, p.MaxStackSize})
+		code()
+		b.constants(p.Constants)
+		protos()
+		upvalues()
+		source()
+		debug()
+	case LUAC_VERSION_53, LUAC_VERSION_54:
+		source()
+		b.int(p.LineDefined)
+		b.int(p.LastLineDefined)
+		b.Write([]byte{p.NumParams, p.IsVararg, p.MaxStackSize})
+		code()
+		b.constants(p.Constants)
+		upvalues()
+		protos()
+		debug()
+	}
+}
+
+// testPrototype 返回一个包含子函数、各种常量和调试信息的函数原型
+func testPrototype(version byte) *Prototype {
+	child := &Prototype{
+		Version:         version,
+		Source:          "@test.lua",
+		LineDefined:     2,
+		LastLineDefined: 4,
+		NumParams:       1,
+		MaxStackSize:    2,
+		Code:            []uint32{0x00000046, 0x0080001E, 0x0000001E},
+		Constants:       []interface{}{"x", 1.5},
+		Upvalues:        []Upvalue{{Instack: 1, Idx: 0}},
+		Protos:          []*Prototype{},
+		Lines:           []int{3, 3, 4},
+		LocVars:         []LocVar{{VarName: "y", StartPC: 0, EndPC: 3}},
+		UpvalueNames:    []string{"_ENV"},
+	}
+	main := &Prototype{
+		Version:      version,
+		Source:       "@test.lua",
+		IsVararg:     2,
+		MaxStackSize: 3,
+		Code:         []uint32{0x00000005, 0x00004041, 0x0000001C, 0x00800024, 0x0080001E},
+		Constants:    []interface{}{nil, true, false, 42.0, "print", strings.Repeat("long string ", 30)},
+		Upvalues:     []Upvalue{{Instack: 1, Idx: 0}},
+		Protos:       []*Prototype{child},
+		Lines:        []int{1, 1, 1, 4, 5},
+		LocVars:      []LocVar{},
+		UpvalueNames: []string{"_ENV"},
+	}
+	switch version {
+	case LUAC_VERSION_51:
+		// Lua5.1没有_ENV，upvalue只记录数量
+		main.Upvalues = []Upvalue{}
+		main.UpvalueNames = []string{}
+		child.Upvalues = []Upvalue{{}}
+		child.UpvalueNames = []string{"t"}
+	case LUAC_VERSION_53:
+		main.Constants = append(main.Constants, int64(-7))
+	}
+	return main
+}
+
+// build 按照版本格式生成包含函数原型p的完整二进制chunk
+func (b *chunkBuilder) build(p *Prototype) io.Reader {
+	b.header()
+	if b.version >= LUAC_VERSION_53 {
+		b.WriteByte(byte(len(p.Upvalues)))
+	}
+	b.proto(p, "")
+	return &b.Buffer
+}
+
+func TestUndumpVersions(t *testing.T) {
+	for _, version := range []byte{LUAC_VERSION_51, LUAC_VERSION_52, LUAC_VERSION_53} {
+		for _, sizes := range [][2]int{{4, 8}, {4, 4}, {8, 8}} {
+			want := testPrototype(version)
+			b := newChunkBuilder(version)
+			b.intSize, b.sizetSize = sizes[0], sizes[1]
+
+			got := Undump(b.build(want))
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("version 0x%02x int %d size_t %d: got %+v, want %+v",
+					version, sizes[0], sizes[1], got, want)
+			}
+			if err := got.CheckExecutable(); !errors.Is(err, ErrUnsupportedVersion) {
+				t.Errorf("version 0x%02x: CheckExecutable() = %v, want ErrUnsupportedVersion", version, err)
+			}
+		}
+	}
+}
+
+func TestUndumpUnsupportedVersion(t *testing.T) {
+	defer func() {
+		if err := recover(); err == nil || !strings.Contains(err.(string), "unsupported version 0x50") {
+			t.Fatalf("got %v, want unsupported version", err)
+		}
+	}()
+	Undump(strings.NewReader(LUA_SIGNATURE + "\x50\x00"))
+}
+
+// TestUndumpCrossPlatform 检查大端序以及4字节整数和浮点数的二进制chunk
+func TestUndumpCrossPlatform(t *testing.T) {
+	file, err := os.Open("binchunk_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	protos := map[byte]*Prototype{LUAC_VERSION_54: Undump(file)}
+	for _, version := range []byte{LUAC_VERSION_51, LUAC_VERSION_52, LUAC_VERSION_53} {
+		protos[version] = testPrototype(version)
+	}
+	for version, want := range protos {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			for _, sizes := range [][2]int{{8, 8}, {4, 4}, {4, 8}, {8, 4}} {
+				b := newChunkBuilder(version)
+				b.order, b.integerSize, b.numberSize = order, sizes[0], sizes[1]
+				b.intSize, b.sizetSize = sizes[0], sizes[0]
+				if got := Undump(b.build(want)); !reflect.DeepEqual(got, want) {
+					t.Errorf("version 0x%02x %v integer %d number %d: got %+v, want %+v",
+						version, order, sizes[0], sizes[1], got, want)
+				}
+			}
+		}
+	}
+}
+
+// TestUndumpIntegralNumber 检查Lua5.1和Lua5.2中lua_Number为整数类型的二进制chunk
+func TestUndumpIntegralNumber(t *testing.T) {
+	for _, version := range []byte{LUAC_VERSION_51, LUAC_VERSION_52} {
+		for _, size := range []int{4, 8} {
+			want := testPrototype(version)
+			want.Constants = []interface{}{nil, true, "print", int64(42), int64(-370)}
+			want.Protos[0].Constants = []interface{}{"x", int64(1)}
+			b := newChunkBuilder(version)
+			b.order, b.numberSize, b.integral = binary.BigEndian, size, true
+			if got := Undump(b.build(want)); !reflect.DeepEqual(got, want) {
+				t.Errorf("version 0x%02x number %d: got %+v, want %+v", version, size, got, want)
+			}
+		}
+	}
+}
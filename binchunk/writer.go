@@ -0,0 +1,220 @@
+package binchunk
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+)
+
+// LuaWriter 按照Lua5.4官方luac在64位小端平台上的格式输出二进制chunk
+type LuaWriter struct {
+	*bufio.Writer
+	strip bool // 是否去掉调试信息
+}
+
+func NewLuaWriter(writer io.Writer, strip bool) *LuaWriter {
+	return &LuaWriter{Writer: bufio.NewWriter(writer), strip: strip}
+}
+
+// dumpError 包装输出过程中发现的错误，由Dump转换为返回值
+type dumpError struct{ err error }
+
+// Dump 把函数原型输出为Lua5.4的二进制chunk，strip为true时去掉调试信息，
+// 和luac -s一样子函数与外围函数的源文件名相同时不重复输出
+func Dump(writer io.Writer, proto *Prototype, strip bool) (err error) {
+	if err := proto.CheckExecutable(); err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(dumpError)
+			if !ok {
+				panic(r)
+			}
+			err = e.err
+		}
+	}()
+	w := NewLuaWriter(writer, strip)
+	// 主函数的upvalue数量只占一个字节
+	if len(proto.Upvalues) > math.MaxUint8 {
+		w.fail("too many upvalues in main function: %d", len(proto.Upvalues))
+	}
+	w.dumpHeader()
+	w.dumpByte(byte(len(proto.Upvalues)))
+	w.dumpProto(proto, "")
+	return w.Flush()
+}
+
+func (w *LuaWriter) fail(format string, a ...interface{}) {
+	panic(dumpError{fmt.Errorf(format, a...)})
+}
+
+func (w *LuaWriter) dumpBytes(b []byte) {
+	if _, err := w.Write(b); err != nil {
+		panic(dumpError{err})
+	}
+}
+
+func (w *LuaWriter) dumpByte(b byte) {
+	if err := w.WriteByte(b); err != nil {
+		panic(dumpError{err})
+	}
+}
+
+func (w *LuaWriter) dumpUint32(x uint32) {
+	buf := make([]byte, 4)
+	litterEndian.PutUint32(buf, x)
+	w.dumpBytes(buf)
+}
+
+func (w *LuaWriter) dumpLuaInteger(x int64) {
+	buf := make([]byte, LUA_INTEGER_SIZE)
+	litterEndian.PutUint64(buf, uint64(x))
+	w.dumpBytes(buf)
+}
+
+func (w *LuaWriter) dumpLuaNumber(x float64) {
+	buf := make([]byte, LUA_NUMBER_SIZE)
+	litterEndian.PutUint64(buf, math.Float64bits(x))
+	w.dumpBytes(buf)
+}
+
+// dumpUnsigned 使用和loadUnsigned相同的变长编码，高位在前，最后一个字节的MSB为1
+func (w *LuaWriter) dumpUnsigned(x uint) {
+	var buf [(64 + 6) / 7]byte
+	n := len(buf)
+	for {
+		n--
+		buf[n] = byte(x & 0x7f)
+		x >>= 7
+		if x == 0 {
+			break
+		}
+	}
+	buf[len(buf)-1] |= 0x80
+	w.dumpBytes(buf[n:])
+}
+
+func (w *LuaWriter) dumpInt(x int) {
+	if x < 0 {
+		w.fail("negative integer %d", x)
+	}
+	w.dumpUnsigned(uint(x))
+}
+
+// dumpString 输出字符串，null为true时输出表示NULL的长度0
+func (w *LuaWriter) dumpString(s string, null bool) {
+	if null {
+		w.dumpUnsigned(0)
+		return
+	}
+	w.dumpUnsigned(uint(len(s)) + 1)
+	w.dumpBytes([]byte(s))
+}
+
+func (w *LuaWriter) dumpHeader() {
+	w.dumpBytes([]byte(LUA_SIGNATURE))
+	w.dumpByte(LUAC_VERSION)
+	w.dumpByte(LUAC_FORMAT)
+	w.dumpBytes([]byte(LUAC_DATA))
+	w.dumpByte(INSTRUCTION_SIZE)
+	w.dumpByte(LUA_INTEGER_SIZE)
+	w.dumpByte(LUA_NUMBER_SIZE)
+	w.dumpLuaInteger(LUAC_INT)
+	w.dumpLuaNumber(LUAC_NUM)
+}
+
+func (w *LuaWriter) dumpProto(p *Prototype, parentSource string) {
+	if p.Version != LUAC_VERSION {
+		w.fail("cannot dump Lua %d.%d function %s:%d", p.Version>>4, p.Version&0xF, p.Source, p.LineDefined)
+	}
+	w.dumpString(p.Source, w.strip || p.Source == parentSource)
+	w.dumpInt(p.LineDefined)
+	w.dumpInt(p.LastLineDefined)
+	w.dumpByte(p.NumParams)
+	w.dumpByte(p.IsVararg)
+	w.dumpByte(p.MaxStackSize)
+	w.dumpCode(p.Code)
+	w.dumpConstants(p.Constants)
+	w.dumpUpvalues(p.Upvalues)
+	w.dumpInt(len(p.Protos))
+	for _, sub := range p.Protos {
+		w.dumpProto(sub, p.Source)
+	}
+	w.dumpDebug(p)
+}
+
+func (w *LuaWriter) dumpCode(code []uint32) {
+	w.dumpInt(len(code))
+	for _, c := range code {
+		w.dumpUint32(c)
+	}
+}
+
+func (w *LuaWriter) dumpConstants(constants []interface{}) {
+	w.dumpInt(len(constants))
+	for _, constant := range constants {
+		switch c := constant.(type) {
+		case nil:
+			w.dumpByte(TAG_NIL)
+		case bool:
+			if c {
+				w.dumpByte(TAG_TRUE)
+			} else {
+				w.dumpByte(TAG_FALSE)
+			}
+		case int64:
+			w.dumpByte(TAG_NUMINT)
+			w.dumpLuaInteger(c)
+		case float64:
+			w.dumpByte(TAG_NUMFLT)
+			w.dumpLuaNumber(c)
+		case string:
+			if len(c) <= LUAI_MAXSHORTLEN {
+				w.dumpByte(TAG_SHORT_STR)
+			} else {
+				w.dumpByte(TAG_LONG_STR)
+			}
+			w.dumpString(c, false)
+		default:
+			w.fail("unknown constant type %T", constant)
+		}
+	}
+}
+
+func (w *LuaWriter) dumpUpvalues(upvalues []Upvalue) {
+	w.dumpInt(len(upvalues))
+	for _, u := range upvalues {
+		w.dumpByte(u.Instack)
+		w.dumpByte(u.Idx)
+		w.dumpByte(u.Kind)
+	}
+}
+
+// dumpDebug 输出调试信息，去掉调试信息时只输出四个空表
+func (w *LuaWriter) dumpDebug(p *Prototype) {
+	if w.strip {
+		for i := 0; i < 4; i++ {
+			w.dumpInt(0)
+		}
+		return
+	}
+	w.dumpInt(len(p.LineInfo))
+	w.dumpBytes(p.LineInfo)
+	w.dumpInt(len(p.AbsLineInfo))
+	for _, info := range p.AbsLineInfo {
+		w.dumpInt(info.Pc)
+		w.dumpInt(info.Line)
+	}
+	w.dumpInt(len(p.LocVars))
+	for _, v := range p.LocVars {
+		w.dumpString(v.VarName, false)
+		w.dumpInt(v.StartPC)
+		w.dumpInt(v.EndPC)
+	}
+	w.dumpInt(len(p.UpvalueNames))
+	for _, name := range p.UpvalueNames {
+		w.dumpString(name, false)
+	}
+}
@@ -0,0 +1,90 @@
+package binchunk
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/depressi0n/myLua/vm"
+)
+
+func TestDumpUndump(t *testing.T) {
+	data, err := ioutil.ReadFile("binchunk_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Dump(&buf, Undump(bytes.NewReader(data)), false); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("got\n% x\nwant\n% x", buf.Bytes(), data)
+	}
+}
+
+func TestDumpRoundTrip(t *testing.T) {
+	p := diffTestPrototype()
+	p.Constants = []interface{}{nil, false, true, int64(math.MinInt64), 0.5, "", string(make([]byte, 300))}
+	lines := make([]int, 200)
+	for pc := range lines {
+		lines[pc] = pc * pc % 1000
+		p.Code = append(p.Code, iABC(vm.OP_MOVE, 0, 0, 1, 0))
+	}
+	p.SetLines(lines)
+	p.Protos[0].Source = "@other.lua"
+	p.Protos = append(p.Protos, &Prototype{Version: LUAC_VERSION, Source: p.Source, LineDefined: 9})
+
+	var buf bytes.Buffer
+	if err := Dump(&buf, p, false); err != nil {
+		t.Fatal(err)
+	}
+	got := Undump(&buf)
+	if diffs := Diff(p, got, false); len(diffs) > 0 {
+		t.Errorf("differences: %v", diffs)
+	}
+	if !reflect.DeepEqual(got.AbsLineInfo, p.AbsLineInfo) {
+		t.Errorf("AbsLineInfo: got %v, want %v", got.AbsLineInfo, p.AbsLineInfo)
+	}
+	for pc, want := range lines {
+		if line := got.LineForPC(pc); line != want {
+			t.Fatalf("LineForPC(%d) = %d, want %d", pc, line, want)
+		}
+	}
+
+	buf.Reset()
+	if err := Dump(&buf, p, true); err != nil {
+		t.Fatal(err)
+	}
+	got = Undump(&buf)
+	if diffs := Diff(p, got, true); len(diffs) > 0 {
+		t.Errorf("stripped differences: %v", diffs)
+	}
+	if got.Source != "" || len(got.LineInfo) != 0 || len(got.LocVars) != 0 || len(got.UpvalueNames) != 0 {
+		t.Errorf("debug information not stripped: %+v", got)
+	}
+}
+
+func TestDumpErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Dump(&buf, testPrototype(LUAC_VERSION_53), false); err == nil {
+		t.Error("dumped a Lua 5.3 prototype")
+	}
+	p := diffTestPrototype()
+	p.LineDefined = -1
+	if err := Dump(&buf, p, false); err == nil {
+		t.Error("dumped a negative line number")
+	}
+	p = diffTestPrototype()
+	p.Constants = []interface{}{[]int{}}
+	if err := Dump(&buf, p, false); err == nil {
+		t.Error("dumped an unknown constant type")
+	}
+	p = diffTestPrototype()
+	p.Upvalues = make([]Upvalue, 256)
+	p.UpvalueNames = nil
+	if err := Dump(&buf, p, false); err == nil {
+		t.Error("dumped 256 upvalues in main function")
+	}
+}
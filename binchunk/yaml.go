@@ -0,0 +1,151 @@
+package binchunk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// EncodeYAML 把函数原型编码为YAML写入w，格式与 EncodeJSON 相同，
+// 字符串总是使用双引号，转义规则与JSON一致
+func EncodeYAML(w io.Writer, p *Prototype) error {
+	var buf bytes.Buffer
+	if err := EncodeJSON(&buf, p); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(&buf)
+	dec.UseNumber()
+	node, err := readYAMLNode(dec)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	writeYAMLMapping(bw, node.(yamlMapping), 0, "")
+	return bw.Flush()
+}
+
+// yamlMapping 是保持键顺序的JSON对象
+type yamlMapping []struct {
+	key   string
+	value interface{}
+}
+
+// readYAMLNode 读取一个JSON值，对象读取为 yamlMapping，数组读取为[]interface{}
+func readYAMLNode(dec *json.Decoder) (interface{}, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		m := yamlMapping{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := readYAMLNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, struct {
+				key   string
+				value interface{}
+			}{key.(string), value})
+		}
+		_, err = dec.Token() // '}'
+		return m, err
+	case json.Delim('['):
+		s := []interface{}{}
+		for dec.More() {
+			value, err := readYAMLNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, value)
+		}
+		_, err = dec.Token() // ']'
+		return s, err
+	}
+	return token, nil
+}
+
+// writeYAMLMapping 输出映射，第一个键之前输出first（列表项的"- "），其余的键缩进indent
+func writeYAMLMapping(w *bufio.Writer, m yamlMapping, indent int, first string) {
+	prefix := strings.Repeat("  ", indent)
+	for i, kv := range m {
+		if i == 0 && first != "" {
+			w.WriteString(first)
+		} else {
+			w.WriteString(prefix)
+		}
+		w.WriteString(kv.key)
+		w.WriteString(":")
+		writeYAMLValue(w, kv.value, indent)
+	}
+}
+
+// writeYAMLValue 输出键之后的值，嵌套的映射和序列缩进一级
+func writeYAMLValue(w *bufio.Writer, value interface{}, indent int) {
+	switch v := value.(type) {
+	case yamlMapping:
+		if len(v) == 0 {
+			w.WriteString(" {}\n")
+			return
+		}
+		w.WriteString("\n")
+		writeYAMLMapping(w, v, indent+1, "")
+	case []interface{}:
+		if len(v) == 0 {
+			w.WriteString(" []\n")
+			return
+		}
+		w.WriteString("\n")
+		writeYAMLSequence(w, v, indent+1)
+	default:
+		w.WriteString(" ")
+		w.WriteString(yamlScalar(v))
+		w.WriteString("\n")
+	}
+}
+
+func writeYAMLSequence(w *bufio.Writer, s []interface{}, indent int) {
+	prefix := strings.Repeat("  ", indent)
+	for _, item := range s {
+		switch v := item.(type) {
+		case yamlMapping:
+			if len(v) == 0 {
+				w.WriteString(prefix + "- {}\n")
+				continue
+			}
+			writeYAMLMapping(w, v, indent+1, prefix+"- ")
+		case []interface{}:
+			if len(v) == 0 {
+				w.WriteString(prefix + "- []\n")
+				continue
+			}
+			w.WriteString(prefix + "-\n")
+			writeYAMLSequence(w, v, indent+1)
+		default:
+			w.WriteString(prefix + "- " + yamlScalar(v) + "\n")
+		}
+	}
+}
+
+func yamlScalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.Encode(v)
+		return strings.TrimSuffix(buf.String(), "\n")
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/depressi0n/myLua/binchunk"
+	"github.com/depressi0n/myLua/cfg"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var cfgCommand = &command{
+	name:  "cfg",
+	usage: "cfg [-func path] file.luac",
+	run:   runCfg,
+}
+
+// runCfg 按照Graphviz的DOT格式输出函数的控制流图
+func runCfg(args []string) error {
+	flags := flag.NewFlagSet("cfg", flag.ExitOnError)
+	path := flags.String("func", "main", "function path as printed by chunkdiff and chunkstat, e.g. main/2/1")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("need exactly one chunk file")
+	}
+
+	proto, err := undumpFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	proto, err = findFunction(proto, *path)
+	if err != nil {
+		return err
+	}
+	g, err := cfg.Build(proto)
+	if err != nil {
+		return err
+	}
+	return g.WriteDOT(os.Stdout)
+}
+
+// findFunction 根据路径查找函数原型，路径由"main"和从1开始的子函数编号组成
+func findFunction(proto *binchunk.Prototype, path string) (*binchunk.Prototype, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "main" {
+		return nil, fmt.Errorf("function path %q does not start with main", path)
+	}
+	for _, part := range parts[1:] {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 1 || n > len(proto.Protos) {
+			return nil, fmt.Errorf("function path %q: no function %s", path, part)
+		}
+		proto = proto.Protos[n-1]
+	}
+	return proto, nil
+}
@@ -0,0 +1,154 @@
+// Package cfg 把Lua5.4函数原型的指令表划分为基本块，建立控制流图
+package cfg
+
+import (
+	"fmt"
+
+	"github.com/depressi0n/myLua/binchunk"
+	"github.com/depressi0n/myLua/vm"
+)
+
+// EdgeKind 表示控制流图中边的类型
+type EdgeKind int
+
+const (
+	EdgeFallthrough EdgeKind = iota // 顺序执行到下一条指令
+	EdgeJump                        // 跳转指令的目标
+	EdgeSkip                        // 测试指令或LFALSESKIP跳过下一条指令
+)
+
+func (k EdgeKind) String() string {
+	switch k {
+	case EdgeFallthrough:
+		return "fallthrough"
+	case EdgeJump:
+		return "jump"
+	case EdgeSkip:
+		return "skip"
+	default:
+		return fmt.Sprintf("EdgeKind(%d)", int(k))
+	}
+}
+
+// Edge 是控制流图中的一条边
+type Edge struct {
+	From, To int // 基本块的编号
+	Kind     EdgeKind
+}
+
+// IsBack 判断是否是回边，即跳转到不在后面的基本块，循环的入口是回边的目标
+func (e Edge) IsBack() bool {
+	return e.To <= e.From
+}
+
+// Block 是一个基本块，包含指令[Start, End)，只能从第一条指令进入，从最后一条指令离开
+type Block struct {
+	Index      int
+	Start, End int    // 指令位置，从0开始计数
+	Succs      []Edge // 后继基本块，按照目标位置排列
+	Preds      []Edge // 前驱基本块
+}
+
+// Graph 是一个函数原型的控制流图，Blocks[0]是入口
+// 没有后继的基本块以RETURN、RETURN0或RETURN1结束
+type Graph struct {
+	Proto   *binchunk.Prototype
+	Blocks  []*Block
+	blockOf []int // 每条指令所属的基本块
+}
+
+// BlockAt 返回第pc条指令所属的基本块
+func (g *Graph) BlockAt(pc int) *Block {
+	return g.Blocks[g.blockOf[pc]]
+}
+
+// successors 返回位于pc的指令执行之后可能到达的指令位置和边的类型
+func successors(i vm.Instruction, pc int) ([]int, []EdgeKind) {
+	info := i.Info()
+	switch op := i.Opcode(); {
+	case op == vm.OP_RETURN || op == vm.OP_RETURN0 || op == vm.OP_RETURN1:
+		return nil, nil
+	case op == vm.OP_LFALSESKIP:
+		return []int{pc + 2}, []EdgeKind{EdgeSkip}
+	case info.IsTest:
+		return []int{pc + 1, pc + 2}, []EdgeKind{EdgeFallthrough, EdgeSkip}
+	case info.IsJump:
+		target, _ := i.JumpTarget(pc)
+		// JMP和TFORPREP总是跳转
+		if op == vm.OP_JMP || op == vm.OP_TFORPREP {
+			return []int{target}, []EdgeKind{EdgeJump}
+		}
+		if target < pc+1 {
+			return []int{target, pc + 1}, []EdgeKind{EdgeJump, EdgeFallthrough}
+		}
+		return []int{pc + 1, target}, []EdgeKind{EdgeFallthrough, EdgeJump}
+	}
+	return []int{pc + 1}, []EdgeKind{EdgeFallthrough}
+}
+
+// Build 建立函数原型的控制流图，不包括子函数原型
+// 跳转目标超出指令表，或者最后一条指令之后还可能继续执行时返回错误
+func Build(p *binchunk.Prototype) (*Graph, error) {
+	if err := p.CheckExecutable(); err != nil {
+		return nil, err
+	}
+	code := p.Code
+	if len(code) == 0 {
+		return nil, fmt.Errorf("%s:%d: empty code", p.Source, p.LineDefined)
+	}
+
+	// 第一条指令、跳转目标以及改变控制流的指令之后的指令是基本块的开始
+	leader := make([]bool, len(code)+1)
+	leader[0] = true
+	for pc, c := range code {
+		i := vm.Instruction(c)
+		targets, kinds := successors(i, pc)
+		for _, target := range targets {
+			if target < 0 || target >= len(code) {
+				return nil, fmt.Errorf("%s:%d: pc %d (%s): successor %d out of code range [1, %d]",
+					p.Source, p.LineDefined, pc+1, i.OpName(), target+1, len(code))
+			}
+		}
+		if len(kinds) == 1 && kinds[0] == EdgeFallthrough {
+			continue
+		}
+		for _, target := range targets {
+			leader[target] = true
+		}
+		leader[pc+1] = true
+	}
+
+	g := &Graph{Proto: p, blockOf: make([]int, len(code))}
+	for pc := range code {
+		if leader[pc] {
+			g.Blocks = append(g.Blocks, &Block{Index: len(g.Blocks), Start: pc})
+		}
+		b := g.Blocks[len(g.Blocks)-1]
+		b.End = pc + 1
+		g.blockOf[pc] = b.Index
+	}
+	for _, b := range g.Blocks {
+		last := b.End - 1
+		targets, kinds := successors(vm.Instruction(code[last]), last)
+		for n, target := range targets {
+			e := Edge{From: b.Index, To: g.blockOf[target], Kind: kinds[n]}
+			b.Succs = append(b.Succs, e)
+			g.Blocks[e.To].Preds = append(g.Blocks[e.To].Preds, e)
+		}
+	}
+	return g, nil
+}
+
+// Loops 返回所有循环的入口基本块，即回边的目标，按照位置排列
+func (g *Graph) Loops() []*Block {
+	var loops []*Block
+	for _, b := range g.Blocks {
+		for _, e := range b.Preds {
+			if e.IsBack() {
+				loops = append(loops, b)
+				break
+			}
+		}
+	}
+	return loops
+}
@@ -0,0 +1,116 @@
+package cfg
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/depressi0n/myLua/asm"
+	"github.com/depressi0n/myLua/binchunk"
+)
+
+const testSource = `
+.source "@test.lua"
+.stack 5
+.line 1
+        LOADI 0 0
+        LOADI 1 1
+        LOADI 2 10
+        LOADI 3 1
+        FORPREP 1 done
+.line 2
+loop:   ADD 0 0 4
+        MMBIN 0 4 6
+        FORLOOP 1 loop
+.line 3
+done:   EQI 0 5 0
+        JMP else
+        LOADTRUE 1
+        JMP end
+else:   LFALSESKIP 1
+        LOADTRUE 1
+end:    RETURN1 1
+`
+
+func assemble(t *testing.T, src string) *binchunk.Prototype {
+	p, err := asm.Assemble([]byte(src), "test.lasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestBuild(t *testing.T) {
+	g, err := Build(assemble(t, testSource))
+	if err != nil {
+		t.Fatal(err)
+	}
+	type block struct {
+		start, end int
+		succs      []Edge
+	}
+	want := []block{
+		{0, 5, []Edge{{0, 1, EdgeFallthrough}, {0, 2, EdgeJump}}},
+		{5, 8, []Edge{{1, 1, EdgeJump}, {1, 2, EdgeFallthrough}}},
+		{8, 9, []Edge{{2, 3, EdgeFallthrough}, {2, 4, EdgeSkip}}},
+		{9, 10, []Edge{{3, 5, EdgeJump}}},
+		{10, 12, []Edge{{4, 7, EdgeJump}}},
+		{12, 13, []Edge{{5, 7, EdgeSkip}}},
+		{13, 14, []Edge{{6, 7, EdgeFallthrough}}},
+		{14, 15, nil},
+	}
+	var got []block
+	for _, b := range g.Blocks {
+		got = append(got, block{b.Start, b.End, b.Succs})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got blocks %v, want %v", got, want)
+	}
+	if b := g.BlockAt(6); b.Index != 1 {
+		t.Errorf("BlockAt(6) = B%d, want B1", b.Index)
+	}
+	if preds := g.Blocks[6].Preds; len(preds) != 0 {
+		t.Errorf("unreachable block has predecessors %v", preds)
+	}
+	if preds := g.Blocks[7].Preds; len(preds) != 3 {
+		t.Errorf("exit block has predecessors %v", preds)
+	}
+	if loops := g.Loops(); len(loops) != 1 || loops[0].Index != 1 {
+		t.Errorf("Loops() = %v, want [B1]", loops)
+	}
+
+	var buf bytes.Buffer
+	if err := g.WriteDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+	for _, s := range []string{
+		`digraph "@test.lua:0" {`,
+		`b1 [label="B1\l6  [2]  ADD 0 0 4\l7  [2]  MMBIN 0 4 6\l8  [2]  FORLOOP 1 3\l"];`,
+		`b1 -> b1 [label=jump, penwidth=2];`,
+		`b2 -> b4 [label=skip, style=dashed];`,
+		`b6 -> b7;`,
+	} {
+		if !strings.Contains(dot, s) {
+			t.Errorf("DOT output does not contain %q:\n%s", s, dot)
+		}
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	for _, src := range []string{
+		"JMP 5\nRETURN0",
+		"LOADI 0 1",
+		"EQI 0 5 0\nRETURN0",
+	} {
+		if _, err := Build(assemble(t, ".stack 2\n"+src)); err == nil {
+			t.Errorf("%q: no error", src)
+		}
+	}
+	p := assemble(t, "RETURN0")
+	p.Version = binchunk.LUAC_VERSION_53
+	if _, err := Build(p); err == nil {
+		t.Errorf("Lua 5.3 prototype: no error")
+	}
+}
@@ -0,0 +1,56 @@
+package cfg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/depressi0n/myLua/vm"
+)
+
+// WriteDOT 按照Graphviz的DOT格式输出控制流图，每个基本块是一个节点，列出其中的指令
+// 跳转用实线，跳过下一条指令用虚线，回边加粗，便于找到循环
+func (g *Graph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph %s {\n", quote(fmt.Sprintf("%s:%d", g.Proto.Source, g.Proto.LineDefined)))
+	fmt.Fprintln(bw, "\tnode [shape=box, fontname=monospace];")
+	for _, b := range g.Blocks {
+		var label strings.Builder
+		fmt.Fprintf(&label, "B%d\\l", b.Index)
+		for pc := b.Start; pc < b.End; pc++ {
+			fmt.Fprintf(&label, "%d  ", pc+1)
+			if line := g.Proto.LineForPC(pc); line >= 0 {
+				fmt.Fprintf(&label, "[%d]  ", line)
+			}
+			fmt.Fprintf(&label, "%s\\l", vm.Instruction(g.Proto.Code[pc]))
+		}
+		fmt.Fprintf(bw, "\tb%d [label=\"%s\"];\n", b.Index, label.String())
+	}
+	for _, b := range g.Blocks {
+		for _, e := range b.Succs {
+			var attrs []string
+			switch e.Kind {
+			case EdgeJump:
+				attrs = append(attrs, "label=jump")
+			case EdgeSkip:
+				attrs = append(attrs, "label=skip", "style=dashed")
+			}
+			if e.IsBack() {
+				attrs = append(attrs, "penwidth=2")
+			}
+			fmt.Fprintf(bw, "\tb%d -> b%d", e.From, e.To)
+			if len(attrs) > 0 {
+				fmt.Fprintf(bw, " [%s]", strings.Join(attrs, ", "))
+			}
+			fmt.Fprintln(bw, ";")
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// quote 把字符串转换为DOT的带引号的ID
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
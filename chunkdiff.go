@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/depressi0n/myLua/binchunk"
+	"os"
+)
+
+var chunkdiffCommand = &command{
+	name:  "chunkdiff",
+	usage: "chunkdiff [-s] a.luac b.luac",
+	run:   runChunkdiff,
+}
+
+// runChunkdiff 比较两个二进制chunk，存在差异时返回错误
+func runChunkdiff(args []string) error {
+	flags := flag.NewFlagSet("chunkdiff", flag.ExitOnError)
+	strip := flags.Bool("s", false, "ignore debug information (source, lines, locals and upvalue names)")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		return fmt.Errorf("need exactly two chunk files")
+	}
+
+	a, err := undumpFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := undumpFile(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	diffs := binchunk.Diff(a, b, *strip)
+	for _, d := range diffs {
+		fmt.Println(d)
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("%d differences", len(diffs))
+	}
+	return nil
+}
+
+// undumpFile 解析二进制chunk文件
+func undumpFile(filename string) (*binchunk.Prototype, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	proto, err := binchunk.TryUndump(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return proto, nil
+}
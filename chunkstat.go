@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/depressi0n/myLua/binchunk"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+var chunkstatCommand = &command{
+	name:  "chunkstat",
+	usage: "chunkstat [-top n] file.luac...",
+	run:   runChunkstat,
+}
+
+// runChunkstat 统计二进制chunk的大小和内容
+func runChunkstat(args []string) error {
+	flags := flag.NewFlagSet("chunkstat", flag.ExitOnError)
+	top := flags.Int("top", 10, "number of duplicate strings to list, 0 for all")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return fmt.Errorf("need at least one chunk file")
+	}
+
+	for i, filename := range flags.Args() {
+		proto, err := undumpFile(filename)
+		if err != nil {
+			return err
+		}
+		stats, err := binchunk.CollectStats(proto)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		printStats(filename, stats, *top)
+	}
+	return nil
+}
+
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(total)
+}
+
+func printStats(filename string, stats *binchunk.Stats, top int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+
+	total := stats.TotalBytes
+	fmt.Fprintf(w, "%s\t%d bytes\t\n", filename, total)
+	fmt.Fprintf(w, "header\t%d\t%.1f%%\t\n", stats.HeaderBytes, percent(stats.HeaderBytes, total))
+	fmt.Fprintf(w, "code\t%d\t%.1f%%\t\n", stats.CodeBytes, percent(stats.CodeBytes, total))
+	fmt.Fprintf(w, "constants\t%d\t%.1f%%\t\n", stats.ConstantBytes, percent(stats.ConstantBytes, total))
+	fmt.Fprintf(w, "debug info\t%d\t%.1f%%\t\n", stats.DebugBytes, percent(stats.DebugBytes, total))
+	other := total - stats.HeaderBytes - stats.CodeBytes - stats.ConstantBytes - stats.DebugBytes
+	fmt.Fprintf(w, "other\t%d\t%.1f%%\t\n", other, percent(other, total))
+	fmt.Fprintf(w, "debug info / code\t%.2f\t\t\n", float64(stats.DebugBytes)/float64(max(stats.CodeBytes, 1)))
+
+	deepest := stats.Functions[0]
+	for _, f := range stats.Functions {
+		if f.MaxStackSize > deepest.MaxStackSize {
+			deepest = f
+		}
+	}
+	fmt.Fprintf(w, "max slots\t%d\t%s\t\n", deepest.MaxStackSize, deepest.Path)
+
+	fmt.Fprintf(w, "\nfunction\tinstructions\tconstants\tslots\tcode bytes\tdebug bytes\t\n")
+	for _, f := range stats.Functions {
+		fmt.Fprintf(w, "%s <%s:%d>\t%d\t%d\t%d\t%d\t%d\t\n", f.Path, f.Source, f.LineDefined,
+			f.Instructions, f.Constants, f.MaxStackSize, f.CodeBytes, f.DebugBytes)
+	}
+
+	fmt.Fprintf(w, "\nopcode\tcount\t\t\n")
+	instructions := 0
+	for _, op := range stats.Opcodes {
+		instructions += op.Count
+	}
+	for _, op := range stats.Opcodes {
+		fmt.Fprintf(w, "%s\t%d\t%.1f%%\t\n", op.Name, op.Count, percent(op.Count, instructions))
+	}
+
+	fmt.Fprintf(w, "\nconstant type\tcount\tbytes\t\n")
+	var types []string
+	for typ := range stats.Constants {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		fmt.Fprintf(w, "%s\t%d\t%d\t\n", typ, stats.Constants[typ].Count, stats.Constants[typ].Bytes)
+	}
+
+	wasted := 0
+	for _, d := range stats.DuplicateStrings {
+		wasted += d.WastedBytes
+	}
+	fmt.Fprintf(w, "\nduplicate strings\tcopies\twasted bytes\t\n")
+	for i, d := range stats.DuplicateStrings {
+		if top > 0 && i >= top {
+			fmt.Fprintf(w, "... %d more\t\t\t\n", len(stats.DuplicateStrings)-top)
+			break
+		}
+		fmt.Fprintf(w, "%q\t%d\t%d\t\n", d.Value, d.Count, d.WastedBytes)
+	}
+	fmt.Fprintf(w, "total\t\t%d\t\n", wasted)
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
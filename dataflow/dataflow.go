@@ -0,0 +1,252 @@
+// Package dataflow 对Lua5.4函数原型的指令做数据流分析，
+// 计算每条指令处活跃的寄存器以及寄存器的值来自哪些定义
+package dataflow
+
+import (
+	"sort"
+
+	"github.com/depressi0n/myLua/binchunk"
+	"github.com/depressi0n/myLua/cfg"
+	"github.com/depressi0n/myLua/vm"
+)
+
+// Entry 表示寄存器的值来自函数入口，即参数或者还没有赋值的寄存器
+const Entry = -1
+
+// Analysis 是一个函数原型的分析结果，不包括子函数原型
+type Analysis struct {
+	Proto   *binchunk.Prototype
+	Graph   *cfg.Graph
+	effects []Effect
+	liveIn  []RegSet  // 每条指令执行之前活跃的寄存器
+	liveOut []RegSet  // 每条指令执行之后活跃的寄存器
+	defsIn  [][][]int // 每个基本块入口处每个寄存器可能的定义位置，不可达的基本块为nil
+}
+
+// Value 表示位于PC的指令写入寄存器Reg的值
+type Value struct {
+	PC, Reg int
+}
+
+// Local 是某条指令处有效的局部变量
+type Local struct {
+	Name string
+	Reg  int
+	Live bool // 变量的值之后还可能被读取
+}
+
+// Analyze 分析函数原型，函数原型先经过 binchunk.Verify 检查
+func Analyze(p *binchunk.Prototype) (*Analysis, error) {
+	if err := binchunk.Verify(p); err != nil {
+		return nil, err
+	}
+	g, err := cfg.Build(p)
+	if err != nil {
+		return nil, err
+	}
+	a := &Analysis{Proto: p, Graph: g, effects: make([]Effect, len(p.Code))}
+	for pc := range p.Code {
+		a.effects[pc] = Effects(p, pc)
+	}
+	a.liveness()
+	a.reachingDefs()
+	return a, nil
+}
+
+// defsOn 判断位于pc的指令沿着k类型的边离开时是否写入 Effect.Defs，
+// 只在某些路径上写入的指令总是位于基本块的末尾
+func (a *Analysis) defsOn(pc int, k cfg.EdgeKind) bool {
+	if !a.effects[pc].Conditional {
+		return true
+	}
+	switch vm.Instruction(a.Proto.Code[pc]).Opcode() {
+	case vm.OP_TESTSET, vm.OP_FORPREP:
+		return k == cfg.EdgeFallthrough
+	default:
+		return k == cfg.EdgeJump
+	}
+}
+
+// liveness 从后向前计算活跃的寄存器，直到不再变化
+func (a *Analysis) liveness() {
+	code := a.Proto.Code
+	a.liveIn = make([]RegSet, len(code))
+	a.liveOut = make([]RegSet, len(code))
+	for changed := true; changed; {
+		changed = false
+		for n := len(a.Graph.Blocks) - 1; n >= 0; n-- {
+			b := a.Graph.Blocks[n]
+			last := b.End - 1
+			var out, in RegSet
+			for _, e := range b.Succs {
+				live := a.liveIn[a.Graph.Blocks[e.To].Start]
+				out = out.union(live)
+				if a.defsOn(last, e.Kind) {
+					live = live.minus(a.effects[last].Defs)
+				}
+				in = in.union(live)
+			}
+			old := a.liveIn[b.Start]
+			a.liveOut[last] = out
+			a.liveIn[last] = in.union(a.effects[last].Uses)
+			for pc := last - 1; pc >= b.Start; pc-- {
+				a.liveOut[pc] = a.liveIn[pc+1]
+				a.liveIn[pc] = a.liveOut[pc].minus(a.effects[pc].Defs).union(a.effects[pc].Uses)
+			}
+			if a.liveIn[b.Start] != old {
+				changed = true
+			}
+		}
+	}
+}
+
+// reachingDefs 从前向后计算每个基本块入口处寄存器可能的定义位置，直到不再变化
+func (a *Analysis) reachingDefs() {
+	blocks := a.Graph.Blocks
+	a.defsIn = make([][][]int, len(blocks))
+	entry := make([][]int, a.Proto.MaxStackSize)
+	for r := range entry {
+		entry[r] = []int{Entry}
+	}
+	a.defsIn[0] = entry
+	for changed := true; changed; {
+		changed = false
+		for _, b := range blocks {
+			if a.defsIn[b.Index] == nil {
+				continue
+			}
+			last := b.End - 1
+			before := a.transfer(a.defsIn[b.Index], b.Start, last)
+			after := a.transfer(before, last, b.End)
+			for _, e := range b.Succs {
+				defs := before
+				if a.defsOn(last, e.Kind) {
+					defs = after
+				}
+				in := a.defsIn[e.To]
+				if in == nil {
+					in = make([][]int, len(defs))
+					changed = true
+				}
+				for r := range defs {
+					merged := merge(in[r], defs[r])
+					if len(merged) != len(in[r]) {
+						in[r] = merged
+						changed = true
+					}
+				}
+				a.defsIn[e.To] = in
+			}
+		}
+	}
+}
+
+// transfer 返回执行[start, end)的指令之后寄存器可能的定义位置，不修改defs。
+// 只在某些路径上写入的指令按照写入计算
+func (a *Analysis) transfer(defs [][]int, start, end int) [][]int {
+	defs = append([][]int(nil), defs...)
+	for pc := start; pc < end; pc++ {
+		for _, r := range a.effects[pc].Defs.Regs() {
+			defs[r] = []int{pc}
+		}
+	}
+	return defs
+}
+
+// merge 合并两个从小到大排列的位置列表，返回新的列表
+func merge(x, y []int) []int {
+	z := make([]int, 0, len(x)+len(y))
+	for len(x) > 0 && len(y) > 0 {
+		switch {
+		case x[0] < y[0]:
+			z, x = append(z, x[0]), x[1:]
+		case x[0] > y[0]:
+			z, y = append(z, y[0]), y[1:]
+		default:
+			z, x, y = append(z, x[0]), x[1:], y[1:]
+		}
+	}
+	z = append(z, x...)
+	return append(z, y...)
+}
+
+// Effect 返回位于pc的指令读写的寄存器
+func (a *Analysis) Effect(pc int) Effect {
+	return a.effects[pc]
+}
+
+// LiveIn 返回执行pc处的指令之前活跃的寄存器，即之后在被覆盖之前还可能被读取的寄存器
+func (a *Analysis) LiveIn(pc int) RegSet {
+	return a.liveIn[pc]
+}
+
+// LiveOut 返回执行pc处的指令之后活跃的寄存器
+func (a *Analysis) LiveOut(pc int) RegSet {
+	return a.liveOut[pc]
+}
+
+// Reaching 返回执行pc处的指令之前，寄存器reg的值可能来自的指令位置，按照从小到大排列，
+// Entry 表示来自函数入口。pc处的指令不可达时返回nil
+func (a *Analysis) Reaching(pc, reg int) []int {
+	b := a.Graph.BlockAt(pc)
+	defs := a.defsIn[b.Index]
+	if defs == nil || reg >= len(defs) {
+		return nil
+	}
+	return a.transfer(defs, b.Start, pc)[reg]
+}
+
+// Unused 返回写入之后不会再被读取的值，按照指令位置排列。
+// 写入数量在运行时才能确定的指令（见 Effect.Open）和不可达的指令不计算在内
+func (a *Analysis) Unused() []Value {
+	var unused []Value
+	for pc, e := range a.effects {
+		if e.Open || a.defsIn[a.Graph.BlockAt(pc).Index] == nil {
+			continue
+		}
+		live := a.liveOut[pc]
+		if e.Conditional {
+			live = RegSet{}
+			for _, edge := range a.Graph.BlockAt(pc).Succs {
+				if a.defsOn(pc, edge.Kind) {
+					live = live.union(a.liveIn[a.Graph.Blocks[edge.To].Start])
+				}
+			}
+		}
+		for _, r := range e.Defs.Regs() {
+			if !live.Has(r) {
+				unused = append(unused, Value{PC: pc, Reg: r})
+			}
+		}
+	}
+	return unused
+}
+
+// UsesOf 返回可能读取位于pc的指令写入寄存器reg的值的指令位置，按照从小到大排列
+func (a *Analysis) UsesOf(pc, reg int) []int {
+	var uses []int
+	for user, e := range a.effects {
+		if !e.Uses.Has(reg) {
+			continue
+		}
+		defs := a.Reaching(user, reg)
+		if n := sort.SearchInts(defs, pc); n < len(defs) && defs[n] == pc {
+			uses = append(uses, user)
+		}
+	}
+	return uses
+}
+
+// Locals 返回pc处有效的局部变量，第n个有效的局部变量保存在寄存器n中。
+// 没有调试信息时返回nil
+func (a *Analysis) Locals(pc int) []Local {
+	var locals []Local
+	for _, v := range a.Proto.LocVars {
+		if v.StartPC > pc || pc >= v.EndPC {
+			continue
+		}
+		reg := len(locals)
+		locals = append(locals, Local{Name: v.VarName, Reg: reg, Live: a.liveIn[pc].Has(reg)})
+	}
+	return locals
+}
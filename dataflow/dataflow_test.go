@@ -0,0 +1,170 @@
+package dataflow
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/depressi0n/myLua/asm"
+	"github.com/depressi0n/myLua/binchunk"
+	"github.com/depressi0n/myLua/vm"
+)
+
+const testSource = `
+.source "@test.lua"
+.params 1
+.stack 6
+.upval _ENV 1 0
+.const "print"
+.line 1
+        LOADI 1 1
+        TESTSET 2 0 0
+        JMP l
+        LOADI 2 7
+l:      LOADI 1 2
+        GETTABUP 3 0 0
+        MOVE 4 2
+        CALL 3 2 0
+        CALL 3 0 2
+        ADD 5 3 1
+        MMBIN 3 1 6
+        RETURN1 5
+.local x 0 12
+.local y 1 12
+`
+
+func analyze(t *testing.T, src, name string) *Analysis {
+	p, err := asm.Assemble([]byte(src), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := Analyze(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAnalyze(t *testing.T) {
+	a := analyze(t, testSource, "test.lasm")
+	reaching := []struct {
+		pc, reg int
+		want    []int
+	}{
+		{1, 0, []int{Entry}},
+		{4, 2, []int{1, 3}},
+		{8, 3, []int{7}},
+		{8, 4, []int{7}},
+		{9, 1, []int{4}},
+		{9, 3, []int{8}},
+		{11, 5, []int{9}},
+	}
+	for _, test := range reaching {
+		if got := a.Reaching(test.pc, test.reg); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Reaching(%d, %d) = %v, want %v", test.pc, test.reg, got, test.want)
+		}
+	}
+	live := []struct {
+		pc   int
+		want []int
+	}{
+		{0, []int{0}},
+		{1, []int{0}},
+		{2, []int{2}},
+		{8, []int{1, 3, 4, 5}},
+		{11, []int{5}},
+	}
+	for _, test := range live {
+		if got := a.LiveIn(test.pc).Regs(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("LiveIn(%d) = %v, want %v", test.pc, got, test.want)
+		}
+	}
+	if got, want := a.Unused(), []Value{{PC: 0, Reg: 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unused() = %v, want %v", got, want)
+	}
+	if got, want := a.UsesOf(4, 1), []int{9, 10}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UsesOf(4, 1) = %v, want %v", got, want)
+	}
+	if got, want := a.Locals(4), []Local{{"x", 0, false}, {"y", 1, false}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Locals(4) = %v, want %v", got, want)
+	}
+	if got, want := a.Locals(9), []Local{{"x", 0, false}, {"y", 1, true}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Locals(9) = %v, want %v", got, want)
+	}
+}
+
+func TestAnalyzeLoop(t *testing.T) {
+	src, err := ioutil.ReadFile("../asm/testdata/forloop.lasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := analyze(t, string(src), "forloop.lasm")
+	if !a.Effect(7).Uses.Has(4) {
+		t.Errorf("CLOSURE does not use the captured register")
+	}
+	if got, want := a.Reaching(7, 4), []int{6, 9}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Reaching(7, 4) = %v, want %v", got, want)
+	}
+	if got, want := a.Reaching(10, 4), []int{Entry, 6, 9}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Reaching(10, 4) = %v, want %v", got, want)
+	}
+	if got, want := a.LiveIn(9).Regs(), []int{0, 1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LiveIn(9) = %v, want %v", got, want)
+	}
+	if got := a.Unused(); got != nil {
+		t.Errorf("Unused() = %v, want none", got)
+	}
+}
+
+// TestAnalyzeChunk 检查测试用的chunk中，指令读取的寄存器都有定义
+func TestAnalyzeChunk(t *testing.T) {
+	data, err := ioutil.ReadFile("../binchunk/binchunk_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var check func(p *binchunk.Prototype)
+	check = func(p *binchunk.Prototype) {
+		a, err := Analyze(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for pc, c := range p.Code {
+			// 读取到栈顶的指令按照保守的近似计算，可能包括没有赋值的寄存器
+			if i := vm.Instruction(c); i.UsesTop() || i.Opcode() == vm.OP_CLOSE {
+				continue
+			}
+			for _, r := range a.Effect(pc).Uses.Regs() {
+				if !a.LiveIn(pc).Has(r) {
+					t.Errorf("%s:%d: pc %d: register %d used but not live", p.Source, p.LineDefined, pc, r)
+				}
+				defs := a.Reaching(pc, r)
+				if r >= int(p.NumParams) && len(defs) > 0 && defs[0] == Entry {
+					t.Errorf("%s:%d: pc %d: register %d may be used before definition: %v",
+						p.Source, p.LineDefined, pc, r, defs)
+				}
+			}
+		}
+		for _, sub := range p.Protos {
+			check(sub)
+		}
+	}
+	check(binchunk.Undump(bytes.NewReader(data)))
+	files, _ := filepath.Glob("../asm/testdata/*.lasm")
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(analyze(t, string(src), file).Proto)
+	}
+
+	p, err := asm.Assemble([]byte("MOVE 0 7\nRETURN0"), "test.lasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Analyze(p); err == nil {
+		t.Errorf("invalid prototype: no error")
+	}
+}
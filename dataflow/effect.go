@@ -0,0 +1,201 @@
+package dataflow
+
+import (
+	"math/bits"
+
+	"github.com/depressi0n/myLua/binchunk"
+	"github.com/depressi0n/myLua/vm"
+)
+
+// RegSet 是寄存器的集合，寄存器编号不超过 vm.MAXARG_A
+type RegSet [4]uint64
+
+// Add 把寄存器r加入集合
+func (s *RegSet) Add(r int) {
+	s[r/64] |= 1 << uint(r%64)
+}
+
+// Has 判断寄存器r是否在集合中
+func (s RegSet) Has(r int) bool {
+	return s[r/64]&(1<<uint(r%64)) != 0
+}
+
+// Regs 按照编号从小到大返回集合中的寄存器
+func (s RegSet) Regs() []int {
+	var regs []int
+	for n, w := range s {
+		for ; w != 0; w &= w - 1 {
+			regs = append(regs, n*64+bits.TrailingZeros64(w))
+		}
+	}
+	return regs
+}
+
+func (s RegSet) union(t RegSet) RegSet {
+	for n := range s {
+		s[n] |= t[n]
+	}
+	return s
+}
+
+func (s RegSet) minus(t RegSet) RegSet {
+	for n := range s {
+		s[n] &^= t[n]
+	}
+	return s
+}
+
+// Effect 描述一条指令读写的寄存器
+type Effect struct {
+	Uses RegSet // 读取的寄存器
+	Defs RegSet // 写入的寄存器
+	// Conditional 表示Defs只在某些执行路径上写入，其他路径上寄存器保留原来的值：
+	// TESTSET和FORPREP在继续执行下一条指令时写入，FORLOOP和TFORLOOP在跳转时写入
+	Conditional bool
+	// Open 表示Defs从某个寄存器开始一直到栈顶，实际写入的数量在运行时才能确定
+	// （CALL和VARARG的C为0，以及TAILCALL），下一条指令按照新的栈顶读取这些值
+	Open bool
+}
+
+// Effects 返回函数原型中位于pc的指令读写的寄存器
+//
+// 使用或者设置栈顶的指令（见 vm.Instruction.UsesTop 和 vm.Instruction.SetsTop）
+// 按照从A一直到MaxStackSize的寄存器计算，这是保守的近似。
+// MMBIN、MMBINI和MMBINK只在前一条算术指令失败时执行，把元方法的结果写入算术指令的寄存器A，
+// 这里把结果看作算术指令写入的值
+func Effects(p *binchunk.Prototype, pc int) Effect {
+	i := vm.Instruction(p.Code[pc])
+	top := int(p.MaxStackSize)
+	var e Effect
+	// rng 把寄存器[from, to]中不超过栈大小的部分加入集合
+	rng := func(s *RegSet, from, to int) {
+		for r := from; r <= to && r < top; r++ {
+			s.Add(r)
+		}
+	}
+	use := func(regs ...int) {
+		for _, r := range regs {
+			rng(&e.Uses, r, r)
+		}
+	}
+	def := func(regs ...int) {
+		for _, r := range regs {
+			rng(&e.Defs, r, r)
+		}
+	}
+	a, k, b, c := i.IABC()
+	// rk 在k为0时读取寄存器C
+	rk := func() {
+		if k == 0 {
+			use(c)
+		}
+	}
+
+	switch op := i.Opcode(); op {
+	case vm.OP_MOVE, vm.OP_UNM, vm.OP_BNOT, vm.OP_NOT, vm.OP_LEN,
+		vm.OP_GETI, vm.OP_GETFIELD, vm.OP_ADDI, vm.OP_SHRI, vm.OP_SHLI,
+		vm.OP_ADDK, vm.OP_SUBK, vm.OP_MULK, vm.OP_MODK, vm.OP_POWK, vm.OP_DIVK, vm.OP_IDIVK,
+		vm.OP_BANDK, vm.OP_BORK, vm.OP_BXORK:
+		use(b)
+		def(a)
+	case vm.OP_GETTABLE, vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD, vm.OP_POW, vm.OP_DIV, vm.OP_IDIV,
+		vm.OP_BAND, vm.OP_BOR, vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR:
+		use(b, c)
+		def(a)
+	case vm.OP_LOADI, vm.OP_LOADF, vm.OP_LOADK, vm.OP_LOADKX, vm.OP_LOADFALSE, vm.OP_LFALSESKIP,
+		vm.OP_LOADTRUE, vm.OP_GETUPVAL, vm.OP_GETTABUP, vm.OP_NEWTABLE:
+		def(a)
+	case vm.OP_LOADNIL:
+		rng(&e.Defs, a, a+b)
+	case vm.OP_SETUPVAL, vm.OP_TEST, vm.OP_EQK, vm.OP_EQI, vm.OP_LTI, vm.OP_LEI, vm.OP_GTI, vm.OP_GEI,
+		vm.OP_RETURN1, vm.OP_TBC:
+		use(a)
+	case vm.OP_EQ, vm.OP_LT, vm.OP_LE:
+		use(a, b)
+	case vm.OP_SETTABUP:
+		rk()
+	case vm.OP_SETTABLE:
+		use(a, b)
+		rk()
+	case vm.OP_SETI, vm.OP_SETFIELD:
+		use(a)
+		rk()
+	case vm.OP_SELF:
+		use(b)
+		rk()
+		def(a, a+1)
+	case vm.OP_MMBIN, vm.OP_MMBINI, vm.OP_MMBINK:
+		use(a)
+		if op == vm.OP_MMBIN {
+			use(b)
+		}
+	case vm.OP_CONCAT:
+		rng(&e.Uses, a, a+b-1)
+		def(a)
+	case vm.OP_CLOSE:
+		// 关闭的to-be-closed变量会被读取
+		rng(&e.Uses, a, top)
+	case vm.OP_TESTSET:
+		use(b)
+		def(a)
+		e.Conditional = true
+	case vm.OP_CALL, vm.OP_TAILCALL:
+		if b == 0 {
+			rng(&e.Uses, a, top)
+		} else {
+			rng(&e.Uses, a, a+b-1)
+		}
+		if c == 0 || op == vm.OP_TAILCALL {
+			rng(&e.Defs, a, top)
+			e.Open = true
+		} else {
+			rng(&e.Defs, a, a+c-2)
+		}
+	case vm.OP_RETURN:
+		if b == 0 {
+			rng(&e.Uses, a, top)
+		} else {
+			rng(&e.Uses, a, a+b-2)
+		}
+	case vm.OP_FORPREP:
+		use(a, a+1, a+2)
+		def(a, a+1, a+2, a+3)
+		e.Conditional = true
+	case vm.OP_FORLOOP:
+		use(a, a+1, a+2)
+		def(a, a+1, a+3)
+		e.Conditional = true
+	case vm.OP_TFORPREP:
+		use(a + 3)
+	case vm.OP_TFORCALL:
+		use(a, a+1, a+2)
+		rng(&e.Defs, a+4, a+3+c)
+	case vm.OP_TFORLOOP:
+		use(a + 4)
+		def(a + 2)
+		e.Conditional = true
+	case vm.OP_SETLIST:
+		use(a)
+		if b == 0 {
+			rng(&e.Uses, a+1, top)
+		} else {
+			rng(&e.Uses, a+1, a+b)
+		}
+	case vm.OP_CLOSURE:
+		_, bx := i.IABx()
+		for _, uv := range p.Protos[bx].Upvalues {
+			if uv.Instack == 1 {
+				use(int(uv.Idx))
+			}
+		}
+		def(a)
+	case vm.OP_VARARG:
+		if c == 0 {
+			rng(&e.Defs, a, top)
+			e.Open = true
+		} else {
+			rng(&e.Defs, a, a+c-2)
+		}
+	}
+	return e
+}
@@ -0,0 +1,250 @@
+package debuginfo
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/depressi0n/myLua/asm"
+	"github.com/depressi0n/myLua/binchunk"
+)
+
+// local y; print(x.z); y.a = 1; local t = {}; t:m(); local _ = "str" + "str"
+const testSource = `
+.source "@test.lua"
+.vararg
+.stack 4
+.upval _ENV 1 0
+.const "print"
+.const "x"
+.const "z"
+.const "a"
+.const "m"
+.const 1
+.const "str"
+        VARARGPREP 0
+.line 1
+        LOADNIL 0 0
+.line 2
+        GETTABUP 1 0 0
+        GETTABUP 2 0 1
+        GETFIELD 2 2 2
+        CALL 1 2 1
+.line 3
+        SETFIELD 0 3 5k
+.line 4
+        NEWTABLE 1 0 0
+        EXTRAARG 0
+.line 5
+        SELF 2 1 4k
+        CALL 2 2 1
+.line 6
+        LOADK 3 6
+        ADD 2 3 3
+        MMBIN 3 3 6
+        TEST 0 0
+        JMP l
+        GETTABUP 3 0 1
+l:      CALL 3 1 1
+        RETURN 0 1 1
+.local y 2 19
+.local t 9 19
+`
+
+func assemble(t *testing.T, src string) *binchunk.Prototype {
+	p, err := asm.Assemble([]byte(src), "test.lasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := binchunk.Verify(p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestObjName(t *testing.T) {
+	p := assemble(t, testSource)
+	tests := []struct {
+		pc, reg int
+		want    string // 为空表示推断不出名字
+	}{
+		{4, 2, "global 'x'"},
+		{5, 1, "global 'print'"},
+		{6, 0, "local 'y'"},
+		{10, 1, "local 't'"},
+		{10, 2, "method 'm'"},
+		{13, 3, "constant 'str'"},
+		{17, 3, ""},
+	}
+	for _, test := range tests {
+		var got string
+		if n, ok := ObjName(p, test.pc, test.reg); ok {
+			got = n.String()
+		}
+		if got != test.want {
+			t.Errorf("ObjName(%d, %d) = %q, want %q", test.pc, test.reg, got, test.want)
+		}
+	}
+
+	funcs := []struct {
+		pc   int
+		want string
+	}{
+		{5, "global 'print'"},
+		{10, "method 'm'"},
+		{4, "metamethod 'index'"},
+		{6, "metamethod 'newindex'"},
+		{13, "metamethod 'add'"},
+		{1, ""},
+	}
+	for _, test := range funcs {
+		var got string
+		if n, ok := FuncNameFromCode(p, test.pc); ok {
+			got = n.String()
+		}
+		if got != test.want {
+			t.Errorf("FuncNameFromCode(%d) = %q, want %q", test.pc, got, test.want)
+		}
+	}
+}
+
+func TestTypeError(t *testing.T) {
+	p := assemble(t, testSource)
+	tests := []struct {
+		err  Error
+		want string
+	}{
+		{TypeError(p, 4, 2, "index", "nil"), "test.lua:2: attempt to index a nil value (global 'x')"},
+		{TypeError(p, 6, 0, "index", "nil"), "test.lua:3: attempt to index a nil value (local 'y')"},
+		{TypeError(p, 17, 3, "call", "nil"), "test.lua:6: attempt to call a nil value"},
+		{NewError(p, 1, "attempt to compare %s with %s", "nil", "number"), "test.lua:1: attempt to compare nil with number"},
+	}
+	for _, test := range tests {
+		if got := test.err.Error(); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+
+	// 去掉调试信息之后只能通过指令推断名字
+	p.Source, p.LocVars, p.UpvalueNames, p.LineInfo, p.AbsLineInfo = "", nil, nil, nil, nil
+	if got, want := TypeError(p, 6, 0, "index", "nil").Error(), "?:-1: attempt to index a nil value"; got != want {
+		t.Errorf("stripped: got %q, want %q", got, want)
+	}
+	if got, want := VarInfo(p, 4, 2), " (field 'x')"; got != want {
+		t.Errorf("stripped: got %q, want %q", got, want)
+	}
+}
+
+func TestChunkID(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	tests := []struct {
+		source, want string
+	}{
+		{"@test.lua", "test.lua"},
+		{"=stdin", "stdin"},
+		{"print(1)", `[string "print(1)"]`},
+		{"print(1)\nprint(2)", `[string "print(1)..."]`},
+		{"=" + long, long[:59]},
+		{"@" + long, "..." + long[:56]},
+		{long, `[string "` + long[:45] + `..."]`},
+		{"", "?"},
+	}
+	for _, test := range tests {
+		if got := ChunkID(test.source); got != test.want {
+			t.Errorf("ChunkID(%q) = %q, want %q", test.source, got, test.want)
+		}
+	}
+}
+
+func TestGetFuncInfo(t *testing.T) {
+	p := assemble(t, testSource)
+	want := FuncInfo{
+		Source:   "@test.lua",
+		ShortSrc: "test.lua",
+		What:     "main",
+		NumUps:   1,
+		IsVararg: true,
+	}
+	if got := GetFuncInfo(p); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got, want := ActiveLines(p), []int{1, 2, 3, 4, 5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ActiveLines() = %v, want %v", got, want)
+	}
+	if got, want := ActiveLocals(p, 10), []string{"y", "t"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ActiveLocals(10) = %v, want %v", got, want)
+	}
+	if got := ActiveLocals(p, 1); got != nil {
+		t.Errorf("ActiveLocals(1) = %v, want none", got)
+	}
+
+	p.Source, p.LineDefined, p.LineInfo, p.AbsLineInfo = "", 3, nil, nil
+	info := GetFuncInfo(p)
+	if info.Source != "=?" || info.ShortSrc != "?" || info.What != "Lua" {
+		t.Errorf("stripped: got %+v", info)
+	}
+	if got := ActiveLines(p); got != nil {
+		t.Errorf("stripped: ActiveLines() = %v, want none", got)
+	}
+}
+
+func TestCheckInfoOptions(t *testing.T) {
+	for what, want := range map[string]string{"": "", "Slnrutf": "", "L": "", "x": "invalid option", "Sx": "invalid option", ">S": "invalid option '>'"} {
+		var got string
+		if err := CheckInfoOptions(what); err != nil {
+			got = err.Error()
+		}
+		if got != want {
+			t.Errorf("CheckInfoOptions(%q) = %q, want %q", what, got, want)
+		}
+	}
+}
+
+func goCallback() {}
+
+func TestTraceback(t *testing.T) {
+	main := assemble(t, testSource)
+	callee := assemble(t, `
+.source "=lib"
+.linedefined 10
+.lastlinedefined 12
+.line 11
+        RETURN0
+`)
+	goFrame := GoFuncFrame(goCallback)
+	if goFrame.GoFunc != "github.com/depressi0n/myLua/debuginfo.goCallback" || !strings.HasSuffix(goFrame.GoFile, "debuginfo_test.go") {
+		t.Fatalf("GoFuncFrame() = %+v", goFrame)
+	}
+	frames := []Frame{
+		{Proto: callee, PC: 0},
+		goFrame,
+		{Proto: main, PC: 5},
+		{Name: Name{"global", "pcall"}},
+	}
+	want := fmt.Sprintf(`boom
+stack traceback:
+	lib:11: in function <lib:10>
+	[Go function] %s:%d: in function 'print'
+	test.lua:2: in main chunk
+	[Go function] in function 'pcall'`, goFrame.GoFile, goFrame.GoLine)
+	if got := Traceback("boom", frames); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// 尾调用和过深的调用栈
+	frames = []Frame{{Proto: callee, TailCall: true}}
+	for len(frames) < 30 {
+		frames = append(frames, Frame{Proto: callee})
+	}
+	lines := strings.Split(Traceback("", frames), "\n")
+	if len(lines) != 1+2+9+1+11 {
+		t.Fatalf("got %d lines:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	if lines[2] != "\t(...tail calls...)" || lines[12] != "\t...\t(skipping 8 levels)" {
+		t.Errorf("got\n%s", strings.Join(lines, "\n"))
+	}
+	if got := GoFuncFrame(nil); got.GoFunc != "" || got.GoFile != "" {
+		t.Errorf("GoFuncFrame(nil) = %+v", got)
+	}
+}
@@ -0,0 +1,84 @@
+package debuginfo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/depressi0n/myLua/binchunk"
+)
+
+// idSize 对应LUA_IDSIZE，ChunkID返回的字符串不超过idSize-1个字节
+const idSize = 60
+
+// ChunkID 按照luaO_chunkid的规则把函数原型的Source转换为错误信息中的chunk名字：
+// "=name"显示为name，"@file"显示为file，过长时保留末尾部分，
+// 其他源代码显示为[string "..."]，只保留第一行。
+// 去掉调试信息的chunk没有Source，返回"?"
+func ChunkID(source string) string {
+	switch {
+	case source == "":
+		return "?"
+	case strings.HasPrefix(source, "="):
+		if len(source) <= idSize {
+			return source[1:]
+		}
+		return source[1:idSize]
+	case strings.HasPrefix(source, "@"):
+		if len(source) <= idSize {
+			return source[1:]
+		}
+		return "..." + source[len(source)-(idSize-len("...")-1):]
+	default:
+		const max = idSize - len(`[string "..."]`) - 1
+		nl := strings.IndexByte(source, '\n')
+		if nl < 0 && len(source) < max {
+			return `[string "` + source + `"]`
+		}
+		if nl >= 0 {
+			source = source[:nl]
+		}
+		if len(source) > max {
+			source = source[:max]
+		}
+		return `[string "` + source + `..."]`
+	}
+}
+
+// Error 是运行时错误，Error方法返回的信息以"chunk:line:"开始
+type Error struct {
+	ChunkID string
+	Line    int // 没有行号信息时为-1
+	Msg     string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.ChunkID, e.Line, e.Msg)
+}
+
+// NewError 返回执行pc处的指令时发生的错误
+func NewError(p *binchunk.Prototype, pc int, format string, a ...interface{}) Error {
+	return Error{
+		ChunkID: ChunkID(p.Source),
+		Line:    p.LineForPC(pc),
+		Msg:     fmt.Sprintf(format, a...),
+	}
+}
+
+// VarInfo 返回寄存器reg中的值的描述，例如" (local 'x')"，推断不出名字时返回空字符串
+func VarInfo(p *binchunk.Prototype, pc, reg int) string {
+	if n, ok := ObjName(p, pc, reg); ok {
+		return " (" + n.String() + ")"
+	}
+	return ""
+}
+
+// UpvalueInfo 返回第idx个upvalue的描述，例如" (upvalue 't')"
+func UpvalueInfo(p *binchunk.Prototype, idx int) string {
+	return " (" + Name{"upvalue", UpvalueName(p, idx)}.String() + ")"
+}
+
+// TypeError 返回对寄存器reg中的值做op操作时的类型错误，对应luaG_typeerror，
+// 例如op为"index"、typeName为"nil"时返回"attempt to index a nil value (field 'x')"
+func TypeError(p *binchunk.Prototype, pc, reg int, op, typeName string) Error {
+	return NewError(p, pc, "attempt to %s a %s value%s", op, typeName, VarInfo(p, pc, reg))
+}
@@ -0,0 +1,92 @@
+package debuginfo
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/depressi0n/myLua/binchunk"
+)
+
+// FuncInfo 是debug.getinfo的结果中只由函数原型决定的字段，对应lua_Debug中的同名字段
+type FuncInfo struct {
+	Source          string // 'S'
+	ShortSrc        string // 'S'
+	LineDefined     int    // 'S'
+	LastLineDefined int    // 'S'
+	What            string // 'S'，主函数为"main"，其他为"Lua"
+	NumUps          int    // 'u'
+	NumParams       int    // 'u'
+	IsVararg        bool   // 'u'
+}
+
+// GetFuncInfo 返回函数原型的信息，对应ldebug.c中的funcinfo和lua_getinfo的'u'选项
+func GetFuncInfo(p *binchunk.Prototype) FuncInfo {
+	source := p.Source
+	if source == "" {
+		source = "=?"
+	}
+	what := "Lua"
+	if p.LineDefined == 0 {
+		what = "main"
+	}
+	return FuncInfo{
+		Source:          source,
+		ShortSrc:        ChunkID(source),
+		LineDefined:     p.LineDefined,
+		LastLineDefined: p.LastLineDefined,
+		What:            what,
+		NumUps:          len(p.Upvalues),
+		NumParams:       int(p.NumParams),
+		IsVararg:        p.IsVararg != 0,
+	}
+}
+
+// ActiveLines 返回函数中有指令的行号，按照从小到大排列，对应lua_getinfo的'L'选项。
+// vararg函数的VARARGPREP不计算在内，没有行号信息时返回nil
+func ActiveLines(p *binchunk.Prototype) []int {
+	seen := make(map[int]bool)
+	var lines []int
+	pc := 0
+	if p.IsVararg != 0 {
+		pc = 1
+	}
+	for ; pc < len(p.Code); pc++ {
+		if line := p.LineForPC(pc); line >= 0 && !seen[line] {
+			seen[line] = true
+			lines = append(lines, line)
+		}
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// ActiveLocals 返回pc处有效的局部变量的名字，第n个名字对应debug.getlocal的第n+1个局部变量
+func ActiveLocals(p *binchunk.Prototype, pc int) []string {
+	var names []string
+	for _, v := range p.LocVars {
+		if v.StartPC > pc {
+			break
+		}
+		if pc < v.EndPC {
+			names = append(names, v.VarName)
+		}
+	}
+	return names
+}
+
+// infoOptions 是lua_getinfo支持的选项
+const infoOptions = "SlnrutfL"
+
+// CheckInfoOptions 检查debug.getinfo的what参数，出错时返回的信息与Lua相同
+func CheckInfoOptions(what string) error {
+	if strings.HasPrefix(what, ">") {
+		return errors.New("invalid option '>'")
+	}
+	for _, c := range what {
+		if !strings.ContainsRune(infoOptions, c) {
+			return errors.New("invalid option")
+		}
+	}
+	return nil
+}
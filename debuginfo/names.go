@@ -0,0 +1,215 @@
+// Package debuginfo 根据函数原型的指令和调试信息推断变量和函数的名字，
+// 生成与Lua5.4一致的运行时错误信息，并提供debug库需要的函数信息，
+// 对应Lua5.4 ldebug.c中不依赖运行时状态的部分
+package debuginfo
+
+import (
+	"fmt"
+
+	"github.com/depressi0n/myLua/binchunk"
+	"github.com/depressi0n/myLua/vm"
+)
+
+// Name 是推断出来的名字，Kind是"global"、"local"、"method"、"field"、"upvalue"、
+// "constant"、"metamethod"或"for iterator"
+type Name struct {
+	Kind string
+	Name string
+}
+
+// String 按照Lua错误信息的格式返回名字，例如"local 'x'"
+func (n Name) String() string {
+	return fmt.Sprintf("%s '%s'", n.Kind, n.Name)
+}
+
+// LocalName 返回pc处第n个（从1开始计数）有效的局部变量的名字，对应luaF_getlocalname
+func LocalName(p *binchunk.Prototype, n, pc int) (string, bool) {
+	for _, v := range p.LocVars {
+		if v.StartPC > pc {
+			break
+		}
+		if pc < v.EndPC {
+			n--
+			if n == 0 {
+				return v.VarName, true
+			}
+		}
+	}
+	return "", false
+}
+
+// UpvalueName 返回第idx个upvalue的名字，没有调试信息时返回"?"
+func UpvalueName(p *binchunk.Prototype, idx int) string {
+	if idx < len(p.UpvalueNames) && p.UpvalueNames[idx] != "" {
+		return p.UpvalueNames[idx]
+	}
+	return "?"
+}
+
+// ObjName 推断执行pc处的指令时寄存器reg中的值的名字，对应getobjname：
+// 先查找局部变量，再从前向后找到最后一条写入寄存器的指令，根据这条指令推断名字
+func ObjName(p *binchunk.Prototype, pc, reg int) (Name, bool) {
+	if name, ok := LocalName(p, reg+1, pc); ok {
+		return Name{"local", name}, true
+	}
+	setpc := findSetReg(p, pc, reg)
+	if setpc < 0 {
+		return Name{}, false
+	}
+	i := vm.Instruction(p.Code[setpc])
+	a, k, b, c := i.IABC()
+	switch i.Opcode() {
+	case vm.OP_MOVE:
+		if b < a {
+			return ObjName(p, setpc, b)
+		}
+	case vm.OP_GETTABUP:
+		return Name{isEnv(p, setpc, b, true), constantName(p, c)}, true
+	case vm.OP_GETTABLE:
+		return Name{isEnv(p, setpc, b, false), registerName(p, setpc, c)}, true
+	case vm.OP_GETI:
+		return Name{"field", "integer index"}, true
+	case vm.OP_GETFIELD:
+		return Name{isEnv(p, setpc, b, false), constantName(p, c)}, true
+	case vm.OP_GETUPVAL:
+		return Name{"upvalue", UpvalueName(p, b)}, true
+	case vm.OP_LOADK, vm.OP_LOADKX:
+		var idx int
+		if i.Opcode() == vm.OP_LOADK {
+			_, idx = i.IABx()
+		} else if setpc+1 < len(p.Code) {
+			idx = vm.Instruction(p.Code[setpc+1]).IAx()
+		}
+		if idx < len(p.Constants) {
+			if s, ok := p.Constants[idx].(string); ok {
+				return Name{"constant", s}, true
+			}
+		}
+	case vm.OP_SELF:
+		if k == 1 {
+			return Name{"method", constantName(p, c)}, true
+		}
+		return Name{"method", registerName(p, setpc, c)}, true
+	}
+	return Name{}, false
+}
+
+// findSetReg 返回pc之前最后一条写入寄存器reg的指令位置，对应findsetreg。
+// 这条指令位于跳转之后的条件执行的代码中时无法确定，返回-1
+func findSetReg(p *binchunk.Prototype, lastpc, reg int) int {
+	if vm.Instruction(p.Code[lastpc]).Info().IsMetamethod {
+		// 前一条算术指令没有真正完成
+		lastpc--
+	}
+	setreg := -1
+	jmptarget := 0 // 这个位置之前的代码是条件执行的
+	for pc := 0; pc < lastpc; pc++ {
+		i := vm.Instruction(p.Code[pc])
+		a, _, b, _ := i.IABC()
+		var change bool
+		switch i.Opcode() {
+		case vm.OP_LOADNIL:
+			change = a <= reg && reg <= a+b
+		case vm.OP_TFORCALL:
+			change = reg >= a+2
+		case vm.OP_CALL, vm.OP_TAILCALL:
+			change = reg >= a
+		case vm.OP_JMP:
+			if dest, _ := i.JumpTarget(pc); dest <= lastpc && dest > jmptarget {
+				jmptarget = dest
+			}
+		default:
+			change = i.Info().SetsA && reg == a
+		}
+		if change {
+			if pc < jmptarget {
+				setreg = -1
+			} else {
+				setreg = pc
+			}
+		}
+	}
+	return setreg
+}
+
+// isEnv 判断被索引的表（upvalue或寄存器t）是否是_ENV，是则索引的是全局变量
+func isEnv(p *binchunk.Prototype, pc, t int, isUpvalue bool) string {
+	var name string
+	if isUpvalue {
+		name = UpvalueName(p, t)
+	} else if n, ok := ObjName(p, pc, t); ok {
+		name = n.Name
+	}
+	if name == "_ENV" {
+		return "global"
+	}
+	return "field"
+}
+
+// constantName 返回字符串常量idx的值，不是字符串时返回"?"
+func constantName(p *binchunk.Prototype, idx int) string {
+	if idx < len(p.Constants) {
+		if s, ok := p.Constants[idx].(string); ok {
+			return s
+		}
+	}
+	return "?"
+}
+
+// registerName 返回寄存器reg中的常量字符串，不是常量时返回"?"
+func registerName(p *binchunk.Prototype, pc, reg int) string {
+	if n, ok := ObjName(p, pc, reg); ok && n.Kind == "constant" {
+		return n.Name
+	}
+	return "?"
+}
+
+// tmNames 是Lua5.4的元方法名字（不包括"__"），顺序与ltm.h中的TMS相同，MMBIN的C是其中的下标
+var tmNames = []string{
+	"index", "newindex", "gc", "mode", "len", "eq",
+	"add", "sub", "mul", "mod", "pow", "div", "idiv",
+	"band", "bor", "bxor", "shl", "shr",
+	"unm", "bnot", "lt", "le", "concat", "call", "close",
+}
+
+// FuncNameFromCode 推断位于pc的指令调用的函数的名字，对应funcnamefromcode。
+// 除了CALL和TAILCALL，其他可能调用元方法的指令返回元方法的名字
+func FuncNameFromCode(p *binchunk.Prototype, pc int) (Name, bool) {
+	i := vm.Instruction(p.Code[pc])
+	a, _, _, c := i.IABC()
+	var tm string
+	switch i.Opcode() {
+	case vm.OP_CALL, vm.OP_TAILCALL:
+		return ObjName(p, pc, a)
+	case vm.OP_TFORCALL:
+		return Name{"for iterator", "for iterator"}, true
+	case vm.OP_SELF, vm.OP_GETTABUP, vm.OP_GETTABLE, vm.OP_GETI, vm.OP_GETFIELD:
+		tm = "index"
+	case vm.OP_SETTABUP, vm.OP_SETTABLE, vm.OP_SETI, vm.OP_SETFIELD:
+		tm = "newindex"
+	case vm.OP_MMBIN, vm.OP_MMBINI, vm.OP_MMBINK:
+		if c >= len(tmNames) {
+			return Name{}, false
+		}
+		tm = tmNames[c]
+	case vm.OP_UNM:
+		tm = "unm"
+	case vm.OP_BNOT:
+		tm = "bnot"
+	case vm.OP_LEN:
+		tm = "len"
+	case vm.OP_CONCAT:
+		tm = "concat"
+	case vm.OP_EQ:
+		tm = "eq"
+	case vm.OP_LT, vm.OP_LTI, vm.OP_GTI:
+		tm = "lt"
+	case vm.OP_LE, vm.OP_LEI, vm.OP_GEI:
+		tm = "le"
+	case vm.OP_CLOSE, vm.OP_RETURN:
+		tm = "close"
+	default:
+		return Name{}, false
+	}
+	return Name{"metamethod", tm}, true
+}
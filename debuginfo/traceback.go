@@ -0,0 +1,112 @@
+package debuginfo
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/depressi0n/myLua/binchunk"
+)
+
+// Frame 是调用栈中的一层，可以是Lua函数或Go函数
+type Frame struct {
+	Proto *binchunk.Prototype // Lua函数的原型，Go函数为nil
+	PC    int                 // Lua函数正在执行的指令位置
+	// Name 是函数的名字，Kind为空时由调用者正在执行的指令推断，见 FuncNameFromCode。
+	// 在全局变量中找到的函数使用Kind "global"
+	Name     Name
+	TailCall bool // 通过尾调用进入，调用者的信息已经丢失
+
+	GoFunc string // Go函数的完整名字，例如"main.callback"
+	GoFile string // Go函数所在的文件和行号
+	GoLine int
+}
+
+// GoFuncFrame 返回注册到解释器的Go函数fn对应的调用栈层，文件和行号是函数定义的位置，
+// fn不是函数时返回的 Frame 没有名字和位置
+func GoFuncFrame(fn interface{}) Frame {
+	var f Frame
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return f
+	}
+	if rf := runtime.FuncForPC(v.Pointer()); rf != nil {
+		f.GoFunc = rf.Name()
+		f.GoFile, f.GoLine = rf.FileLine(rf.Entry())
+	}
+	return f
+}
+
+// 调用栈太深时只显示前levels1层和后levels2层，与luaL_traceback相同
+const (
+	levels1 = 10
+	levels2 = 11
+)
+
+// Traceback 按照luaL_traceback的格式返回调用栈，frames从当前正在执行的函数开始。
+// Lua函数显示为"source:line: in function 'name'"，
+// Go函数显示为"[Go function] file:line: in function 'name'"，
+// msg不为空时放在第一行
+func Traceback(msg string, frames []Frame) string {
+	var b strings.Builder
+	if msg != "" {
+		b.WriteString(msg)
+		b.WriteString("\n")
+	}
+	b.WriteString("stack traceback:")
+	for level := 0; level < len(frames); level++ {
+		if len(frames)-1 > levels1+levels2 && level == levels1 {
+			// 与Lua相同，显示的跳过层数比实际少一层
+			fmt.Fprintf(&b, "\n\t...\t(skipping %d levels)", len(frames)-1-levels1-levels2)
+			level = len(frames) - levels2 - 1
+			continue
+		}
+		f := frames[level]
+		b.WriteString("\n\t")
+		if f.Proto == nil {
+			b.WriteString("[Go function] ")
+			if f.GoFile != "" {
+				fmt.Fprintf(&b, "%s:%d: ", f.GoFile, f.GoLine)
+			}
+		} else {
+			src := ChunkID(GetFuncInfo(f.Proto).Source)
+			if line := f.Proto.LineForPC(f.PC); line > 0 {
+				fmt.Fprintf(&b, "%s:%d: ", src, line)
+			} else {
+				fmt.Fprintf(&b, "%s: ", src)
+			}
+		}
+		b.WriteString("in ")
+		b.WriteString(funcName(frames, level))
+		if f.TailCall {
+			b.WriteString("\n\t(...tail calls...)")
+		}
+	}
+	return b.String()
+}
+
+// funcName 返回第level层的函数的描述，对应lauxlib.c中的pushfuncname
+func funcName(frames []Frame, level int) string {
+	f := frames[level]
+	name := f.Name
+	if name.Kind == "" && !f.TailCall && level+1 < len(frames) && frames[level+1].Proto != nil {
+		caller := frames[level+1]
+		name, _ = FuncNameFromCode(caller.Proto, caller.PC)
+	}
+	switch {
+	case name.Kind == "global":
+		return fmt.Sprintf("function '%s'", name.Name)
+	case name.Kind != "":
+		return name.String()
+	case f.Proto == nil && f.GoFunc != "":
+		return fmt.Sprintf("function '%s'", f.GoFunc)
+	case f.Proto == nil:
+		return "?"
+	case f.Proto.LineDefined == 0:
+		return "main chunk"
+	default:
+		info := GetFuncInfo(f.Proto)
+		return fmt.Sprintf("function <%s:%d>", info.ShortSrc, info.LineDefined)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/depressi0n/myLua/binchunk"
+	"os"
+)
+
+var exportCommand = &command{
+	name:  "export",
+	usage: "export [-format json|yaml] file.luac",
+	run:   runExport,
+}
+
+// runExport 把二进制chunk中的函数原型树导出为JSON或YAML
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "json", "output format: json or yaml")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("need exactly one chunk file")
+	}
+
+	proto, err := undumpFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "json":
+		return binchunk.EncodeJSON(os.Stdout, proto)
+	case "yaml":
+		return binchunk.EncodeYAML(os.Stdout, proto)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
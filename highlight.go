@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/depressi0n/myLua/highlight"
+	"io/ioutil"
+	"os"
+)
+
+var highlightCommand = &command{
+	name:  "highlight",
+	usage: "highlight [-format ansi|html] [-css] [file.lua]",
+	run:   runHighlight,
+}
+
+// runHighlight 高亮显示Lua源代码，没有指定文件时从标准输入读取
+func runHighlight(args []string) error {
+	flags := flag.NewFlagSet("highlight", flag.ExitOnError)
+	format := flags.String("format", "ansi", "output format: ansi or html")
+	css := flags.Bool("css", false, "with -format html, emit a standalone page with the default stylesheet")
+	flags.Parse(args)
+
+	chunkName := "stdin"
+	var data []byte
+	var err error
+	if flags.NArg() > 0 {
+		chunkName = flags.Arg(0)
+		data, err = ioutil.ReadFile(chunkName)
+	} else {
+		data, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "ansi":
+		return highlight.ANSI(os.Stdout, string(data), chunkName)
+	case "html":
+		if *css {
+			fmt.Printf("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<style>\n%s</style>\n</head>\n<body>\n", highlight.CSS)
+			defer fmt.Print("</body>\n</html>\n")
+		}
+		return highlight.HTML(os.Stdout, string(data), chunkName)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
@@ -0,0 +1,160 @@
+package highlight
+
+import (
+	"github.com/depressi0n/myLua/lexer"
+	"html"
+	"io"
+	"strings"
+)
+
+// 高亮显示的词素类别，除了 lexer.KindToCategory 给出的类别之外，还包括注释
+// and，or，not虽然是操作符，但按照关键字显示
+const (
+	Keyword  = "keyword"
+	Operator = "operator"
+	String   = "string"
+	Number   = "number"
+	Comment  = "comment"
+)
+
+// ANSI终端中各个类别对应的颜色
+var ansiColors = map[string]string{
+	Keyword:  "\x1b[1;35m",
+	Operator: "\x1b[33m",
+	String:   "\x1b[32m",
+	Number:   "\x1b[36m",
+	Comment:  "\x1b[90m",
+}
+
+const ansiReset = "\x1b[0m"
+
+// HTML输出中各个类别对应的样式类
+var htmlClasses = map[string]string{
+	Keyword:  "lua-keyword",
+	Operator: "lua-operator",
+	String:   "lua-string",
+	Number:   "lua-number",
+	Comment:  "lua-comment",
+}
+
+// CSS 是HTML输出使用的默认样式表
+const CSS = `pre.lua { background: #fafafa; padding: 0.5em; }
+pre.lua .lua-keyword { color: #a626a4; font-weight: bold; }
+pre.lua .lua-operator { color: #986801; }
+pre.lua .lua-string { color: #50a14f; }
+pre.lua .lua-number { color: #0184bc; }
+pre.lua .lua-comment { color: #a0a1a7; font-style: italic; }
+`
+
+// ANSI 将Lua源代码以ANSI终端颜色输出到w
+// 源代码有词法错误时，错误位置之后的部分原样输出，并返回该错误
+func ANSI(w io.Writer, chunk, chunkName string) error {
+	return highlight(w, chunk, chunkName, func(category, text string) string {
+		if color, ok := ansiColors[category]; ok {
+			return color + text + ansiReset
+		}
+		return text
+	})
+}
+
+// HTML 将Lua源代码输出为HTML片段，需要高亮的部分放在带有"lua-类别"样式类的span中
+// 源代码有词法错误时，错误位置之后的部分原样输出，并返回该错误
+func HTML(w io.Writer, chunk, chunkName string) error {
+	if _, err := io.WriteString(w, `<pre class="lua">`); err != nil {
+		return err
+	}
+	err := highlight(w, chunk, chunkName, func(category, text string) string {
+		text = html.EscapeString(text)
+		if class, ok := htmlClasses[category]; ok {
+			return `<span class="` + class + `">` + text + `</span>`
+		}
+		return text
+	})
+	if _, werr := io.WriteString(w, "</pre>\n"); err == nil {
+		err = werr
+	}
+	return err
+}
+
+// highlight 依次将源代码中的每一段文本及其类别交给render，并输出render的结果
+// 词素之间的空白符号和注释由splitGap进一步划分
+func highlight(w io.Writer, chunk, chunkName string, render func(category, text string) string) error {
+	var buf strings.Builder
+	var lexErr error
+	prev := 0
+	l := lexer.NewLexer(chunk, chunkName)
+	for {
+		_, _, kind, _, err := l.TryNextToken()
+		if err != nil {
+			lexErr = err
+			buf.WriteString(render("", chunk[prev:]))
+			break
+		}
+		start, end := l.TokenSpan()
+		splitGap(chunk[prev:start], func(category, text string) {
+			buf.WriteString(render(category, text))
+		})
+		buf.WriteString(render(category(kind), chunk[start:end]))
+		prev = end
+		if kind == lexer.TOKEN_EOF {
+			break
+		}
+	}
+	// 词法错误优先于写入错误
+	if _, err := io.WriteString(w, buf.String()); lexErr == nil {
+		return err
+	}
+	return lexErr
+}
+
+func category(kind int) string {
+	switch kind {
+	case lexer.TOKEN_OP_AND, lexer.TOKEN_OP_OR, lexer.TOKEN_OP_NOT:
+		return Keyword
+	default:
+		return lexer.KindToCategory(kind)
+	}
+}
+
+// splitGap 将词素之间只包含空白符号和注释的文本划分为空白和注释
+// 注释以"--"开始，长注释到相同级别的闭长括号结束，短注释到行末结束
+func splitGap(gap string, emit func(category, text string)) {
+	for len(gap) > 0 {
+		i := strings.Index(gap, "--")
+		if i < 0 {
+			emit("", gap)
+			return
+		}
+		if i > 0 {
+			emit("", gap[:i])
+			gap = gap[i:]
+		}
+		end := strings.IndexAny(gap, "\r\n")
+		if level := longBracketLevel(gap[2:]); level >= 0 {
+			closing := "]" + strings.Repeat("=", level) + "]"
+			if end = strings.Index(gap, closing); end >= 0 {
+				end += len(closing)
+			}
+		}
+		if end < 0 {
+			end = len(gap)
+		}
+		emit(Comment, gap[:end])
+		gap = gap[end:]
+	}
+}
+
+// longBracketLevel 返回s开头的开长括号的级别，不是开长括号时返回-1
+func longBracketLevel(s string) int {
+	if !strings.HasPrefix(s, "[") {
+		return -1
+	}
+	level := 0
+	for level+1 < len(s) && s[level+1] == '=' {
+		level++
+	}
+	if level+1 < len(s) && s[level+1] == '[' {
+		return level
+	}
+	return -1
+}
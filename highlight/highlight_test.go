@@ -0,0 +1,77 @@
+package highlight
+
+import (
+	"bytes"
+	"errors"
+	"html"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/depressi0n/myLua/lexer"
+)
+
+var reANSI = regexp.MustCompile("\x1b\\[[0-9;]*m")
+var reTag = regexp.MustCompile("<[^>]*>")
+
+func TestHighlight(t *testing.T) {
+	data, err := ioutil.ReadFile("../lexer/hello_world.lua")
+	if err != nil {
+		t.Fatalf("fail to open file: %v", err)
+	}
+	chunks := []string{
+		string(data),
+		"local s = [==[ -- not a comment ]==] --[[ long\ncomment ]] x = 1 -- short\r\nreturn s <= \"<&>\"",
+	}
+	for _, chunk := range chunks {
+		var buf bytes.Buffer
+		if err := ANSI(&buf, chunk, "chunk"); err != nil {
+			t.Fatal(err)
+		}
+		if got := reANSI.ReplaceAllString(buf.String(), ""); got != chunk {
+			t.Errorf("ANSI output without colors differs from source:\n%s", got)
+		}
+		buf.Reset()
+		if err := HTML(&buf, chunk, "chunk"); err != nil {
+			t.Fatal(err)
+		}
+		if got := html.UnescapeString(reTag.ReplaceAllString(buf.String(), "")); got != chunk+"\n" {
+			t.Errorf("HTML output without tags differs from source:\n%s", got)
+		}
+	}
+}
+
+func TestHighlightCategories(t *testing.T) {
+	var buf bytes.Buffer
+	chunk := "if not x then return 'a' .. 0x10 end -- done"
+	if err := HTML(&buf, chunk, "chunk"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<span class="lua-keyword">if</span>`,
+		`<span class="lua-keyword">not</span>`,
+		` x `,
+		`<span class="lua-string">&#39;a&#39;</span>`,
+		`<span class="lua-operator">..</span>`,
+		`<span class="lua-number">0x10</span>`,
+		`<span class="lua-comment">-- done</span>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("missing %s in %s", want, buf.String())
+		}
+	}
+}
+
+func TestHighlightError(t *testing.T) {
+	var buf bytes.Buffer
+	chunk := "x = 1\ny = 'unfinished\nz = 2"
+	err := ANSI(&buf, chunk, "chunk")
+	var lexErr lexer.Error
+	if !errors.As(err, &lexErr) || !strings.Contains(lexErr.Msg, "unfinished string") {
+		t.Fatalf("got error %v, want unfinished string", err)
+	}
+	if got := reANSI.ReplaceAllString(buf.String(), ""); got != chunk {
+		t.Errorf("output differs from source:\n%s", got)
+	}
+}
@@ -0,0 +1,205 @@
+// Package hook 实现Lua5.4调试钩子的触发规则，供解释器的指令循环调用。
+// 事件的种类和顺序与Lua5.4 ldo.c和ldebug.c中的luaD_hook、luaG_traceexec一致
+package hook
+
+import (
+	"strings"
+
+	"github.com/depressi0n/myLua/binchunk"
+	"github.com/depressi0n/myLua/vm"
+)
+
+// Event 是钩子事件的种类，值与LUA_HOOKCALL等常量相同
+type Event int
+
+const (
+	Call Event = iota
+	Return
+	Line
+	Count
+	TailCall
+)
+
+// String 返回debug.sethook传给钩子函数的事件名
+func (e Event) String() string {
+	switch e {
+	case Call:
+		return "call"
+	case Return:
+		return "return"
+	case Line:
+		return "line"
+	case Count:
+		return "count"
+	case TailCall:
+		return "tail call"
+	}
+	return "?"
+}
+
+// Mask 表示需要触发的事件，值与LUA_MASKCALL等常量相同，TailCall包括在MaskCall中
+type Mask int
+
+const (
+	MaskCall   Mask = 1 << Call
+	MaskReturn Mask = 1 << Return
+	MaskLine   Mask = 1 << Line
+	MaskCount  Mask = 1 << Count
+)
+
+// ParseMask 按照debug.sethook的参数转换为 Mask：
+// s中的'c'、'r'、'l'分别表示调用、返回和行事件，count大于0时还触发计数事件
+func ParseMask(s string, count int) Mask {
+	var m Mask
+	if strings.ContainsRune(s, 'c') {
+		m |= MaskCall
+	}
+	if strings.ContainsRune(s, 'r') {
+		m |= MaskReturn
+	}
+	if strings.ContainsRune(s, 'l') {
+		m |= MaskLine
+	}
+	if count > 0 {
+		m |= MaskCount
+	}
+	return m
+}
+
+// String 返回debug.gethook格式的字符串，不包括计数事件
+func (m Mask) String() string {
+	var s []byte
+	if m&MaskCall != 0 {
+		s = append(s, 'c')
+	}
+	if m&MaskReturn != 0 {
+		s = append(s, 'r')
+	}
+	if m&MaskLine != 0 {
+		s = append(s, 'l')
+	}
+	return string(s)
+}
+
+// Info 是传给钩子函数的信息
+type Info struct {
+	Event Event
+	Proto *binchunk.Prototype // 触发事件的函数，Go函数为nil
+	Line  int                 // 行事件的行号，其他事件为-1
+}
+
+// Func 是钩子函数
+type Func func(Info)
+
+// Hooks 保存一个Lua状态的钩子设置，零值表示没有设置钩子。
+// 钩子函数执行期间忽略 Enter、Leave 和 Exec，不会触发新的事件，也不影响计数和行号的状态
+type Hooks struct {
+	fn        Func
+	mask      Mask
+	baseCount int
+	count     int  // 距离下一次计数事件还要执行的指令数
+	oldPC     int  // 上一次检查行事件的指令位置
+	deferred  bool // vararg函数的调用事件还没有触发
+	event     Event
+	inHook    bool
+}
+
+// Set 设置钩子，对应lua_sethook。fn为nil或mask为0时关闭所有钩子
+func (h *Hooks) Set(fn Func, mask Mask, count int) {
+	if fn == nil || mask == 0 {
+		fn, mask = nil, 0
+	}
+	h.fn, h.mask, h.baseCount, h.count = fn, mask, count, count
+}
+
+// Get 返回当前的钩子设置，对应lua_gethook、lua_gethookmask和lua_gethookcount
+func (h *Hooks) Get() (Func, Mask, int) {
+	return h.fn, h.mask, h.baseCount
+}
+
+// Active 判断是否设置了钩子，指令循环只在返回true时才需要调用 Exec，开销只是一次比较
+func (h *Hooks) Active() bool {
+	return h.mask != 0
+}
+
+func (h *Hooks) call(info Info) {
+	h.inHook = true
+	defer func() { h.inHook = false }()
+	h.fn(info)
+}
+
+// Enter 在开始执行函数p时调用，tail表示尾调用，p为nil表示Go函数。
+// vararg函数的调用事件推迟到VARARGPREP之后，即 Exec 第一次检查pc 1的时候
+func (h *Hooks) Enter(p *binchunk.Prototype, tail bool) {
+	if h.inHook {
+		return
+	}
+	event := Call
+	if tail {
+		event = TailCall
+	}
+	h.oldPC = 0
+	h.deferred = p != nil && p.IsVararg != 0 && len(p.Code) > 0 && vm.Instruction(p.Code[0]).Opcode() == vm.OP_VARARGPREP
+	h.event = event
+	if !h.deferred && h.mask&MaskCall != 0 {
+		h.call(Info{Event: event, Proto: p, Line: -1})
+	}
+}
+
+// Leave 在函数p返回之前调用，callerPC是调用者正在执行的指令位置，
+// 返回到调用者之后从这个位置继续检查行事件，避免在同一行上重复触发
+func (h *Hooks) Leave(p *binchunk.Prototype, callerPC int) {
+	if h.inHook {
+		return
+	}
+	if h.mask&MaskReturn != 0 {
+		h.call(Info{Event: Return, Proto: p, Line: -1})
+	}
+	h.deferred = false
+	h.oldPC = callerPC
+}
+
+// Exec 在执行函数p中位于pc的指令之前调用，按照luaG_traceexec触发计数事件和行事件：
+// 执行了Count条指令之后触发计数事件；
+// 跳转到前面（包括进入函数的第一条指令）或者进入新的一行时触发行事件
+func (h *Hooks) Exec(p *binchunk.Prototype, pc int) {
+	if h.inHook {
+		return
+	}
+	if h.deferred {
+		if pc == 0 {
+			// VARARGPREP不触发钩子
+			return
+		}
+		if h.mask&MaskCall != 0 {
+			h.call(Info{Event: h.event, Proto: p, Line: -1})
+		}
+		h.deferred = false
+		h.oldPC = 1 // 下一条指令看作新的一行
+	}
+	if h.mask&MaskCount != 0 {
+		h.count--
+		if h.count == 0 {
+			h.count = h.baseCount
+			h.call(Info{Event: Count, Proto: p, Line: -1})
+		}
+	}
+	if h.mask&MaskLine != 0 {
+		oldPC := h.oldPC
+		if oldPC < 0 || oldPC >= len(p.Code) {
+			oldPC = 0
+		}
+		if pc <= oldPC || changedLine(p, oldPC, pc) {
+			h.call(Info{Event: Line, Proto: p, Line: p.LineForPC(pc)})
+		}
+		h.oldPC = pc
+	}
+}
+
+// changedLine 判断两条指令是否在不同的行上，没有行号信息时总是返回false
+func changedLine(p *binchunk.Prototype, oldPC, newPC int) bool {
+	if len(p.LineInfo) == 0 && len(p.Lines) == 0 {
+		return false
+	}
+	return p.LineForPC(oldPC) != p.LineForPC(newPC)
+}
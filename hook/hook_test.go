@@ -0,0 +1,124 @@
+package hook
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/depressi0n/myLua/asm"
+	"github.com/depressi0n/myLua/binchunk"
+)
+
+const testSource = `
+.source "@test.lua"
+.line 1
+        LOADI 0 0
+.line 2
+loop:   ADDI 0 0 1
+        MMBINI 0 1 6
+.line 3
+        LTI 0 3 0
+        JMP loop
+.line 4
+        RETURN1 0
+        RETURN0
+`
+
+func assemble(t *testing.T, src string) *binchunk.Prototype {
+	p, err := asm.Assemble([]byte(src), "test.lasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// record 设置记录所有事件的钩子
+func record(h *Hooks, mask string, count int) *[]string {
+	var events []string
+	h.Set(func(info Info) {
+		if info.Event == Line {
+			events = append(events, fmt.Sprintf("line %d", info.Line))
+		} else {
+			events = append(events, info.Event.String())
+		}
+		// 钩子函数中不会触发新的事件
+		h.Exec(info.Proto, 0)
+	}, ParseMask(mask, count), count)
+	return &events
+}
+
+func TestHooks(t *testing.T) {
+	p := assemble(t, testSource)
+	var h Hooks
+	events := record(&h, "crl", 4)
+	h.Enter(p, false)
+	// 执行三次循环，MMBINI在ADDI成功时被跳过
+	for _, pc := range []int{0, 1, 3, 4, 1, 3, 4, 1, 3, 5} {
+		h.Exec(p, pc)
+	}
+	h.Leave(p, -1)
+	want := []string{"call", "line 1", "line 2", "line 3", "count", "line 2", "line 3",
+		"count", "line 2", "line 3", "line 4", "return"}
+	if !reflect.DeepEqual(*events, want) {
+		t.Errorf("got %q, want %q", *events, want)
+	}
+
+	// 被调用的函数返回之后，在同一行上继续执行不触发行事件
+	*events = nil
+	h.Set(h.fn, MaskLine|MaskCall, 0)
+	h.Exec(p, 1)
+	h.Enter(p, true)
+	h.Exec(p, 0)
+	h.Leave(p, 1)
+	h.Exec(p, 2)
+	h.Exec(p, 3)
+	want = []string{"line 2", "tail call", "line 1", "line 3"}
+	if !reflect.DeepEqual(*events, want) {
+		t.Errorf("got %q, want %q", *events, want)
+	}
+
+	*events = nil
+	h.Set(nil, MaskLine, 0)
+	if h.Active() {
+		t.Errorf("Set(nil, ...) did not clear the hook")
+	}
+}
+
+func TestVarargHooks(t *testing.T) {
+	p := assemble(t, `
+.vararg
+        VARARGPREP 0
+.line 1
+        LOADI 0 0
+        RETURN0
+`)
+	var h Hooks
+	events := record(&h, "cl", 0)
+	h.Enter(p, false)
+	for pc := range p.Code {
+		h.Exec(p, pc)
+	}
+	if want := []string{"call", "line 1"}; !reflect.DeepEqual(*events, want) {
+		t.Errorf("got %q, want %q", *events, want)
+	}
+}
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		s     string
+		count int
+		mask  Mask
+		str   string
+	}{
+		{"", 0, 0, ""},
+		{"lrc", 0, MaskCall | MaskReturn | MaskLine, "crl"},
+		{"l", 10, MaskLine | MaskCount, "l"},
+		{"x", 0, 0, ""},
+	}
+	for _, test := range tests {
+		m := ParseMask(test.s, test.count)
+		if m != test.mask || m.String() != test.str {
+			t.Errorf("ParseMask(%q, %d) = %d (%q), want %d (%q)", test.s, test.count, m, m, test.mask, test.str)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package lexer
 
+import "strings"
+
 // 词素定义
 const (
 	TOKEN_EOF        = iota // end of file
@@ -73,6 +75,29 @@ const (
 	TOKEN_OP_BXOR = TOKEN_OP_WAVE  //
 )
 
+// KindToCategory 返回词素所属的类别，包括separator，operator，keyword，
+// identifier，number，string以及other
+func KindToCategory(kind int) string {
+	switch {
+	case kind < TOKEN_SEP_SEMI:
+		return "other"
+	case kind <= TOKEN_SEP_RCURLY:
+		return "separator"
+	case kind <= TOKEN_OP_NOT:
+		return "operator"
+	case kind <= TOKEN_KW_WHILE:
+		return "keyword"
+	case kind == TOKEN_IDENTIFIER:
+		return "identifier"
+	case kind == TOKEN_NUMBER:
+		return "number"
+	case kind == TOKEN_STRING:
+		return "string"
+	default:
+		return "other"
+	}
+}
+
 // 部分词素对应的保留关键字
 // and    break  do        else    elseif  end
 // false  for    function  goto    if      in
@@ -135,3 +160,52 @@ func isDigit(c byte) bool {
 func isLetter(c uint8) bool {
 	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
 }
+
+// isHexDigit 判断当前字符是否是十六进制数字
+func isHexDigit(c byte) bool {
+	return isDigit(c) || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// isSpace 判断当前字符是否是'\z'之后可以跳过的空白符号
+func isSpace(c byte) bool {
+	switch c {
+	case '\t', '\n', '\f', '\r', ' ':
+		return true
+	default:
+		return false
+	}
+}
+
+// countNewLines 统计字符串中换行符序列的个数
+// "\r\n"和"\n\r"都只算作一个换行符
+func countNewLines(str string) int {
+	cnt := 0
+	for i := 0; i < len(str); i++ {
+		if isNewLine(str[i]) {
+			if i+1 < len(str) && isNewLine(str[i+1]) && str[i+1] != str[i] {
+				i++
+			}
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// normalizeNewLines 将字符串中所有的换行符序列统一替换为'\n'
+func normalizeNewLines(str string) string {
+	if strings.IndexByte(str, '\r') < 0 {
+		return str
+	}
+	var buf strings.Builder
+	for i := 0; i < len(str); i++ {
+		if isNewLine(str[i]) {
+			if i+1 < len(str) && isNewLine(str[i+1]) && str[i+1] != str[i] {
+				i++
+			}
+			buf.WriteByte('\n')
+		} else {
+			buf.WriteByte(str[i])
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,49 @@
+package lexer
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// tryTokenize 读取全部token，遇到词法错误时返回错误
+func tryTokenize(t *testing.T, lexer *Lexer, limit int) ([]tokenInfo, error) {
+	var tokens []tokenInfo
+	for {
+		line, column, kind, token, err := lexer.TryNextToken()
+		if err != nil {
+			return tokens, err
+		}
+		tokens = append(tokens, tokenInfo{line, column, kind, token})
+		if kind == TOKEN_EOF {
+			return tokens, nil
+		}
+		// 除了EOF之外每个token至少占用一个字节
+		if len(tokens) > limit {
+			t.Fatalf("more than %d tokens", limit)
+		}
+	}
+}
+
+func FuzzLexer(f *testing.F) {
+	data, err := ioutil.ReadFile("hello_world.lua")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(string(data))
+	f.Add(`s = [==[ long ]] string ]=] ]==] .. "short\z
+		string" .. 'a\'b\x41\u{7FF}\065' -- comment
+	x = 0x1Fp4 + 3.25e-2 + .5 --[[ long
+	comment ]] y = a.b:c(...) // 2 ~= 3 >> 1 << 2 :: label ::`)
+	f.Add("a = [[\r\nunfinished long string")
+
+	f.Fuzz(func(t *testing.T, chunk string) {
+		want, wantErr := tryTokenize(t, NewLexer(chunk, "chunk"), len(chunk))
+		got, gotErr := tryTokenize(t, NewLexerFromReader(iotest.OneByteReader(strings.NewReader(chunk)), "chunk"), len(chunk))
+		if !reflect.DeepEqual(got, want) || gotErr != wantErr {
+			t.Fatalf("NewLexerFromReader: got %v, %v, want %v, %v", got, gotErr, want, wantErr)
+		}
+	})
+}
@@ -3,18 +3,29 @@ package lexer
 import (
 	"bytes"
 	"fmt"
+	"github.com/depressi0n/myLua/number"
+	"io"
 	"regexp"
 	"strconv"
 	"strings"
 )
 
+// bufferSize 从输入流读取源代码时每次读取的字节数
+const bufferSize = 4096
+
 // Lexer 定义一个词法分析器，将输入流进行Token化即执行词法分析过程的数据结构
 type Lexer struct {
-	chunk     string // 源代码
+	chunk     string // 源代码，流式读取时为缓冲区中尚未处理的部分
 	chunkName string // 源文件名称
 	curLine   int    // 当前行号
 	curColumn int    // 当前列号
 
+	// 流式读取源代码
+	// reader为nil表示chunk已经包含全部剩余的源代码
+	// window是读入源代码的缓冲区，容量按需成倍增长，只与最长的token有关
+	reader io.Reader
+	window []byte
+
 	// 往后查看下一个token
 	// 对当前状态进行备份，然后读取下一个token，记录类型
 	// 恢复状态，并缓存这个token
@@ -22,6 +33,13 @@ type Lexer struct {
 	nextTokenKind   int
 	nextTokenLine   int
 	nextTokenColumn int
+	nextTokenStart  int
+	nextTokenEnd    int
+
+	// 已经处理过的字节数，以及最近一次返回的token在源代码中的位置
+	offset     int
+	tokenStart int
+	tokenEnd   int
 }
 
 // NewLexer 创建一个词法分析器并初始化
@@ -34,8 +52,58 @@ func NewLexer(chunk string, chunkName string) *Lexer {
 	}
 }
 
+// NewLexerFromReader 创建一个从输入流中读取源代码的词法分析器
+// 源代码按需读入一个有界的缓冲区，内存占用只与最长的token有关，与源代码大小无关
+// 产生的token及其行列号与 NewLexer 处理完整源代码时完全相同
+func NewLexerFromReader(reader io.Reader, chunkName string) *Lexer {
+	return &Lexer{
+		chunkName: chunkName,
+		curLine:   1,
+		curColumn: 1,
+		reader:    reader,
+	}
+}
+
+// fill 从输入流中读取源代码，直到缓冲区中至少有n个字节或者输入流结束
+// 返回缓冲区中是否有至少n个字节
+func (l *Lexer) fill(n int) bool {
+	if len(l.chunk) >= n || l.reader == nil {
+		return len(l.chunk) >= n
+	}
+	// 尚未处理的部分移到窗口的开头，已经处理过的源代码随之丢弃，
+	// 读入的内容直接追加在后面，每次fill只转换一次字符串，
+	// 配合 grow 成倍扩大需要的长度，读取很长的token的总开销与token长度成线性关系
+	w := append(l.window[:0], l.chunk...)
+	for len(w) < n && l.reader != nil {
+		if cap(w)-len(w) < bufferSize {
+			w = append(w, make([]byte, bufferSize)...)[:len(w)]
+		}
+		cnt, err := l.reader.Read(w[len(w):cap(w)])
+		w = w[:len(w)+cnt]
+		if err == io.EOF {
+			l.reader = nil
+		} else if err != nil {
+			l.reader = nil
+			l.chunk = string(w)
+			l.window = w
+			l.error("read error: %v", err)
+		}
+	}
+	l.window = w
+	l.chunk = string(w)
+	return len(l.chunk) >= n
+}
+
+// grow 在缓冲区已有内容的基础上至少再读入一倍的源代码，返回是否读到了新的内容
+func (l *Lexer) grow() bool {
+	n := len(l.chunk)
+	l.fill(2*n + bufferSize)
+	return len(l.chunk) > n
+}
+
 // hasPrefix 判断Lexer正在处理的当前位置是否以s作为前缀
 func (l *Lexer) hasPrefix(prefix string) bool {
+	l.fill(len(prefix))
 	return strings.HasPrefix(l.chunk, prefix)
 }
 
@@ -44,7 +112,7 @@ func (l *Lexer) hasPrefix(prefix string) bool {
 // 空白字符包括'\t', '\n', '\v', '\f', '\r', ' '
 // 需要更新Lexer的当前处理行
 func (l *Lexer) skipWhiteSpaces() {
-	for len(l.chunk) > 0 {
+	for l.fill(1) {
 		if l.hasPrefix("--") {
 			l.skipComment()
 		} else if l.hasPrefix("\r\n") || l.hasPrefix("\n\r") {
@@ -74,6 +142,8 @@ func (l *Lexer) NextToken() (int, int, int, string) {
 
 		l.curLine = l.nextTokenLine
 		l.curColumn = l.nextTokenColumn
+		l.tokenStart = l.nextTokenStart
+		l.tokenEnd = l.nextTokenEnd
 		l.nextTokenLine = 0
 		l.nextTokenColumn = 0
 		return line, column, kind, token
@@ -81,7 +151,9 @@ func (l *Lexer) NextToken() (int, int, int, string) {
 
 	// 跳过空白符号和注释
 	l.skipWhiteSpaces()
-	if len(l.chunk) == 0 {
+	l.tokenStart = l.offset
+	defer func() { l.tokenEnd = l.offset }()
+	if !l.fill(1) {
 		return l.curLine, l.curColumn, TOKEN_EOF, "EOF"
 	}
 
@@ -198,7 +270,7 @@ func (l *Lexer) NextToken() (int, int, int, string) {
 		} else if l.hasPrefix("..") {
 			l.next(2)
 			return l.curLine, l.curColumn, TOKEN_OP_CONCAT, ".."
-		} else if len(l.chunk) == 1 || // 仅有一个.号
+		} else if !l.fill(2) || // 仅有一个.号
 			!isDigit(l.chunk[1]) { // 后续跟随有非数字字符，表示成员？
 			l.next(1)
 			return l.curLine, l.curColumn, TOKEN_SEP_DOT, "."
@@ -236,6 +308,7 @@ func (l *Lexer) NextToken() (int, int, int, string) {
 func (l *Lexer) next(n int) {
 	l.chunk = l.chunk[n:]
 	l.curColumn += n
+	l.offset += n
 }
 
 // skipComment 跳过注释
@@ -246,46 +319,67 @@ func (l *Lexer) skipComment() {
 	l.next(2) // 跳过"--"
 	// 长注释 [[some comment]]
 	if l.hasPrefix("[") {
-		if !(reOpeningLongBracket.FindString(l.chunk) == "") {
+		if l.longBracketLevel() >= 0 {
 			l.scanLongString()
 			return
 		}
 	}
 	// 短注释遇到换行符即表示注释结束（可以理解为单行注释）
-	for len(l.chunk) > 0 && !isNewLine(l.chunk[0]) {
+	for l.fill(1) && !isNewLine(l.chunk[0]) {
 		l.next(1)
 	}
 }
 
-var reNewLine = regexp.MustCompile("\r\n|\n\r|\n|\r")
-
+// longBracketLevel 返回当前位置开长括号的级别即[[之间=的个数，不是开长括号时返回-1
 // 字面字符串包括一种由长括号包含的方式定义，[[之间可以包含若干个=
 // 两个正的方括号间插入 n 个等号定义为 第 n 级开长括号
 // 不受分行限制，不处理任何转义符，并且忽略掉任何不同级别的长括号。
 // 其中碰到的任何形式的换行串（回车、换行、回车加换行、换行加回车），
 // 都会被转换为单个换行符。
-var reOpeningLongBracket = regexp.MustCompile(`\[=*\[`)
+func (l *Lexer) longBracketLevel() int {
+	n := 1
+	for l.fill(n+1) && l.chunk[n] == '=' {
+		n++
+	}
+	if l.fill(n+1) && l.chunk[n] == '[' {
+		return n - 1
+	}
+	return -1
+}
 
 // 寻找左右长方括号，如果任何一个都找不到，则语法错误
 // 提取字符串字面量，把左方括号和右方括号去掉，换行符序列统一换成换行符
 // 将第一个换行符去掉后得到最终字符串
 func (l *Lexer) scanLongString() string {
-	openingLongBracket := reOpeningLongBracket.FindString(l.chunk)
-	if openingLongBracket == "" {
+	level := l.longBracketLevel()
+	if level < 0 {
 		l.error("invalid long string delimiter near '%s", l.chunk[0:2])
 	}
 	// 结尾的字符串必须以相同级别的闭长括号作为结尾
-	closingLongBracket := strings.Replace(openingLongBracket, "[", "]", -1)
-	closingLongBracketIdx := strings.Index(l.chunk, closingLongBracket)
+	openingLen := level + 2
+	closingLongBracket := "]" + strings.Repeat("=", level) + "]"
+	closingLongBracketIdx := strings.Index(l.chunk[openingLen:], closingLongBracket)
+	// 缓冲区中没有找到时继续读入源代码，只需要从上次查找的末尾附近继续查找
+	for from := openingLen; closingLongBracketIdx < 0; {
+		if n := len(l.chunk) - len(closingLongBracket) + 1; n > from {
+			from = n
+		}
+		if !l.grow() {
+			break
+		}
+		if idx := strings.Index(l.chunk[from:], closingLongBracket); idx >= 0 {
+			closingLongBracketIdx = from + idx - openingLen
+		}
+	}
 	// 没有找到则表示长括号没有正常结束
 	if closingLongBracketIdx < 0 {
 		l.error("unfinished long string or comment")
 	}
-	str := l.chunk[len(openingLongBracket):closingLongBracketIdx]
-	l.next(closingLongBracketIdx + len(closingLongBracket))
+	str := l.chunk[openingLen : openingLen+closingLongBracketIdx]
+	l.next(openingLen + closingLongBracketIdx + len(closingLongBracket))
 
 	// 将长字符串中所有的换行符统一表示为'\n'
-	str = reNewLine.ReplaceAllString(str, "\n")
+	str = normalizeNewLines(str)
 	// 更新行号和列号
 	l.curLine += strings.Count(str, "\n")
 	l.curColumn = 0
@@ -302,33 +396,71 @@ func (l *Lexer) scanLongString() string {
 //									 '\\' （反斜杠）， '\"' （双引号）， 以及 '\'' (单引号)。
 // 在反斜杠后跟一个真正的换行等价于在字符串中写一个换行符。
 // 转义串 '\z' 会忽略其后的一系列空白符，包括换行； 在需要对一个很长的字符串常量断行为多行并希望在每个新行保持缩进时非常有用。
-// (?s) 表示单行模式，将更改.的含义，使它与每一个字符匹配（包括换行符\n）。
-var reShortStr = regexp.MustCompile(`(?s)(^'(\\\\|\\'|\\\n|\\z\s*|[^'\n])*')|(^"(\\\\|\\"|\\\n|\\z\s*|[^"\n])*")`)
-
+// 字符串中不能直接出现换行符，反斜杠和其后的一个字符总是一起跳过，具体的转义在escape中完成。
 func (l *Lexer) scanShortString() string {
-	if str := reShortStr.FindString(l.chunk); str != "" {
-		l.next(len(str))
-		// 去掉'或"
-		str = str[1 : len(str)-1]
-		// 检查str中是否需要完成转义
-		if strings.Index(str, `\`) >= 0 {
-			// 寻找其中的换行符，更新行号和列号
-			l.curLine += len(reNewLine.FindAllString(str, -1))
-			l.curColumn = 0
-			// 对获取对字符串进行转义表示
-			str = l.escape(str)
+	quote := l.chunk[0]
+	n := 1
+	hasEscape := false
+	for {
+		if !l.fill(n + 1) {
+			l.error("unfinished string")
+		}
+		c := l.chunk[n]
+		if c == quote {
+			break
+		}
+		switch c {
+		case '\n':
+			l.error("unfinished string")
+		case '\\':
+			hasEscape = true
+			if !l.fill(n + 2) {
+				l.error("unfinished string")
+			}
+			n += 2
+			if l.chunk[n-1] == 'z' {
+				for l.fill(n+1) && isSpace(l.chunk[n]) {
+					n++
+				}
+			}
+		default:
+			n++
 		}
-		return str
 	}
-	l.error("unfinished string")
-	return ""
+	// 去掉'或"
+	str := l.chunk[1:n]
+	l.next(n + 1)
+	// 检查str中是否需要完成转义
+	if hasEscape {
+		// 寻找其中的换行符，更新行号和列号
+		l.curLine += countNewLines(str)
+		l.curColumn = 0
+		// 对获取对字符串进行转义表示
+		str = l.escape(str)
+	}
+	return str
+}
+
+// Error 描述一个词法错误，NextToken等方法遇到错误时以 Error 值panic
+type Error struct {
+	ChunkName string
+	Line      int
+	Column    int
+	Msg       string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.ChunkName, e.Line, e.Column, e.Msg)
 }
 
 // error用于抛出错误信息
 func (l *Lexer) error(f string, a ...interface{}) {
-	err := fmt.Sprintf(f, a...)
-	err = fmt.Sprintf("%s:%d:%d: %s", l.chunkName, l.curLine, l.curColumn, err)
-	panic(err)
+	panic(Error{
+		ChunkName: l.chunkName,
+		Line:      l.curLine,
+		Column:    l.curColumn,
+		Msg:       fmt.Sprintf(f, a...),
+	})
 }
 
 // \ddd ， 这里的 ddd 是一到三个十进制数字。
@@ -423,6 +555,7 @@ func (l *Lexer) escape(str string) string {
 				str = str[len(found):]
 				continue
 			}
+			l.error("hexadecimal digit expected near '%s'", escapeNear(str, 4))
 		case 'u': // \u{XXX}
 			if found := reUnicodeEscapeSeq.FindString(str); found != "" {
 				d, err := strconv.ParseInt(found[3:len(found)-1], 16, 32)
@@ -433,6 +566,7 @@ func (l *Lexer) escape(str string) string {
 				}
 				l.error("UTF-8 value too large near '%s'", found)
 			}
+			l.error("missing '{' or '}' in \\u{xxxx} near '%s'", escapeNear(str, 12))
 		case 'z':
 			str = str[2:]
 			for len(str) > 0 && isWhiteSpace(str[0]) {
@@ -446,31 +580,81 @@ func (l *Lexer) escape(str string) string {
 	return buf.String()
 }
 
+// escapeNear 返回错误信息中展示的转义序列，最多n个字节
+func escapeNear(str string, n int) string {
+	if len(str) > n {
+		return str[:n]
+	}
+	return str
+}
+
 // 数字字面量由可选的小数部分和可选的十为底的指数部分构成：
 // 指数部分用字符 'e' 或 'E' 来标记。
 // Lua 也接受以 0x 或 0X 开头的 16 进制常量。
 // 16 进制常量也接受小数加指数部分的形式，指数部分是以二为底， 用字符 'p' 或 'P' 来标记。
 // 数字常量中包含小数点或指数部分时，被认为是一个浮点数； 否则被认为是一个整数。
-var reNumber = regexp.MustCompile(`^0[xX][\da-fA-F]*(\.[\da-fA-F]*)?([pP][+\-]?\d+)?|^\d*(\.\d*)?([eE][+\-]?\d+)?`)
-
+// 与Lua5.4(llex.c中的read_numeral)一样，先读入所有可能属于数字的字符，
+// 再按照 number.ParseNumber 的规则检查，因此3..2和0x这样的数字会报告错误
 func (l *Lexer) scanNumber() string {
-	return l.scan(reNumber)
+	n := 0
+	if l.chunk[0] == '.' {
+		n = 1
+	}
+	l.fill(n + 2)
+	lower, upper := byte('e'), byte('E')
+	if l.chunk[n] == '0' && len(l.chunk) > n+1 && (l.chunk[n+1] == 'x' || l.chunk[n+1] == 'X') {
+		lower, upper = 'p', 'P'
+		n++
+	}
+	for n++; l.fill(n + 1); n++ {
+		c := l.chunk[n]
+		if c == lower || c == upper {
+			// 指数部分可以带有符号
+			if l.fill(n+2) && (l.chunk[n+1] == '+' || l.chunk[n+1] == '-') {
+				n++
+			}
+		} else if !isHexDigit(c) && c != '.' {
+			break
+		}
+	}
+	// 紧接着字母的数字一定是错误的
+	if l.fill(n+1) && (l.chunk[n] == '_' || isLetter(l.chunk[n])) {
+		n++
+	}
+	token := l.chunk[:n]
+	if _, ok := number.ParseNumber(token); !ok {
+		l.error("malformed number near '%s'", token)
+	}
+	l.next(n)
+	return token
 }
 
 // 标识符可以是由非数字打头的任意字母下划线和数字构成的字符串。
-// 标识符可用于对变量、表的域、以及标签命名。^[a-z_][a-z\d_]*
-var reIdentifier = regexp.MustCompile(`(?i)^[a-z_][a-z\d_]*`)
-
+// 标识符可用于对变量、表的域、以及标签命名。[a-zA-Z_][a-zA-Z0-9_]*
 func (l *Lexer) scanIdentifier() string {
-	return l.scan(reIdentifier)
+	n := 1
+	for l.fill(n+1) && (l.chunk[n] == '_' || isLetter(l.chunk[n]) || isDigit(l.chunk[n])) {
+		n++
+	}
+	token := l.chunk[:n]
+	l.next(n)
+	return token
 }
 
-func (l *Lexer) scan(re *regexp.Regexp) string {
-	if token := re.FindString(l.chunk); token != "" {
-		l.next(len(token))
-		return token
-	}
-	panic("unreachable")
+// TryNextToken 与 NextToken 相同，但词法错误以 Error 的形式返回，不会panic，
+// 用于处理来源不可信的源代码，返回错误之后不能继续使用该词法分析器
+func (l *Lexer) TryNextToken() (line, column, kind int, token string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(Error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	line, column, kind, token = l.NextToken()
+	return
 }
 
 // LookAhead 预读并缓存下一个token，同时返回下一个token的类型
@@ -482,6 +666,7 @@ func (l *Lexer) LookAhead() int {
 	// 保存词法分析器当前状态
 	currentLine := l.curLine
 	currentColumn := l.curColumn
+	tokenStart, tokenEnd := l.tokenStart, l.tokenEnd
 	line, column, kind, token := l.NextToken()
 	// 恢复词法分析器状态，并缓存下一个token
 	l.curLine = currentLine
@@ -490,6 +675,8 @@ func (l *Lexer) LookAhead() int {
 	l.nextTokenColumn = column
 	l.nextTokenKind = kind
 	l.nextToken = token
+	l.nextTokenStart, l.nextTokenEnd = l.tokenStart, l.tokenEnd
+	l.tokenStart, l.tokenEnd = tokenStart, tokenEnd
 	return kind
 }
 
@@ -511,3 +698,9 @@ func (l *Lexer) NexIdentifier() (line int, column int, token string) {
 func (l *Lexer) Line() int {
 	return l.curLine
 }
+
+// TokenSpan 返回最近一次由NextToken返回的token在源代码中的字节偏移区间[start, end)
+// 区间之外的源代码只包含空白符号和注释
+func (l *Lexer) TokenSpan() (start, end int) {
+	return l.tokenStart, l.tokenEnd
+}
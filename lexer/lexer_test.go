@@ -2,33 +2,19 @@ package lexer
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 var chunk string
 var chunkName string
 
-func kindToCategory(kind int) string {
-	switch {
-	case kind < TOKEN_SEP_SEMI:
-		return "other"
-	case kind <= TOKEN_SEP_RCURLY:
-		return "separator"
-	case kind <= TOKEN_OP_NOT:
-		return "operator"
-	case kind <= TOKEN_KW_WHILE:
-		return "keyword"
-	case kind == TOKEN_IDENTIFIER:
-		return "identifier"
-	case kind == TOKEN_NUMBER:
-		return "number"
-	case kind == TOKEN_STRING:
-		return "string"
-	default:
-		return "other"
-	}
-}
 func TestLexer(t *testing.T) {
 	chunkName = "hello_world.lua"
 	data, err := ioutil.ReadFile(chunkName)
@@ -40,9 +26,269 @@ func TestLexer(t *testing.T) {
 	for {
 		line, column, kind, token := lexer.NextToken()
 		fmt.Printf("[%2d:%4d] [%-10s] %s\n",
-			line, column, kindToCategory(kind), token)
+			line, column, KindToCategory(kind), token)
 		if kind == TOKEN_EOF {
 			break
 		}
 	}
 }
+
+type tokenInfo struct {
+	line, column, kind int
+	token              string
+}
+
+// tokenize 读取全部token，遇到词法错误时返回错误信息
+func tokenize(lexer *Lexer) (tokens []tokenInfo, err interface{}) {
+	defer func() {
+		err = recover()
+	}()
+	for {
+		line, column, kind, token := lexer.NextToken()
+		tokens = append(tokens, tokenInfo{line, column, kind, token})
+		if kind == TOKEN_EOF {
+			return
+		}
+	}
+}
+
+func TestLexerFromReader(t *testing.T) {
+	data, err := ioutil.ReadFile("hello_world.lua")
+	if err != nil {
+		t.Fatalf("fail to open file named %s: %v", "hello_world.lua", err)
+	}
+	chunks := []string{
+		string(data),
+		`s = [==[ long ]] string ]=] ]==] .. "short\z
+			string" .. 'a\'b' -- comment
+		x = 0x1Fp4 + 3.25e-2 + .5 --[[ long
+		comment ]] y = "unfinished`,
+		"a = [[\r\nunfinished long string",
+		strings.Repeat("local identifier_"+strings.Repeat("x", 5000)+" = 1\n", 3),
+	}
+	for i, chunk := range chunks {
+		want, wantErr := tokenize(NewLexer(chunk, "chunk"))
+		readers := []io.Reader{
+			strings.NewReader(chunk),
+			iotest.OneByteReader(strings.NewReader(chunk)),
+			iotest.DataErrReader(strings.NewReader(chunk)),
+		}
+		for _, reader := range readers {
+			got, gotErr := tokenize(NewLexerFromReader(reader, "chunk"))
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("chunk %d: tokens mismatch", i)
+			}
+			if gotErr != wantErr {
+				t.Fatalf("chunk %d: got error %v, want %v", i, gotErr, wantErr)
+			}
+		}
+	}
+}
+
+// TestLexerFromReaderLongToken 流式读取很长的token时，分配的内存与token长度成线性关系
+func TestLexerFromReaderLongToken(t *testing.T) {
+	body := strings.Repeat("x", 4<<20)
+	chunk := "s = [[" + body + "]]"
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	tokens, err := tokenize(NewLexerFromReader(strings.NewReader(chunk), "chunk"))
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 4 || tokens[2].token != body {
+		t.Fatalf("got %d tokens, want the long string", len(tokens))
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 64<<20 {
+		t.Errorf("allocated %d bytes", alloc)
+	}
+}
+
+// 以下是基于正则表达式的扫描实现，作为手写扫描器的参照
+
+var reNewLine = regexp.MustCompile("\r\n|\n\r|\n|\r")
+var reOpeningLongBracket = regexp.MustCompile(`^\[=*\[`)
+var reShortStr = regexp.MustCompile(`(?s)(^'(\\\\|\\'|\\\n|\\z\s*|[^'\n])*')|(^"(\\\\|\\"|\\\n|\\z\s*|[^"\n])*")`)
+var reNumber = regexp.MustCompile(`^0[xX][\da-fA-F]*(\.[\da-fA-F]*)?([pP][+\-]?\d+)?|^\d*(\.\d*)?([eE][+\-]?\d+)?`)
+var reIdentifier = regexp.MustCompile(`(?i)^[a-z_][a-z\d_]*`)
+
+func (l *Lexer) matchRegexp(re *regexp.Regexp) string {
+	for {
+		loc := re.FindStringIndex(l.chunk)
+		if loc != nil && loc[1] < len(l.chunk) {
+			return l.chunk[loc[0]:loc[1]]
+		}
+		if !l.grow() {
+			if loc == nil {
+				return ""
+			}
+			return l.chunk[loc[0]:loc[1]]
+		}
+	}
+}
+
+func (l *Lexer) scanLongStringRegexp() string {
+	openingLongBracket := l.matchRegexp(reOpeningLongBracket)
+	if openingLongBracket == "" {
+		l.error("invalid long string delimiter near '%s", l.chunk[0:2])
+	}
+	closingLongBracket := strings.Replace(openingLongBracket, "[", "]", -1)
+	closingLongBracketIdx := strings.Index(l.chunk, closingLongBracket)
+	for closingLongBracketIdx < 0 && l.grow() {
+		closingLongBracketIdx = strings.Index(l.chunk, closingLongBracket)
+	}
+	if closingLongBracketIdx < 0 {
+		l.error("unfinished long string or comment")
+	}
+	str := l.chunk[len(openingLongBracket):closingLongBracketIdx]
+	l.next(closingLongBracketIdx + len(closingLongBracket))
+	str = reNewLine.ReplaceAllString(str, "\n")
+	l.curLine += strings.Count(str, "\n")
+	l.curColumn = 0
+	if len(str) > 0 && str[0] == '\n' {
+		str = str[1:]
+	}
+	return str
+}
+
+func (l *Lexer) scanShortStringRegexp() string {
+	if str := l.matchRegexp(reShortStr); str != "" {
+		l.next(len(str))
+		str = str[1 : len(str)-1]
+		if strings.Index(str, `\`) >= 0 {
+			l.curLine += len(reNewLine.FindAllString(str, -1))
+			l.curColumn = 0
+			str = l.escape(str)
+		}
+		return str
+	}
+	l.error("unfinished string")
+	return ""
+}
+
+func (l *Lexer) scanRegexp(re *regexp.Regexp) string {
+	if token := l.matchRegexp(re); token != "" {
+		l.next(len(token))
+		return token
+	}
+	panic("unreachable")
+}
+
+func (l *Lexer) scanNumberRegexp() string {
+	return l.scanRegexp(reNumber)
+}
+
+func (l *Lexer) scanIdentifierRegexp() string {
+	return l.scanRegexp(reIdentifier)
+}
+
+var scannerCorpus = []string{
+	`a, _b, c1_D2, local_var, _ENV = 0, 42, 3.0, 3.1416, 314.16e-2, 0.31416E1, 34e1, .5, 5., 1e+10,
+	0xff, 0XBEBADA, 0x0.1E, 0xA23p-4, 0X1.921FB54442D18P+1, 0x.8p1, 0xA., 1234567890123456789012`,
+	`s = 'single' .. "double" .. 'it\'s' .. "say \"hi\"" .. 'tab\tnew\nline\\' .. "\65\066\0677"
+	t = "\x41\x62" .. '\u{48}\u{20AC}' .. "skip \z
+	     whitespace" .. 'line\
+	continued' .. "a\r\n" .. ''
+	u = [[]] .. [[
+first newline dropped]] .. [==[ contains ]] and ]=] ]==] .. [=[
+crlf` + "\r\n" + `and lfcr` + "\n\r" + `and cr` + "\r" + `]=]`,
+	"--[[ long\r\ncomment ]] x = 1 --[==[ another ]==] y = 'z' -- short [[ not long\nz = 2",
+}
+
+func TestScannerDifferential(t *testing.T) {
+	data, err := ioutil.ReadFile("hello_world.lua")
+	if err != nil {
+		t.Fatalf("fail to open file named %s: %v", "hello_world.lua", err)
+	}
+	for i, chunk := range append(scannerCorpus, string(data)) {
+		l := NewLexer(chunk, "chunk")
+		for {
+			ref := *l
+			ref.skipWhiteSpaces()
+			line, column, kind, token := l.NextToken()
+			if kind == TOKEN_EOF {
+				break
+			}
+			var want string
+			switch c := ref.chunk[0]; {
+			case kind == TOKEN_STRING && c == '[':
+				want = ref.scanLongStringRegexp()
+			case kind == TOKEN_STRING:
+				want = ref.scanShortStringRegexp()
+			case kind == TOKEN_NUMBER:
+				want = ref.scanNumberRegexp()
+			case c == '_' || isLetter(c):
+				want = ref.scanIdentifierRegexp()
+			default:
+				continue
+			}
+			if token != want || line != ref.curLine || column != ref.curColumn || l.chunk != ref.chunk {
+				t.Fatalf("chunk %d: got [%d:%d] %q, want [%d:%d] %q",
+					i, line, column, token, ref.curLine, ref.curColumn, want)
+			}
+		}
+	}
+}
+
+func benchmarkScan(b *testing.B, chunk string, scan func(l *Lexer) string) {
+	b.SetBytes(int64(len(chunk)))
+	for i := 0; i < b.N; i++ {
+		l := NewLexer(chunk, "chunk")
+		for l.skipWhiteSpaces(); len(l.chunk) > 0; l.skipWhiteSpaces() {
+			scan(l)
+		}
+	}
+}
+
+var (
+	benchNumbers     = strings.Repeat("42 3.1416 314.16e-2 0xA23p-4 0X1.921FB54442D18P+1 1234567890 ", 100)
+	benchIdentifiers = strings.Repeat("a _b c1_D2 local_variable thisIsAVeryLongIdentifierName ", 100)
+	benchShortString = strings.Repeat(`'single' "double" "with \"escapes\"\n" 'a much longer string without any escapes' `, 100)
+	benchLongString  = strings.Repeat("[[long]] [==[ contains ]] and ]=] ]==] [[\nmulti\nline\nstring]] ", 100)
+)
+
+func BenchmarkScanNumber(b *testing.B) {
+	benchmarkScan(b, benchNumbers, (*Lexer).scanNumber)
+}
+
+func BenchmarkScanNumberRegexp(b *testing.B) {
+	benchmarkScan(b, benchNumbers, (*Lexer).scanNumberRegexp)
+}
+
+func BenchmarkScanIdentifier(b *testing.B) {
+	benchmarkScan(b, benchIdentifiers, (*Lexer).scanIdentifier)
+}
+
+func BenchmarkScanIdentifierRegexp(b *testing.B) {
+	benchmarkScan(b, benchIdentifiers, (*Lexer).scanIdentifierRegexp)
+}
+
+func BenchmarkScanShortString(b *testing.B) {
+	benchmarkScan(b, benchShortString, (*Lexer).scanShortString)
+}
+
+func BenchmarkScanShortStringRegexp(b *testing.B) {
+	benchmarkScan(b, benchShortString, (*Lexer).scanShortStringRegexp)
+}
+
+func BenchmarkScanLongString(b *testing.B) {
+	benchmarkScan(b, benchLongString, (*Lexer).scanLongString)
+}
+
+func BenchmarkScanLongStringRegexp(b *testing.B) {
+	benchmarkScan(b, benchLongString, (*Lexer).scanLongStringRegexp)
+}
+
+func TestMalformedNumber(t *testing.T) {
+	for _, chunk := range []string{"3..2", "0x", "0x.p1", "1e", "1e+", "3.4.5", "12abc", "0xg", "1_000", "0x1p4.5", "x = .5e"} {
+		_, err := tokenize(NewLexer(chunk, "chunk"))
+		if e, ok := err.(Error); !ok || !strings.Contains(e.Msg, "malformed number near") {
+			t.Errorf("%q: got error %v, want malformed number", chunk, err)
+		}
+	}
+	for _, chunk := range []string{"3 .. 2", "0x10", "1e+10", "a.b", "t[1].x", "0xA23p-4", "x=.5"} {
+		if _, err := tokenize(NewLexer(chunk, "chunk")); err != nil {
+			t.Errorf("%q: unexpected error %v", chunk, err)
+		}
+	}
+}
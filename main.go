@@ -1,7 +1,51 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+)
+
+// command 定义mylua的一个子命令
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+// commands 所有子命令
+var commands = []*command{
+	highlightCommand,
+	chunkdiffCommand,
+	exportCommand,
+	asmCommand,
+	chunkstatCommand,
+	cfgCommand,
+	runCommand,
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: mylua <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "\t%s\n", cmd.usage)
+	}
+}
 
 func main() {
-	fmt.Println("Hello myLua")
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "mylua %s: %v\n", cmd.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "mylua: unknown command %q\n", os.Args[1])
+	usage()
+	os.Exit(2)
 }
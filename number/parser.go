@@ -0,0 +1,242 @@
+package number
+
+import (
+	"math"
+	"strconv"
+)
+
+// 按照Lua5.4的规则(lobject.c中的luaO_str2num)将字符串转换为数字
+// 字符串前后可以有空白符号，整数和浮点数都可以带有正负号
+// 十进制整数溢出时不作为整数，而是转换为浮点数
+// 十六进制整数溢出时按2^64取模回绕
+// 不接受inf和nan
+
+// maxSigDig 十六进制浮点数中最多读取的有效数字个数，多余的数字只用于计算指数
+const maxSigDig = 30
+
+// ParseInteger 将字符串转换为整数，对应lobject.c中的l_str2int
+func ParseInteger(str string) (int64, bool) {
+	i := skipSpaces(str, 0)
+	neg := false
+	if i < len(str) && str[i] == '-' {
+		neg = true
+		i++
+	} else if i < len(str) && str[i] == '+' {
+		i++
+	}
+	var a uint64
+	empty := true
+	if isHexPrefix(str, i) {
+		for i += 2; i < len(str) && isHexDigit(str[i]); i++ {
+			a = a*16 + uint64(hexValue(str[i])) // 溢出时回绕
+			empty = false
+		}
+	} else {
+		maxBy10 := uint64(math.MaxInt64 / 10)
+		maxLastD := uint64(math.MaxInt64 % 10)
+		for ; i < len(str) && isDigit(str[i]); i++ {
+			d := uint64(str[i] - '0')
+			// 溢出时不是整数，负数可以多表示一个
+			if a >= maxBy10 && (a > maxBy10 || d > maxLastD+boolToUint(neg)) {
+				return 0, false
+			}
+			a = a*10 + d
+			empty = false
+		}
+	}
+	i = skipSpaces(str, i)
+	if empty || i != len(str) {
+		return 0, false
+	}
+	if neg {
+		return int64(0 - a), true
+	}
+	return int64(a), true
+}
+
+// ParseFloat 将字符串转换为浮点数，对应lobject.c中的l_str2d
+func ParseFloat(str string) (float64, bool) {
+	i := skipSpaces(str, 0)
+	j := len(str)
+	for j > i && isSpace(str[j-1]) {
+		j--
+	}
+	str = str[i:j]
+	sign := 0
+	if len(str) > 0 && (str[0] == '-' || str[0] == '+') {
+		sign = 1
+	}
+	if isHexPrefix(str, sign) {
+		return parseHexFloat(str)
+	}
+	if !isDecimalFloat(str) {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		// 超出范围时与strtod一样得到inf
+		if ne, ok := err.(*strconv.NumError); !ok || ne.Err != strconv.ErrRange {
+			return 0, false
+		}
+	}
+	return f, true
+}
+
+// ParseNumber 将字符串转换为数字，先尝试转换为整数，失败后再尝试转换为浮点数
+// 对应lobject.c中的luaO_str2num，返回值是int64或float64
+func ParseNumber(str string) (interface{}, bool) {
+	if i, ok := ParseInteger(str); ok {
+		return i, true
+	}
+	if f, ok := ParseFloat(str); ok {
+		return f, true
+	}
+	return nil, false
+}
+
+// isDecimalFloat 判断字符串是否是strtod可以完整解析的十进制数
+// [+-]?(%d+.?%d*|.%d+)([eE][+-]?%d+)?
+func isDecimalFloat(str string) bool {
+	i := 0
+	if i < len(str) && (str[i] == '-' || str[i] == '+') {
+		i++
+	}
+	digits := 0
+	for ; i < len(str) && isDigit(str[i]); i++ {
+		digits++
+	}
+	if i < len(str) && str[i] == '.' {
+		for i++; i < len(str) && isDigit(str[i]); i++ {
+			digits++
+		}
+	}
+	if digits == 0 {
+		return false
+	}
+	if i < len(str) && (str[i] == 'e' || str[i] == 'E') {
+		i++
+		if i < len(str) && (str[i] == '-' || str[i] == '+') {
+			i++
+		}
+		if i == len(str) || !isDigit(str[i]) {
+			return false
+		}
+		for i < len(str) && isDigit(str[i]) {
+			i++
+		}
+	}
+	return i == len(str)
+}
+
+// parseHexFloat 转换十六进制浮点数，对应lobject.c中的lua_strx2number
+// 0x%x*(.%x*)?([pP][+-]?%d+)?，小数点前后至少有一个数字
+func parseHexFloat(str string) (float64, bool) {
+	i := 0
+	neg := false
+	if str[i] == '-' {
+		neg = true
+		i++
+	} else if str[i] == '+' {
+		i++
+	}
+	r := 0.0
+	sigDig, noSigDig := 0, 0 // 有效数字和前导零的个数
+	e := 0                   // 指数修正
+	hasDot := false
+	for i += 2; i < len(str); i++ {
+		if str[i] == '.' {
+			if hasDot {
+				break
+			}
+			hasDot = true
+		} else if isHexDigit(str[i]) {
+			if sigDig == 0 && str[i] == '0' {
+				noSigDig++
+			} else if sigDig++; sigDig <= maxSigDig {
+				r = r*16 + float64(hexValue(str[i]))
+			} else {
+				e++ // 忽略过多的数字，但仍然计入指数
+			}
+			if hasDot {
+				e--
+			}
+		} else {
+			break
+		}
+	}
+	if sigDig+noSigDig == 0 {
+		return 0, false
+	}
+	e *= 4 // 每个十六进制数字相当于乘以或者除以2^4
+	if i < len(str) && (str[i] == 'p' || str[i] == 'P') {
+		i++
+		expNeg := false
+		if i < len(str) && (str[i] == '-' || str[i] == '+') {
+			expNeg = str[i] == '-'
+			i++
+		}
+		if i == len(str) || !isDigit(str[i]) {
+			return 0, false
+		}
+		exp := 0
+		for ; i < len(str) && isDigit(str[i]); i++ {
+			if exp < math.MaxInt32/10 { // 足够表示任何有意义的指数，避免溢出
+				exp = exp*10 + int(str[i]-'0')
+			}
+		}
+		if expNeg {
+			exp = -exp
+		}
+		e += exp
+	}
+	if i != len(str) {
+		return 0, false
+	}
+	if neg {
+		r = -r
+	}
+	return math.Ldexp(r, e), true
+}
+
+func isHexPrefix(str string, i int) bool {
+	return i+1 < len(str) && str[i] == '0' && (str[i+1] == 'x' || str[i+1] == 'X')
+}
+
+func skipSpaces(str string, i int) int {
+	for i < len(str) && isSpace(str[i]) {
+		i++
+	}
+	return i
+}
+
+// isSpace 对应C语言中的isspace
+func isSpace(c byte) bool {
+	switch c {
+	case '\t', '\n', '\v', '\f', '\r', ' ':
+		return true
+	default:
+		return false
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func hexValue(c byte) int {
+	if isDigit(c) {
+		return int(c - '0')
+	}
+	return int((c|0x20)-'a') + 10
+}
+
+func boolToUint(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,59 @@
+package number
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		str  string
+		want interface{}
+	}{
+		{"0", int64(0)},
+		{"  42\t\n", int64(42)},
+		{"-17", int64(-17)},
+		{"9223372036854775807", int64(math.MaxInt64)},
+		{"-9223372036854775808", int64(math.MinInt64)},
+		{"9223372036854775808", float64(9223372036854775808)},
+		{"-9223372036854775809", float64(-9223372036854775809)},
+		{"0xff", int64(255)},
+		{"0XBEBADA", int64(0xBEBADA)},
+		{"0x7fffffffffffffff", int64(math.MaxInt64)},
+		{"0xffffffffffffffff", int64(-1)},
+		{"0x10000000000000001", int64(1)},
+		{"3.0", 3.0},
+		{"3.1416", 3.1416},
+		{"314.16e-2", 3.1416},
+		{"0.31416E1", 3.1416},
+		{"34e1", 340.0},
+		{".5", 0.5},
+		{"5.", 5.0},
+		{"1e400", math.Inf(1)},
+		{"0x0.1E", 0.1171875},
+		{"0xA23p-4", 162.1875},
+		{"0X1.921FB54442D18P+1", math.Pi},
+		{"0x.8", 0.5},
+		{"0xA.", 10.0},
+		{"0x1p4", 16.0},
+		{"-0x1P-1", -0.5},
+		{"0x1000000000000000000000000000000000p-4", math.Ldexp(1, 128)},
+	}
+	for _, test := range tests {
+		got, ok := ParseNumber(test.str)
+		if !ok || got != test.want {
+			t.Errorf("ParseNumber(%q) = %v(%T), %t, want %v(%T)", test.str, got, got, ok, test.want, test.want)
+		}
+	}
+}
+
+func TestParseMalformedNumber(t *testing.T) {
+	for _, str := range []string{
+		"", " ", ".", "-", "0x", "0x.", "0xp1", "1e", "1e+", "0x1p", "3..2", "3.4.5",
+		"12abc", "0xg", "1_000", "inf", "nan", "-inf", "0x1.8n", "1 2", "0x1p4.5",
+	} {
+		if got, ok := ParseNumber(str); ok {
+			t.Errorf("ParseNumber(%q) = %v, want malformed", str, got)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Package peephole 对编译之后的Lua5.4函数原型做窥孔优化
+package peephole
+
+import (
+	"github.com/depressi0n/myLua/binchunk"
+	"github.com/depressi0n/myLua/cfg"
+	"github.com/depressi0n/myLua/vm"
+)
+
+// Optimize 优化函数原型及其所有子函数原型，直接修改传入的函数原型
+//
+// 包括以下变换：
+//   - 小整数常量的LOADK改写为LOADI，常量表保持不变
+//   - 跳转到JMP的JMP直接跳转到最终目标
+//   - 删除无条件跳转和返回之后不可达的指令，以及跳转到下一条指令的JMP
+//   - 合并相邻的LOADNIL
+//   - 删除MOVE A A，以及紧跟在MOVE A B之后的MOVE B A
+//
+// 删除指令之后重新计算跳转偏移、LineInfo、AbsLineInfo和局部变量的pc范围。
+// 函数原型先经过 binchunk.Verify 检查，检查失败时返回错误并且不做任何修改
+func Optimize(p *binchunk.Prototype) error {
+	if err := binchunk.Verify(p); err != nil {
+		return err
+	}
+	optimize(p)
+	return nil
+}
+
+func optimize(p *binchunk.Prototype) {
+	for _, sub := range p.Protos {
+		optimize(sub)
+	}
+	foldLoadK(p)
+	// 删除指令可能产生新的优化机会，例如删除死代码之后两条LOADNIL变得相邻，
+	// 或者删除MOVE A A之后跳转的目标变成JMP，重复到不再变化为止
+	for {
+		threaded := threadJumps(p)
+		if !removeInstructions(p) && !threaded {
+			break
+		}
+	}
+}
+
+// foldLoadK 把加载小整数常量的LOADK改写为LOADI
+func foldLoadK(p *binchunk.Prototype) {
+	for pc, c := range p.Code {
+		i := vm.Instruction(c)
+		if i.Opcode() != vm.OP_LOADK {
+			continue
+		}
+		a, bx := i.IABx()
+		n, ok := p.Constants[bx].(int64)
+		if !ok || n < -vm.OFFSET_sBx || n > vm.MAXARG_Bx-vm.OFFSET_sBx {
+			continue
+		}
+		loadi, _ := vm.CreateAsBx(vm.OP_LOADI, a, int(n))
+		p.Code[pc] = uint32(loadi)
+	}
+}
+
+// threadJumps 让跳转到JMP的JMP直接跳转到最终目标，返回是否修改了指令
+func threadJumps(p *binchunk.Prototype) bool {
+	changed := false
+	for pc, c := range p.Code {
+		i := vm.Instruction(c)
+		if i.Opcode() != vm.OP_JMP {
+			continue
+		}
+		target, _ := i.JumpTarget(pc)
+		// 最多经过len(p.Code)次跳转，避免在JMP组成的死循环中无法停止
+		for n := 0; n < len(p.Code); n++ {
+			next := vm.Instruction(p.Code[target])
+			if next.Opcode() != vm.OP_JMP {
+				break
+			}
+			target, _ = next.JumpTarget(target)
+		}
+		jmp, _ := vm.CreatesJ(vm.OP_JMP, target-pc-1)
+		if jmp != i {
+			p.Code[pc] = uint32(jmp)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// removeInstructions 删除不可达和没有作用的指令，返回是否删除了指令
+func removeInstructions(p *binchunk.Prototype) bool {
+	g, err := cfg.Build(p)
+	if err != nil {
+		// 经过检查的函数原型不会出现这种情况
+		panic(err)
+	}
+	code := p.Code
+	removed := make([]bool, len(code))
+	reachable := make([]bool, len(g.Blocks))
+	var visit func(b *cfg.Block)
+	visit = func(b *cfg.Block) {
+		if reachable[b.Index] {
+			return
+		}
+		reachable[b.Index] = true
+		for _, e := range b.Succs {
+			visit(g.Blocks[e.To])
+		}
+	}
+	visit(g.Blocks[0])
+
+	// keep 判断pc处的指令是否必须保留在原来的位置：
+	// 测试指令和LFALSESKIP跳过紧跟其后的一条指令，删除这条指令会改变它们的语义；
+	// 指令表必须以返回指令结束，即使它不可达
+	keep := func(pc int) bool {
+		if pc == len(code)-1 {
+			return true
+		}
+		if pc == 0 || !reachable[g.BlockAt(pc-1).Index] {
+			return false
+		}
+		prev := vm.Instruction(code[pc-1])
+		return prev.Info().IsTest || prev.Opcode() == vm.OP_LFALSESKIP
+	}
+	// startsBlock 判断pc处的指令是否可能从前一条指令之外的地方到达
+	startsBlock := func(pc int) bool {
+		return g.BlockAt(pc).Start == pc
+	}
+
+	for pc := 0; pc < len(code); pc++ {
+		i := vm.Instruction(code[pc])
+		switch {
+		case keep(pc):
+		case !reachable[g.BlockAt(pc).Index]:
+			removed[pc] = true
+		case i.Opcode() == vm.OP_JMP && i.IsJx() == 0:
+			removed[pc] = true
+		case i.Opcode() == vm.OP_MOVE:
+			a, _, b, _ := i.IABC()
+			if a == b {
+				removed[pc] = true
+			} else if next := pc + 1; next < len(code) && !startsBlock(next) && !keep(next) &&
+				vm.Instruction(code[next]) == vm.Instruction(vm.OP_MOVE|b<<vm.POS_A|a<<vm.POS_B) {
+				removed[next] = true
+				pc++
+			}
+		case i.Opcode() == vm.OP_LOADNIL:
+			// 与后面相邻或重叠的LOADNIL合并为一条
+			a, _, b, _ := i.IABC()
+			from, to := a, a+b
+			next := pc + 1
+			for ; next < len(code) && !startsBlock(next) && !keep(next); next++ {
+				j := vm.Instruction(code[next])
+				if j.Opcode() != vm.OP_LOADNIL {
+					break
+				}
+				a2, _, b2, _ := j.IABC()
+				if a2 > to+1 || a2+b2+1 < from || max(to, a2+b2)-min(from, a2) > vm.MAXARG_B {
+					break
+				}
+				from, to = min(from, a2), max(to, a2+b2)
+				removed[next] = true
+			}
+			merged, _ := vm.CreateABCk(vm.OP_LOADNIL, from, to-from, 0, 0)
+			code[pc] = uint32(merged)
+			pc = next - 1
+		}
+	}
+
+	for _, r := range removed {
+		if r {
+			compact(p, removed)
+			return true
+		}
+	}
+	return false
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,192 @@
+package peephole
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/depressi0n/myLua/asm"
+	"github.com/depressi0n/myLua/binchunk"
+	"github.com/depressi0n/myLua/vm"
+)
+
+func listing(p *binchunk.Prototype) []string {
+	var code []string
+	for _, c := range p.Code {
+		code = append(code, vm.Instruction(c).String())
+	}
+	return code
+}
+
+func optimizeSource(t *testing.T, src string) *binchunk.Prototype {
+	p, err := asm.Assemble([]byte(".stack 6\n"+src), "test.lasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Optimize(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := binchunk.Verify(p); err != nil {
+		t.Fatalf("optimized code does not verify: %v\n%q", err, listing(p))
+	}
+	return p
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"loadk", `
+.const 5
+.const 1.5
+.const 100000000
+        LOADK 0 0
+        LOADK 1 1
+        LOADK 2 2
+        RETURN0`,
+			[]string{"LOADI 0 5", "LOADK 1 1", "LOADK 2 2", "RETURN0 0 0 0"}},
+		{"jumps", `
+        EQI 0 5 0
+        JMP a
+        LOADTRUE 1
+a:      JMP b
+        LOADFALSE 1
+b:      RETURN1 1`,
+			[]string{"EQI 0 5 0", "JMP 1", "LOADTRUE 1 0 0", "RETURN1 1 0 0"}},
+		{"loadnil and move", `
+        LOADNIL 0 1
+        LOADNIL 2 0
+        LOADNIL 1 2
+        MOVE 4 4
+        MOVE 4 5
+        MOVE 5 4
+        RETURN0`,
+			[]string{"LOADNIL 0 3 0", "MOVE 4 5 0", "RETURN0 0 0 0"}},
+		{"jump target", `
+        LOADNIL 0 0
+        TEST 0 0
+        JMP b
+b:      LOADNIL 1 0
+        RETURN0`,
+			[]string{"LOADNIL 0 0 0", "TEST 0 0 0", "JMP 0", "LOADNIL 1 0 0", "RETURN0 0 0 0"}},
+		{"jumps after removal", `
+        TEST 0 0
+        JMP a
+        LOADTRUE 1
+        JMP c
+a:      MOVE 1 1
+        JMP b
+c:      LOADFALSE 1
+b:      RETURN1 1`,
+			[]string{"TEST 0 0 0", "JMP 2", "LOADTRUE 1 0 0", "LOADFALSE 1 0 0", "RETURN1 1 0 0"}},
+		{"lfalseskip", `
+        LFALSESKIP 0
+        LOADTRUE 0
+        RETURN1 0
+        RETURN0`,
+			[]string{"LFALSESKIP 0 0 0", "LOADTRUE 0 0 0", "RETURN1 0 0 0", "RETURN0 0 0 0"}},
+		{"loop", `
+        LOADI 1 1
+        LOADI 2 3
+        LOADI 3 1
+        FORPREP 1 done
+        JMP body
+        LOADNIL 0 0
+body:   MOVE 0 4
+        FORLOOP 1 body
+done:   RETURN0`,
+			[]string{"LOADI 1 1", "LOADI 2 3", "LOADI 3 1", "FORPREP 1 1", "MOVE 0 4 0", "FORLOOP 1 2", "RETURN0 0 0 0"}},
+	}
+	for _, test := range tests {
+		p := optimizeSource(t, test.src)
+		got := listing(p)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+		// 一次优化就达到不动点
+		Optimize(p)
+		if again := listing(p); !reflect.DeepEqual(again, got) {
+			t.Errorf("%s: second Optimize changed %q to %q", test.name, got, again)
+		}
+	}
+}
+
+func TestOptimizeDebugInfo(t *testing.T) {
+	p := optimizeSource(t, `
+.line 1
+        LOADI 0 1
+        JMP skip
+.line 2
+        LOADI 0 2
+.line 300
+skip:   RETURN1 0
+.local x 1 4
+.local y 2 3`)
+	if got, want := listing(p), []string{"LOADI 0 1", "RETURN1 0 0 0"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if l0, l1 := p.LineForPC(0), p.LineForPC(1); l0 != 1 || l1 != 300 {
+		t.Errorf("lines %d, %d, want 1, 300", l0, l1)
+	}
+	want := []binchunk.LocVar{{VarName: "x", StartPC: 1, EndPC: 2}, {VarName: "y", StartPC: 1, EndPC: 1}}
+	if !reflect.DeepEqual(p.LocVars, want) {
+		t.Errorf("got locals %v, want %v", p.LocVars, want)
+	}
+}
+
+func TestOptimizeErrors(t *testing.T) {
+	p, err := asm.Assemble([]byte("MOVE 0 7\nRETURN0"), "test.lasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := append([]uint32(nil), p.Code...)
+	if err := Optimize(p); err == nil {
+		t.Errorf("invalid prototype: no error")
+	}
+	if !reflect.DeepEqual(p.Code, code) {
+		t.Errorf("invalid prototype was modified")
+	}
+}
+
+// TestOptimizeCorpus 优化测试用的所有chunk，检查结果能通过验证，并且再次优化不会改变
+func TestOptimizeCorpus(t *testing.T) {
+	var protos []*binchunk.Prototype
+	data, err := ioutil.ReadFile("../binchunk/binchunk_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	protos = append(protos, binchunk.Undump(bytes.NewReader(data)))
+	files, _ := filepath.Glob("../asm/testdata/*.lasm")
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := asm.Assemble(src, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		protos = append(protos, p)
+	}
+	for _, p := range protos {
+		if err := Optimize(p); err != nil {
+			t.Fatal(err)
+		}
+		var before bytes.Buffer
+		if err := binchunk.Dump(&before, p, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := Optimize(p); err != nil {
+			t.Fatal(err)
+		}
+		var after bytes.Buffer
+		binchunk.Dump(&after, p, false)
+		if !bytes.Equal(before.Bytes(), after.Bytes()) {
+			t.Errorf("%s: second Optimize changed the chunk", p.Source)
+		}
+	}
+}
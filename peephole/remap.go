@@ -0,0 +1,76 @@
+package peephole
+
+import (
+	"github.com/depressi0n/myLua/binchunk"
+	"github.com/depressi0n/myLua/vm"
+)
+
+// compact 删除标记为removed的指令，重新计算跳转偏移、行号信息和局部变量的pc范围
+// 跳转到被删除指令的目标改为它之后第一条保留的指令
+func compact(p *binchunk.Prototype, removed []bool) {
+	// newPC[pc]是pc处或者之后第一条保留的指令的新位置，newPC[len(code)]是新的指令数量
+	newPC := make([]int, len(p.Code)+1)
+	n := 0
+	for pc := range p.Code {
+		newPC[pc] = n
+		if !removed[pc] {
+			n++
+		}
+	}
+	newPC[len(p.Code)] = n
+
+	var lines []int
+	hasLines := len(p.LineInfo) == len(p.Code)
+	code := make([]uint32, 0, n)
+	for pc, c := range p.Code {
+		if removed[pc] {
+			continue
+		}
+		i := vm.Instruction(c)
+		if target, ok := i.JumpTarget(pc); ok {
+			i = retarget(i, newPC[pc], newPC[target])
+		}
+		code = append(code, uint32(i))
+		if hasLines {
+			lines = append(lines, p.LineForPC(pc))
+		}
+	}
+
+	p.Code = code
+	if hasLines {
+		p.SetLines(lines)
+	}
+	for i := range p.LocVars {
+		v := &p.LocVars[i]
+		if v.StartPC >= 0 && v.StartPC <= len(removed) {
+			v.StartPC = newPC[v.StartPC]
+		}
+		if v.EndPC >= 0 && v.EndPC <= len(removed) {
+			v.EndPC = newPC[v.EndPC]
+		}
+	}
+}
+
+// retarget 重新编码位于pc的跳转指令，使其跳转到target
+func retarget(i vm.Instruction, pc, target int) vm.Instruction {
+	var j vm.Instruction
+	var err error
+	switch op := i.Opcode(); op {
+	case vm.OP_JMP:
+		j, err = vm.CreatesJ(op, target-pc-1)
+	case vm.OP_FORLOOP, vm.OP_TFORLOOP:
+		a, _ := i.IABx()
+		j, err = vm.CreateABx(op, a, pc+1-target)
+	case vm.OP_FORPREP:
+		a, _ := i.IABx()
+		j, err = vm.CreateABx(op, a, target-pc-2)
+	case vm.OP_TFORPREP:
+		a, _ := i.IABx()
+		j, err = vm.CreateABx(op, a, target-pc-1)
+	}
+	if err != nil {
+		// 删除指令只会缩短跳转距离
+		panic(err)
+	}
+	return j
+}
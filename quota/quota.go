@@ -0,0 +1,220 @@
+// Package quota 限制不可信的Lua脚本使用的资源：执行的指令数、调用深度、分配的内存和字符串长度。
+// 超出限制时返回 *Error，解释器的pcall和xpcall不能捕获这种错误，只有宿主程序能够处理
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Resource 是受限制的资源
+type Resource int
+
+const (
+	Instructions Resource = iota // 执行的指令数
+	CallDepth                    // 调用深度
+	Memory                       // 累计分配的字节数
+	StringLen                    // 单个字符串的长度
+)
+
+func (r Resource) String() string {
+	switch r {
+	case Instructions:
+		return "instructions"
+	case CallDepth:
+		return "call depth"
+	case Memory:
+		return "memory"
+	case StringLen:
+		return "string length"
+	}
+	return fmt.Sprintf("Resource(%d)", int(r))
+}
+
+// Limits 是一个Lua状态的资源限制，值为0表示不限制
+type Limits struct {
+	Instructions int64
+	CallDepth    int
+	Memory       int64
+	StringLen    int
+}
+
+// Error 表示超出了资源限制
+type Error struct {
+	Resource Resource
+	Limit    int64
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("quota exceeded: %s limit %d", e.Resource, e.Limit)
+}
+
+// Catchable 判断Lua代码（pcall和xpcall）是否可以捕获错误err，超出资源限制的错误不能捕获
+func Catchable(err error) bool {
+	var qe *Error
+	return !errors.As(err, &qe)
+}
+
+// Budget 记录一个Lua状态已经使用的资源。超出任何一项限制之后，
+// 所有检查都返回同一个错误，即使错误被意外地忽略，脚本也不能继续执行
+type Budget struct {
+	limits Limits
+	fuel   int64 // 还可以执行的指令数，不限制时为math.MaxInt64
+	depth  int
+	memory int64
+	err    *Error
+	spent  int64 // 超出限制时已经执行的指令数
+}
+
+// NewBudget 按照限制l创建 Budget
+func NewBudget(l Limits) *Budget {
+	b := &Budget{limits: l, fuel: l.Instructions}
+	if l.Instructions <= 0 {
+		b.fuel = math.MaxInt64
+	}
+	return b
+}
+
+// Limits 返回创建 Budget 时的限制
+func (b *Budget) Limits() Limits {
+	return b.limits
+}
+
+// Err 返回超出限制的错误，没有超出限制时返回nil
+func (b *Budget) Err() error {
+	if b.err == nil {
+		return nil
+	}
+	return b.err
+}
+
+// exceed 记录超出限制的错误，并且让之后的 Step 和 Charge 也返回这个错误
+func (b *Budget) exceed(r Resource, limit int64) error {
+	if b.err == nil {
+		b.spent = b.Executed()
+		b.err = &Error{Resource: r, Limit: limit}
+		b.fuel = -1
+	}
+	return b.err
+}
+
+// Step 在指令循环中执行每条指令之前调用。
+// 通常只有一次减法和一次比较，可以被内联，不会明显影响执行速度
+func (b *Budget) Step() error {
+	b.fuel--
+	if b.fuel >= 0 {
+		return nil
+	}
+	return b.stepExceeded()
+}
+
+// Charge 一次计入n条指令，可以在进入基本块时按照块中的指令数调用，进一步减少检查的次数
+func (b *Budget) Charge(n int) error {
+	b.fuel -= int64(n)
+	if b.fuel >= 0 {
+		return nil
+	}
+	return b.stepExceeded()
+}
+
+// stepExceeded 不内联到 Step 中，使 Step 本身可以被内联
+//
+//go:noinline
+func (b *Budget) stepExceeded() error {
+	if b.err == nil {
+		b.fuel = 0
+	}
+	err := b.exceed(Instructions, b.limits.Instructions)
+	b.fuel = -1 // 避免反复减少之后溢出
+	return err
+}
+
+// Executed 返回已经执行的指令数，不限制指令数时返回0
+func (b *Budget) Executed() int64 {
+	switch {
+	case b.limits.Instructions <= 0:
+		return 0
+	case b.err != nil:
+		return b.spent
+	}
+	return b.limits.Instructions - b.fuel
+}
+
+// Enter 在调用函数之前调用，调用深度超出限制时返回错误
+func (b *Budget) Enter() error {
+	if b.err != nil {
+		return b.err
+	}
+	b.depth++
+	if b.limits.CallDepth > 0 && b.depth > b.limits.CallDepth {
+		b.depth--
+		return b.exceed(CallDepth, int64(b.limits.CallDepth))
+	}
+	return nil
+}
+
+// Leave 在函数返回之后调用，与 Enter 配对
+func (b *Budget) Leave() {
+	if b.depth > 0 {
+		b.depth--
+	}
+}
+
+// Depth 返回当前的调用深度
+func (b *Budget) Depth() int {
+	return b.depth
+}
+
+// Alloc 在分配n个字节之前调用，累计分配的字节数超出限制时返回错误，这次分配不计算在内
+func (b *Budget) Alloc(n int64) error {
+	if b.err != nil {
+		return b.err
+	}
+	if b.limits.Memory > 0 && b.memory+n > b.limits.Memory {
+		return b.exceed(Memory, b.limits.Memory)
+	}
+	b.memory += n
+	return nil
+}
+
+// Allocated 返回累计分配的字节数
+func (b *Budget) Allocated() int64 {
+	return b.memory
+}
+
+// AllocString 在创建长度为n的字符串之前调用，同时检查字符串长度和内存的限制
+func (b *Budget) AllocString(n int) error {
+	if b.err != nil {
+		return b.err
+	}
+	if b.limits.StringLen > 0 && n > b.limits.StringLen {
+		return b.exceed(StringLen, int64(b.limits.StringLen))
+	}
+	return b.Alloc(StringSize(n))
+}
+
+// 下面是Lua5.4在64位平台上各种对象占用的字节数，用于估计分配的内存
+const (
+	stringHeader  = 24 // TString
+	tableHeader   = 56 // Table
+	arraySlot     = 16 // TValue
+	hashNode      = 24 // Node
+	closureHeader = 32 // LClosure，不包括upvalue指针
+	upvalueSlot   = 8
+)
+
+// StringSize 返回长度为n的字符串占用的字节数
+func StringSize(n int) int64 {
+	return stringHeader + int64(n) + 1
+}
+
+// TableSize 返回数组部分长度为narr、哈希部分长度为nhash的表占用的字节数
+func TableSize(narr, nhash int) int64 {
+	return tableHeader + arraySlot*int64(narr) + hashNode*int64(nhash)
+}
+
+// ClosureSize 返回有nups个upvalue的Lua闭包占用的字节数
+func ClosureSize(nups int) int64 {
+	return closureHeader + upvalueSlot*int64(nups)
+}
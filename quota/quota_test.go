@@ -0,0 +1,142 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestInstructions(t *testing.T) {
+	b := NewBudget(Limits{Instructions: 100})
+	n := 0
+	var err error
+	for ; err == nil; n++ {
+		err = b.Step()
+	}
+	if n != 101 || b.Executed() != 100 {
+		t.Errorf("stopped after %d steps, executed %d", n, b.Executed())
+	}
+	var qe *Error
+	if !errors.As(err, &qe) || qe.Resource != Instructions || qe.Limit != 100 {
+		t.Fatalf("got %v", err)
+	}
+	if err.Error() != "quota exceeded: instructions limit 100" {
+		t.Errorf("got %q", err.Error())
+	}
+	// 超出限制之后所有检查都失败
+	if b.Step() != err || b.Enter() != err || b.Alloc(1) != err || b.AllocString(1) != err || b.Err() != err {
+		t.Errorf("budget is not exhausted after an error")
+	}
+
+	b = NewBudget(Limits{Instructions: 10})
+	if b.Charge(10) != nil || b.Charge(1) == nil {
+		t.Errorf("Charge did not stop at the limit")
+	}
+
+	b = NewBudget(Limits{})
+	for i := 0; i < 1000; i++ {
+		if err := b.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.Err() != nil || b.Executed() != 0 {
+		t.Errorf("unlimited budget: %v, executed %d", b.Err(), b.Executed())
+	}
+}
+
+func TestCallDepth(t *testing.T) {
+	b := NewBudget(Limits{CallDepth: 3})
+	for i := 0; i < 3; i++ {
+		if err := b.Enter(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.Leave()
+	if err := b.Enter(); err != nil {
+		t.Fatal(err)
+	}
+	err := b.Enter()
+	var qe *Error
+	if !errors.As(err, &qe) || qe.Resource != CallDepth || b.Depth() != 3 {
+		t.Errorf("got %v at depth %d", err, b.Depth())
+	}
+}
+
+func TestMemory(t *testing.T) {
+	b := NewBudget(Limits{Memory: 1000, StringLen: 100})
+	if err := b.Alloc(TableSize(4, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AllocString(100); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.Allocated(), TableSize(4, 4)+StringSize(100); got != want {
+		t.Errorf("allocated %d, want %d", got, want)
+	}
+	var qe *Error
+	if err := b.AllocString(101); !errors.As(err, &qe) || qe.Resource != StringLen {
+		t.Errorf("got %v", err)
+	}
+
+	b = NewBudget(Limits{Memory: 1000})
+	for b.Alloc(ClosureSize(2)) == nil {
+	}
+	if err := b.Err(); !errors.As(err, &qe) || qe.Resource != Memory || b.Allocated() > 1000 {
+		t.Errorf("got %v, allocated %d", err, b.Allocated())
+	}
+}
+
+// TestExhausted 检查超出任何一项限制之后，指令检查也返回同一个错误
+func TestExhausted(t *testing.T) {
+	for _, l := range []Limits{{Memory: 10}, {CallDepth: 1}, {StringLen: 1}, {Instructions: 5, Memory: 10}} {
+		b := NewBudget(l)
+		b.Step()
+		b.Enter()
+		err := b.Enter()
+		if err == nil {
+			err = b.AllocString(100)
+		}
+		if err == nil {
+			t.Fatalf("%+v: no error", l)
+		}
+		if b.Step() != err || b.Charge(1) != err {
+			t.Errorf("%+v: Step after %v does not fail", l, err)
+		}
+		if l.Instructions > 0 && b.Executed() != 1 {
+			t.Errorf("%+v: executed %d", l, b.Executed())
+		}
+	}
+}
+
+func TestCatchable(t *testing.T) {
+	b := NewBudget(Limits{StringLen: 1})
+	err := fmt.Errorf("test.lua:1: %w", b.AllocString(2))
+	if Catchable(err) {
+		t.Errorf("quota error %v is catchable", err)
+	}
+	if !Catchable(errors.New("attempt to call a nil value")) {
+		t.Errorf("runtime error is not catchable")
+	}
+}
+
+// 比较没有检查和每条指令调用Step的循环，衡量检查的开销
+func BenchmarkLoop(b *testing.B) {
+	b.Run("plain", func(b *testing.B) {
+		sum := 0
+		for i := 0; i < b.N; i++ {
+			sum += i
+		}
+		_ = sum
+	})
+	b.Run("step", func(b *testing.B) {
+		budget := NewBudget(Limits{})
+		sum := 0
+		for i := 0; i < b.N; i++ {
+			if err := budget.Step(); err != nil {
+				b.Fatal(err)
+			}
+			sum += i
+		}
+		_ = sum
+	})
+}
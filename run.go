@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/depressi0n/myLua/quota"
+	"github.com/depressi0n/myLua/state"
+)
+
+var runCommand = &command{
+	name:  "run",
+	usage: "run [-instructions n] [-depth n] [-memory bytes] [-strlen n] file.luac [args...]",
+	run:   runRun,
+}
+
+// runRun 执行二进制chunk，其余的命令行参数作为主函数的vararg，限制为0表示不限制
+func runRun(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	var limits quota.Limits
+	flags.Int64Var(&limits.Instructions, "instructions", 0, "maximum number of executed instructions")
+	flags.IntVar(&limits.CallDepth, "depth", 0, "maximum call depth")
+	flags.Int64Var(&limits.Memory, "memory", 0, "maximum number of allocated bytes")
+	flags.IntVar(&limits.StringLen, "strlen", 0, "maximum string length")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return fmt.Errorf("need a chunk file")
+	}
+
+	proto, err := undumpFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	L := state.New()
+	L.SetLimits(limits)
+	cl, err := L.Load(proto)
+	if err != nil {
+		return err
+	}
+	var params []state.Value
+	for _, arg := range flags.Args()[1:] {
+		params = append(params, arg)
+	}
+	_, err = L.PCall(cl, params...)
+	return err
+}
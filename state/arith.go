@@ -0,0 +1,238 @@
+package state
+
+import (
+	"math"
+	"strings"
+)
+
+// 算术和位运算，前12种的顺序与OP_ADD到OP_SHR相同，
+// 加上6就是MMBIN的C操作数（ltm.h中的TM_ADD到TM_SHR）
+const (
+	opAdd = iota
+	opSub
+	opMul
+	opMod
+	opPow
+	opDiv
+	opIDiv
+	opBand
+	opBor
+	opBxor
+	opShl
+	opShr
+	opUnm
+	opBnot
+)
+
+// isBitwise 判断运算是否是位运算
+func isBitwise(op int) bool {
+	return op >= opBand && op <= opShr || op == opBnot
+}
+
+// arith 计算算术和位运算，一元运算的两个操作数相同。
+// 字符串按照Lua的规则转换为数字，操作数不能转换时返回false，由调用者报告错误
+func (L *LuaState) arith(op int, a, b Value) (Value, bool) {
+	if isBitwise(op) {
+		x, ok1 := toInteger(a)
+		y, ok2 := toInteger(b)
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		return bitwise(op, x, y), true
+	}
+	na, ok1 := toNumber(a)
+	nb, ok2 := toNumber(b)
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+	if x, ok := na.(int64); ok && op != opPow && op != opDiv {
+		if y, ok := nb.(int64); ok {
+			return L.intArith(op, x, y), true
+		}
+	}
+	x, _ := toFloat(na)
+	y, _ := toFloat(nb)
+	return floatArith(op, x, y), true
+}
+
+func (L *LuaState) intArith(op int, x, y int64) int64 {
+	switch op {
+	case opAdd:
+		return x + y
+	case opSub:
+		return x - y
+	case opMul:
+		return x * y
+	case opMod:
+		if y == 0 {
+			L.runtimeError("attempt to perform 'n%%0'")
+		}
+		if y == -1 {
+			return 0 // 避免MinInt64 % -1溢出
+		}
+		r := x % y
+		if r != 0 && r^y < 0 {
+			r += y
+		}
+		return r
+	case opIDiv:
+		if y == 0 {
+			L.runtimeError("attempt to perform 'n//0'")
+		}
+		if y == -1 {
+			return -x
+		}
+		q := x / y
+		if x%y != 0 && x^y < 0 {
+			q--
+		}
+		return q
+	case opUnm:
+		return -x
+	}
+	panic("unreachable")
+}
+
+func floatArith(op int, x, y float64) float64 {
+	switch op {
+	case opAdd:
+		return x + y
+	case opSub:
+		return x - y
+	case opMul:
+		return x * y
+	case opMod:
+		// fmod的结果与x同号，与y异号时需要修正为向下取整的结果
+		m := math.Mod(x, y)
+		if m != 0 && (m < 0) != (y < 0) {
+			m += y
+		}
+		return m
+	case opPow:
+		return math.Pow(x, y)
+	case opDiv:
+		return x / y
+	case opIDiv:
+		return math.Floor(x / y)
+	case opUnm:
+		return -x
+	}
+	panic("unreachable")
+}
+
+func bitwise(op int, x, y int64) int64 {
+	switch op {
+	case opBand:
+		return x & y
+	case opBor:
+		return x | y
+	case opBxor:
+		return x ^ y
+	case opShl:
+		return shiftLeft(x, y)
+	case opShr:
+		return shiftLeft(x, -y)
+	case opBnot:
+		return ^x
+	}
+	panic("unreachable")
+}
+
+// shiftLeft 逻辑左移，y为负数时右移，移动64位以上时结果为0
+func shiftLeft(x, y int64) int64 {
+	switch {
+	case y <= -64 || y >= 64:
+		return 0
+	case y >= 0:
+		return int64(uint64(x) << uint(y))
+	default:
+		return int64(uint64(x) >> uint(-y))
+	}
+}
+
+// lessThan 比较a < b，只能比较两个数字或者两个字符串
+func (L *LuaState) lessThan(a, b Value) bool {
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return x < y
+		case float64:
+			return intLessFloat(x, y)
+		}
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			return !math.IsNaN(x) && !intLessEqualFloat(y, x)
+		case float64:
+			return x < y
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y) < 0
+		}
+	}
+	L.orderError(a, b)
+	return false
+}
+
+// lessEqual 比较a <= b
+func (L *LuaState) lessEqual(a, b Value) bool {
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return x <= y
+		case float64:
+			return intLessEqualFloat(x, y)
+		}
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			return !math.IsNaN(x) && !intLessFloat(y, x)
+		case float64:
+			return x <= y
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y) <= 0
+		}
+	}
+	L.orderError(a, b)
+	return false
+}
+
+// intLessFloat 精确地比较i < f，不把大整数转换为浮点数
+func intLessFloat(i int64, f float64) bool {
+	switch {
+	case math.IsNaN(f):
+		return false
+	case f >= 1<<63:
+		return true
+	case f <= -(1 << 63):
+		return false
+	}
+	return i < int64(math.Ceil(f))
+}
+
+// intLessEqualFloat 精确地比较i <= f
+func intLessEqualFloat(i int64, f float64) bool {
+	switch {
+	case math.IsNaN(f):
+		return false
+	case f >= 1<<63:
+		return true
+	case f < -(1 << 63):
+		return false
+	}
+	return i <= int64(math.Floor(f))
+}
+
+// orderError 报告不能比较大小的两个值，对应luaG_ordererror
+func (L *LuaState) orderError(a, b Value) {
+	t1, t2 := TypeName(a), TypeName(b)
+	if t1 == t2 {
+		L.runtimeError("attempt to compare two %s values", t1)
+	}
+	L.runtimeError("attempt to compare %s with %s", t1, t2)
+}
@@ -0,0 +1,250 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/depressi0n/myLua/number"
+)
+
+// baseFuncs 是基础库中的函数，没有元表，不包括load、require等需要编译器的函数
+var baseFuncs = map[string]GoFunc{
+	"assert":   baseAssert,
+	"error":    baseError,
+	"ipairs":   baseIPairs,
+	"next":     baseNext,
+	"pairs":    basePairs,
+	"pcall":    basePCall,
+	"print":    basePrint,
+	"rawequal": baseRawEqual,
+	"rawget":   baseRawGet,
+	"rawlen":   baseRawLen,
+	"rawset":   baseRawSet,
+	"select":   baseSelect,
+	"tonumber": baseToNumber,
+	"tostring": baseToString,
+	"type":     baseType,
+	"xpcall":   baseXPCall,
+}
+
+// openBase 打开基础库
+func (L *LuaState) openBase() {
+	for name, fn := range baseFuncs {
+		L.Register(name, fn)
+	}
+	L.SetGlobal("_G", L.globals)
+	L.SetGlobal("_VERSION", "Lua 5.4")
+}
+
+// assert (v [, message])
+func baseAssert(L *LuaState, args []Value) ([]Value, error) {
+	v := L.checkAny(args, 1, "assert")
+	if truthy(v) {
+		return args, nil
+	}
+	if len(args) < 2 {
+		L.Errorf("assertion failed!")
+	}
+	L.raise(args[1])
+	return nil, nil
+}
+
+// error (message [, level])
+// level为1（默认）时在字符串消息前面加上调用error的位置，为2时加上调用者的调用者的位置
+func baseError(L *LuaState, args []Value) ([]Value, error) {
+	msg := arg(args, 1)
+	level := L.optInteger(args, 2, "error", 1)
+	if s, ok := msg.(string); ok && level > 0 {
+		msg = L.where(int(level)) + s
+	}
+	L.raise(msg)
+	return nil, nil
+}
+
+// ipairs (t)
+func baseIPairs(L *LuaState, args []Value) ([]Value, error) {
+	L.checkAny(args, 1, "ipairs")
+	return []Value{ipairsAux, args[0], int64(0)}, nil
+}
+
+var ipairsAux = NewGoFunction("ipairs_aux", func(L *LuaState, args []Value) ([]Value, error) {
+	i := L.checkInteger(args, 2, "ipairs_aux") + 1
+	v := L.index(-1, args[0], i)
+	if v == nil {
+		return []Value{nil}, nil
+	}
+	return []Value{i, v}, nil
+})
+
+// next (table [, index])
+func baseNext(L *LuaState, args []Value) ([]Value, error) {
+	t := L.checkArg(args, 1, "next", "table").(*Table)
+	k, v, ok := t.Next(arg(args, 2))
+	if !ok {
+		L.Errorf("invalid key to 'next'")
+	}
+	if k == nil {
+		return []Value{nil}, nil
+	}
+	return []Value{k, v}, nil
+}
+
+var nextFunc = NewGoFunction("next", baseNext)
+
+// pairs (t)
+func basePairs(L *LuaState, args []Value) ([]Value, error) {
+	t := L.checkArg(args, 1, "pairs", "table")
+	return []Value{nextFunc, t, nil}, nil
+}
+
+// pcall (f [, arg1, ...])
+func basePCall(L *LuaState, args []Value) ([]Value, error) {
+	L.checkAny(args, 1, "pcall")
+	results, err := L.pcall(args[0], args[1:], nil, false)
+	if err != nil {
+		return []Value{false, err.Value}, nil
+	}
+	return append([]Value{true}, results...), nil
+}
+
+// xpcall (f, msgh [, arg1, ...])
+func baseXPCall(L *LuaState, args []Value) ([]Value, error) {
+	L.checkArg(args, 2, "xpcall", "function")
+	results, err := L.pcall(args[0], args[2:], args[1], false)
+	if err != nil {
+		return []Value{false, err.Value}, nil
+	}
+	return append([]Value{true}, results...), nil
+}
+
+// print (...)
+func basePrint(L *LuaState, args []Value) ([]Value, error) {
+	strs := make([]string, len(args))
+	for i, v := range args {
+		strs[i] = L.tostring(v)
+	}
+	_, err := fmt.Fprintln(L.stdout, strings.Join(strs, "\t"))
+	return nil, err
+}
+
+// rawequal (v1, v2)
+func baseRawEqual(L *LuaState, args []Value) ([]Value, error) {
+	L.checkAny(args, 1, "rawequal")
+	L.checkAny(args, 2, "rawequal")
+	return []Value{rawEqual(args[0], args[1])}, nil
+}
+
+// rawget (table, index)
+func baseRawGet(L *LuaState, args []Value) ([]Value, error) {
+	t := L.checkArg(args, 1, "rawget", "table").(*Table)
+	L.checkAny(args, 2, "rawget")
+	return []Value{t.Get(args[1])}, nil
+}
+
+// rawlen (v)
+func baseRawLen(L *LuaState, args []Value) ([]Value, error) {
+	switch v := arg(args, 1).(type) {
+	case *Table:
+		return []Value{v.Len()}, nil
+	case string:
+		return []Value{int64(len(v))}, nil
+	}
+	L.argError(1, "rawlen", "table or string expected")
+	return nil, nil
+}
+
+// rawset (table, index, value)
+func baseRawSet(L *LuaState, args []Value) ([]Value, error) {
+	t := L.checkArg(args, 1, "rawset", "table").(*Table)
+	L.checkAny(args, 2, "rawset")
+	L.checkAny(args, 3, "rawset")
+	L.rawSet(t, args[1], args[2])
+	return []Value{t}, nil
+}
+
+// select (index, ...)
+func baseSelect(L *LuaState, args []Value) ([]Value, error) {
+	n := int64(len(args) - 1)
+	if s, ok := arg(args, 1).(string); ok && s == "#" {
+		return []Value{n}, nil
+	}
+	i := L.checkInteger(args, 1, "select")
+	if i < 0 {
+		i = n + i
+	} else if i > n {
+		i = n
+	}
+	if i < 0 {
+		L.argError(1, "select", "index out of range")
+	}
+	return args[1+i:], nil
+}
+
+// tonumber (e [, base])
+func baseToNumber(L *LuaState, args []Value) ([]Value, error) {
+	if len(args) < 2 || args[1] == nil {
+		v := L.checkAny(args, 1, "tonumber")
+		if s, ok := v.(string); ok {
+			if n, ok := number.ParseNumber(s); ok {
+				return []Value{n}, nil
+			}
+			return []Value{nil}, nil
+		}
+		if n, ok := toNumber(v); ok {
+			return []Value{n}, nil
+		}
+		return []Value{nil}, nil
+	}
+	base := L.checkInteger(args, 2, "tonumber")
+	s := L.checkArg(args, 1, "tonumber", "string").(string)
+	if base < 2 || base > 36 {
+		L.argError(2, "tonumber", "base out of range")
+	}
+	if n, ok := parseIntBase(strings.TrimSpace(s), base); ok {
+		return []Value{n}, nil
+	}
+	return []Value{nil}, nil
+}
+
+// parseIntBase 把base进制的整数转换为整数，溢出时回绕，对应luaB_tonumber中的l_str2int
+func parseIntBase(s string, base int64) (int64, bool) {
+	neg := false
+	if strings.HasPrefix(s, "-") {
+		neg, s = true, s[1:]
+	} else if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+	if s == "" {
+		return 0, false
+	}
+	var n int64
+	for _, c := range strings.ToLower(s) {
+		var d int64
+		switch {
+		case c >= '0' && c <= '9':
+			d = int64(c - '0')
+		case c >= 'a' && c <= 'z':
+			d = int64(c-'a') + 10
+		default:
+			return 0, false
+		}
+		if d >= base {
+			return 0, false
+		}
+		n = n*base + d
+	}
+	if neg {
+		n = -n
+	}
+	return n, true
+}
+
+// tostring (v)
+func baseToString(L *LuaState, args []Value) ([]Value, error) {
+	return []Value{L.tostring(L.checkAny(args, 1, "tostring"))}, nil
+}
+
+// type (v)
+func baseType(L *LuaState, args []Value) ([]Value, error) {
+	return []Value{TypeName(L.checkAny(args, 1, "type"))}, nil
+}
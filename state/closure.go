@@ -0,0 +1,57 @@
+package state
+
+import "github.com/depressi0n/myLua/binchunk"
+
+// Closure 是Lua闭包，由函数原型和upvalue组成
+type Closure struct {
+	proto  *binchunk.Prototype
+	upvals []*Upvalue
+}
+
+// Proto 返回闭包的函数原型
+func (c *Closure) Proto() *binchunk.Prototype {
+	return c.proto
+}
+
+// Upvalue 是闭包捕获的变量。变量所在的函数返回之前upvalue是打开的，
+// 直接读写函数的寄存器；函数返回或者变量离开作用域时关闭，之后保存变量自己的值
+type Upvalue struct {
+	fr    *frame // 打开时变量所在的调用栈层，关闭后为nil
+	idx   int    // 打开时变量所在的寄存器
+	value Value  // 关闭后的值
+}
+
+func (u *Upvalue) get() Value {
+	if u.fr != nil {
+		return u.fr.regs[u.idx]
+	}
+	return u.value
+}
+
+func (u *Upvalue) set(v Value) {
+	if u.fr != nil {
+		u.fr.regs[u.idx] = v
+	} else {
+		u.value = v
+	}
+}
+
+// GoFunc 是可以被Lua代码调用的Go函数，args是调用时的参数。
+// 返回的错误作为Lua错误抛出，超出资源限制的错误原样传给宿主程序
+type GoFunc func(L *LuaState, args []Value) ([]Value, error)
+
+// GoFunction 是Lua中的Go函数值，name用于错误信息
+type GoFunction struct {
+	name string
+	fn   GoFunc
+}
+
+// NewGoFunction 把Go函数fn包装为Lua的函数值
+func NewGoFunction(name string, fn GoFunc) *GoFunction {
+	return &GoFunction{name: name, fn: fn}
+}
+
+// Name 返回创建函数值时指定的名字
+func (f *GoFunction) Name() string {
+	return f.name
+}
@@ -0,0 +1,423 @@
+package state
+
+import (
+	"runtime"
+
+	"github.com/depressi0n/myLua/debuginfo"
+	"github.com/depressi0n/myLua/hook"
+	"github.com/depressi0n/myLua/vm"
+)
+
+// debugFuncs 是debug库中的函数，没有协程，所以不支持thread参数
+var debugFuncs = map[string]GoFunc{
+	"gethook":     debugGetHook,
+	"getinfo":     debugGetInfo,
+	"getlocal":    debugGetLocal,
+	"getupvalue":  debugGetUpvalue,
+	"sethook":     debugSetHook,
+	"setlocal":    debugSetLocal,
+	"setupvalue":  debugSetUpvalue,
+	"traceback":   debugTraceback,
+	"upvalueid":   debugUpvalueID,
+	"upvaluejoin": debugUpvalueJoin,
+}
+
+// OpenDebug 打开debug库，设置全局变量debug。
+// debug库可以读写任意函数的局部变量和upvalue，运行不可信的代码时不要打开
+func (L *LuaState) OpenDebug() {
+	lib := NewTable(0, len(debugFuncs))
+	for name, fn := range debugFuncs {
+		lib.Set(name, NewGoFunction(name, fn))
+	}
+	L.SetGlobal("debug", lib)
+}
+
+// sethook ([hook, mask [, count]])
+// 钩子函数的参数是事件名，行事件还有行号
+func debugSetHook(L *LuaState, args []Value) ([]Value, error) {
+	if arg(args, 1) == nil {
+		L.SetHook(nil, 0, 0)
+		return nil, nil
+	}
+	smask := L.checkString(args, 2, "sethook")
+	fn := L.checkArg(args, 1, "sethook", "function")
+	count := int(L.optInteger(args, 3, "sethook", 0))
+	L.SetHook(func(info hook.Info) {
+		var line Value
+		if info.Line >= 0 {
+			line = int64(info.Line)
+		}
+		// 触发事件的函数一定在栈顶，标记之后getinfo把钩子函数的名字报告为"hook"
+		fr := L.currentFrame()
+		fr.hooked = true
+		defer func() { fr.hooked = false }()
+		L.call(fn, []Value{info.Event.String(), line})
+	}, hook.ParseMask(smask, count), count)
+	if _, mask, _ := L.GetHook(); mask != 0 {
+		L.luaHook = fn
+	}
+	return nil, nil
+}
+
+// gethook ()
+// 返回钩子函数、事件和计数，钩子是Go设置的时返回"external hook"，没有钩子时返回nil
+func debugGetHook(L *LuaState, args []Value) ([]Value, error) {
+	fn, mask, count := L.GetHook()
+	if fn == nil {
+		return []Value{nil}, nil
+	}
+	var h Value = "external hook"
+	if L.luaHook != nil {
+		h = L.luaHook
+	}
+	return []Value{h, mask.String(), int64(count)}, nil
+}
+
+// frameAt 返回第level层（0是正在执行的函数）在调用栈中的下标，level越界时返回false
+func (L *LuaState) frameAt(level int64) (int, bool) {
+	i := int64(len(L.frames)) - 1 - level
+	if level < 0 || i < 0 {
+		return 0, false
+	}
+	return int(i), true
+}
+
+// funcName 推断调用栈中第i层的函数的名字，对应ldebug.c中的getfuncname：
+// 由调用者正在执行的指令推断，尾调用和Go函数调用的函数没有名字
+func (L *LuaState) funcName(i int) (debuginfo.Name, bool) {
+	if i == 0 || L.frames[i].tailCall {
+		return debuginfo.Name{}, false
+	}
+	caller := L.frames[i-1]
+	if caller.hooked {
+		return debuginfo.Name{Kind: "hook", Name: "?"}, true
+	}
+	if caller.cl == nil {
+		return debuginfo.Name{}, false
+	}
+	return debuginfo.FuncNameFromCode(caller.cl.proto, caller.currentPC())
+}
+
+// getinfo ([f [, what]])
+// f是函数或者调用栈的层数，层数越界时返回nil
+func debugGetInfo(L *LuaState, args []Value) ([]Value, error) {
+	what := "flnSrtu"
+	if arg(args, 2) != nil {
+		what = L.checkString(args, 2, "getinfo")
+	}
+	if err := debuginfo.CheckInfoOptions(what); err != nil {
+		L.argError(2, "getinfo", err.Error())
+	}
+	var fr *frame
+	var fn Value
+	i := -1
+	if isFunction(arg(args, 1)) {
+		fn = args[0]
+	} else {
+		var ok bool
+		if i, ok = L.frameAt(L.checkInteger(args, 1, "getinfo")); !ok {
+			return []Value{nil}, nil
+		}
+		fr = L.frames[i]
+		fn = fr.function()
+	}
+	cl, _ := fn.(*Closure)
+	t := NewTable(0, len(what)*2)
+	for _, c := range what {
+		switch c {
+		case 'S':
+			if cl != nil {
+				info := debuginfo.GetFuncInfo(cl.proto)
+				t.Set("source", info.Source)
+				t.Set("short_src", info.ShortSrc)
+				t.Set("linedefined", int64(info.LineDefined))
+				t.Set("lastlinedefined", int64(info.LastLineDefined))
+				t.Set("what", info.What)
+			} else {
+				// 与Lua的C函数相同，脚本中常用what == "C"判断
+				t.Set("source", "=[C]")
+				t.Set("short_src", "[C]")
+				t.Set("linedefined", int64(-1))
+				t.Set("lastlinedefined", int64(-1))
+				t.Set("what", "C")
+			}
+		case 'l':
+			line := -1
+			if fr != nil && fr.cl != nil {
+				line = fr.cl.proto.LineForPC(fr.currentPC())
+			}
+			t.Set("currentline", int64(line))
+		case 'u':
+			if cl != nil {
+				info := debuginfo.GetFuncInfo(cl.proto)
+				t.Set("nups", int64(info.NumUps))
+				t.Set("nparams", int64(info.NumParams))
+				t.Set("isvararg", info.IsVararg)
+			} else {
+				t.Set("nups", int64(0))
+				t.Set("nparams", int64(0))
+				t.Set("isvararg", true)
+			}
+		case 'n':
+			namewhat := ""
+			if fr != nil {
+				if name, ok := L.funcName(i); ok {
+					t.Set("name", name.Name)
+					namewhat = name.Kind
+				}
+			}
+			t.Set("namewhat", namewhat)
+		case 'r':
+			// 只有调用钩子时才有传递的值，这里不记录
+			t.Set("ftransfer", int64(0))
+			t.Set("ntransfer", int64(0))
+		case 't':
+			t.Set("istailcall", fr != nil && fr.tailCall)
+		case 'L':
+			if cl != nil {
+				lines := NewTable(0, 0)
+				for _, line := range debuginfo.ActiveLines(cl.proto) {
+					lines.Set(int64(line), true)
+				}
+				t.Set("activelines", lines)
+			}
+		case 'f':
+			t.Set("func", fn)
+		}
+	}
+	return []Value{t}, nil
+}
+
+// function 返回调用栈层中正在执行的函数
+func (fr *frame) function() Value {
+	if fr.cl != nil {
+		return fr.cl
+	}
+	return fr.gofn
+}
+
+// findLocal 返回调用栈层fr中第n个局部变量的名字和位置，对应luaG_findlocal：
+// n为负数时是第-n个额外参数，没有名字但在栈上的值是临时变量
+func findLocal(fr *frame, n int) (string, *Value) {
+	if fr.cl != nil {
+		if n < 0 {
+			if -n <= len(fr.varargs) {
+				return "(vararg)", &fr.varargs[-n-1]
+			}
+			return "", nil
+		}
+		if name, ok := debuginfo.LocalName(fr.cl.proto, n, fr.currentPC()); ok {
+			return name, &fr.regs[n-1]
+		}
+	}
+	if n <= 0 || n > fr.limit() {
+		return "", nil
+	}
+	if fr.cl == nil {
+		return "(C temporary)", &fr.regs[n-1]
+	}
+	return "(temporary)", &fr.regs[n-1]
+}
+
+// limit 返回调用栈层中正在使用的寄存器数量：
+// 正在调用其他函数时是被调用的函数所在的寄存器，否则是所有寄存器
+func (fr *frame) limit() int {
+	if fr.cl == nil || fr.hooked {
+		return len(fr.regs)
+	}
+	i := vm.Instruction(fr.cl.proto.Code[fr.currentPC()])
+	a, _, _, _ := i.IABC()
+	switch i.Opcode() {
+	case vm.OP_CALL, vm.OP_TAILCALL:
+		return a
+	case vm.OP_TFORCALL:
+		return a + 4
+	}
+	return len(fr.regs)
+}
+
+// getlocal ([f,] local)
+// f是函数时只返回参数的名字
+func debugGetLocal(L *LuaState, args []Value) ([]Value, error) {
+	n := int(L.checkInteger(args, 2, "getlocal"))
+	if cl, ok := arg(args, 1).(*Closure); ok {
+		name, ok := debuginfo.LocalName(cl.proto, n, 0)
+		if !ok || n > int(cl.proto.NumParams) {
+			return []Value{nil}, nil
+		}
+		return []Value{name}, nil
+	}
+	if _, ok := arg(args, 1).(*GoFunction); ok {
+		return []Value{nil}, nil
+	}
+	i, ok := L.frameAt(L.checkInteger(args, 1, "getlocal"))
+	if !ok {
+		L.argError(1, "getlocal", "level out of range")
+	}
+	name, v := findLocal(L.frames[i], n)
+	if v == nil {
+		return []Value{nil}, nil
+	}
+	return []Value{name, *v}, nil
+}
+
+// setlocal (level, local, value)
+func debugSetLocal(L *LuaState, args []Value) ([]Value, error) {
+	level := L.checkInteger(args, 1, "setlocal")
+	i, ok := L.frameAt(level)
+	if !ok {
+		L.argError(1, "setlocal", "level out of range")
+	}
+	n := int(L.checkInteger(args, 2, "setlocal"))
+	L.checkAny(args, 3, "setlocal")
+	name, v := findLocal(L.frames[i], n)
+	if v == nil {
+		return []Value{nil}, nil
+	}
+	*v = args[2]
+	return []Value{name}, nil
+}
+
+// upvalue 返回函数fn的第n个upvalue，Go函数没有upvalue
+func upvalue(fn Value, n int64) (*Upvalue, string) {
+	cl, ok := fn.(*Closure)
+	if !ok || n < 1 || n > int64(len(cl.upvals)) {
+		return nil, ""
+	}
+	name := "(no name)"
+	if int(n) <= len(cl.proto.UpvalueNames) && cl.proto.UpvalueNames[n-1] != "" {
+		name = cl.proto.UpvalueNames[n-1]
+	}
+	return cl.upvals[n-1], name
+}
+
+// getupvalue (f, up)
+func debugGetUpvalue(L *LuaState, args []Value) ([]Value, error) {
+	n := L.checkInteger(args, 2, "getupvalue")
+	fn := L.checkArg(args, 1, "getupvalue", "function")
+	u, name := upvalue(fn, n)
+	if u == nil {
+		return nil, nil
+	}
+	return []Value{name, u.get()}, nil
+}
+
+// setupvalue (f, up, value)
+func debugSetUpvalue(L *LuaState, args []Value) ([]Value, error) {
+	L.checkAny(args, 3, "setupvalue")
+	n := L.checkInteger(args, 2, "setupvalue")
+	fn := L.checkArg(args, 1, "setupvalue", "function")
+	u, name := upvalue(fn, n)
+	if u == nil {
+		return nil, nil
+	}
+	u.set(args[2])
+	return []Value{name}, nil
+}
+
+// upvalueid (f, n)
+// 返回的轻量用户数据只能用于比较两个upvalue是否相同，n无效时返回nil
+func debugUpvalueID(L *LuaState, args []Value) ([]Value, error) {
+	n := L.checkInteger(args, 2, "upvalueid")
+	fn := L.checkArg(args, 1, "upvalueid", "function")
+	if u, _ := upvalue(fn, n); u != nil {
+		return []Value{u}, nil
+	}
+	return []Value{nil}, nil
+}
+
+// upvaluejoin (f1, n1, f2, n2)
+// 让Lua函数f1的第n1个upvalue引用f2的第n2个upvalue
+func debugUpvalueJoin(L *LuaState, args []Value) ([]Value, error) {
+	check := func(argf, argn int) (Value, int64) {
+		fn := L.checkArg(args, argf, "upvaluejoin", "function")
+		n := L.checkInteger(args, argn, "upvaluejoin")
+		if u, _ := upvalue(fn, n); u == nil {
+			L.argError(argn, "upvaluejoin", "invalid upvalue index")
+		}
+		return fn, n
+	}
+	f1, n1 := check(1, 2)
+	f2, n2 := check(3, 4)
+	f1.(*Closure).upvals[n1-1] = f2.(*Closure).upvals[n2-1]
+	return nil, nil
+}
+
+// traceback ([message [, level]])
+// message不是字符串也不是nil时原样返回，level默认为1，即调用traceback的函数
+func debugTraceback(L *LuaState, args []Value) ([]Value, error) {
+	msg := arg(args, 1)
+	s, ok := toStringNumber(msg)
+	if !ok && msg != nil {
+		return []Value{msg}, nil
+	}
+	level := L.optInteger(args, 2, "traceback", 1)
+	return []Value{debuginfo.Traceback(s, L.stackFrames(level))}, nil
+}
+
+// stackFrames 返回从第level层开始的调用栈，用于生成traceback。
+// Go函数的位置是它在Go调用栈中正在执行的行，找不到时是函数定义的位置
+func (L *LuaState) stackFrames(level int64) []debuginfo.Frame {
+	var frames []debuginfo.Frame
+	i, ok := L.frameAt(level)
+	if !ok {
+		return nil
+	}
+	callers := goCallers()
+	for ; i >= 0; i-- {
+		fr := L.frames[i]
+		var f debuginfo.Frame
+		if fr.cl != nil {
+			f = debuginfo.Frame{Proto: fr.cl.proto, PC: fr.currentPC(), TailCall: fr.tailCall}
+		} else {
+			f = debuginfo.GoFuncFrame(fr.gofn.fn)
+			// Go调用栈和frames都是从内向外排列，依次匹配函数名
+			for j, c := range callers {
+				if c.Function == f.GoFunc {
+					f.GoFile, f.GoLine = c.File, c.Line
+					callers = callers[j+1:]
+					break
+				}
+			}
+		}
+		if name, ok := L.globalName(fr.function()); ok {
+			f.Name = debuginfo.Name{Kind: "global", Name: name}
+		} else if i > 0 && L.frames[i-1].hooked {
+			f.Name = debuginfo.Name{Kind: "hook", Name: "?"}
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+// globalName 在全局变量中查找函数fn的名字，对应lauxlib.c中的pushglobalfuncname
+func (L *LuaState) globalName(fn Value) (string, bool) {
+	for k, v, _ := L.globals.Next(nil); k != nil; k, v, _ = L.globals.Next(k) {
+		if name, ok := k.(string); ok && v == fn {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// goCallers 返回当前goroutine的调用栈，从最内层开始
+func goCallers() []runtime.Frame {
+	pcs := make([]uintptr, 64)
+	for {
+		n := runtime.Callers(2, pcs)
+		if n < len(pcs) {
+			pcs = pcs[:n]
+			break
+		}
+		pcs = make([]uintptr, 2*len(pcs))
+	}
+	var callers []runtime.Frame
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		callers = append(callers, f)
+		if !more {
+			return callers
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/depressi0n/myLua/debuginfo"
+)
+
+// Error 是Lua代码中发生的错误，Value是错误对象：
+// 运行时错误和error函数抛出的字符串带有"chunk:line:"形式的位置信息。
+// Traceback是发生错误时的调用栈，只有 PCall 返回的错误才记录
+type Error struct {
+	Value     Value
+	Traceback string
+}
+
+func (e *Error) Error() string {
+	s, ok := toStringNumber(e.Value)
+	if !ok {
+		s = fmt.Sprintf("(error object is a %s value)", TypeName(e.Value))
+	}
+	if e.Traceback != "" {
+		s += "\n" + e.Traceback
+	}
+	return s
+}
+
+// raise 抛出错误对象为v的Lua错误。
+// 在xpcall中时先在发生错误的位置调用消息处理函数，用它的返回值作为错误对象
+func (L *LuaState) raise(v Value) {
+	if h := L.errHandler; h != nil {
+		// 消息处理函数中的错误不再交给它自己处理
+		results, err := L.pcall(h, []Value{v}, nil, false)
+		if err != nil {
+			v = "error in error handling"
+		} else if len(results) > 0 {
+			v = results[0]
+		} else {
+			v = nil
+		}
+	}
+	e := &Error{Value: v}
+	if L.traceback {
+		e.Traceback = debuginfo.Traceback("", L.stackFrames(0))
+	}
+	panic(e)
+}
+
+// runtimeError 抛出运行时错误，正在执行的是Lua函数时加上当前指令的位置，对应luaG_runerror
+func (L *LuaState) runtimeError(format string, a ...interface{}) {
+	if fr := L.currentFrame(); fr != nil && fr.cl != nil {
+		L.raise(debuginfo.NewError(fr.cl.proto, fr.currentPC(), format, a...).Error())
+	}
+	L.raise(fmt.Sprintf(format, a...))
+}
+
+// typeError 报告对寄存器reg中的值v做op操作时的类型错误，对应luaG_typeerror，
+// reg为负数表示v是常量或者立即数，不推断名字
+func (L *LuaState) typeError(reg int, v Value, op string) {
+	fr := L.currentFrame()
+	p, pc := fr.cl.proto, fr.currentPC()
+	if reg < 0 {
+		L.raise(debuginfo.NewError(p, pc, "attempt to %s a %s value", op, TypeName(v)).Error())
+	}
+	L.raise(debuginfo.TypeError(p, pc, reg, op, TypeName(v)).Error())
+}
+
+// upvalueTypeError 报告对第idx个upvalue中的值v做op操作时的类型错误
+func (L *LuaState) upvalueTypeError(idx int, v Value, op string) {
+	fr := L.currentFrame()
+	p := fr.cl.proto
+	L.raise(debuginfo.NewError(p, fr.currentPC(), "attempt to %s a %s value%s",
+		op, TypeName(v), debuginfo.UpvalueInfo(p, idx)).Error())
+}
+
+// currentFrame 返回正在执行的一层，调用栈为空时返回nil
+func (L *LuaState) currentFrame() *frame {
+	if len(L.frames) == 0 {
+		return nil
+	}
+	return L.frames[len(L.frames)-1]
+}
+
+// where 返回第level层（0是正在执行的函数）的位置"chunk:line: "，对应luaL_where，
+// Go函数和没有行号信息的Lua函数返回空字符串
+func (L *LuaState) where(level int) string {
+	i := len(L.frames) - 1 - level
+	if i < 0 || L.frames[i].cl == nil {
+		return ""
+	}
+	fr := L.frames[i]
+	p := fr.cl.proto
+	if line := p.LineForPC(fr.currentPC()); line > 0 {
+		return fmt.Sprintf("%s:%d: ", debuginfo.ChunkID(p.Source), line)
+	}
+	return ""
+}
+
+// Errorf 在Go函数中抛出Lua错误，信息前面加上调用这个Go函数的位置，对应luaL_error
+func (L *LuaState) Errorf(format string, a ...interface{}) {
+	L.raise(L.where(1) + fmt.Sprintf(format, a...))
+}
+
+// argError 报告Go函数fname的第n个参数错误，对应luaL_argerror
+func (L *LuaState) argError(n int, fname, msg string) {
+	L.Errorf("bad argument #%d to '%s' (%s)", n, fname, msg)
+}
+
+// checkArg 检查Go函数fname的第n个参数是否是typeName类型的值
+func (L *LuaState) checkArg(args []Value, n int, fname, typeName string) Value {
+	if n > len(args) || TypeName(args[n-1]) != typeName {
+		L.argError(n, fname, fmt.Sprintf("%s expected, got %s", typeName, argTypeName(args, n)))
+	}
+	return args[n-1]
+}
+
+// checkAny 检查Go函数fname至少有n个参数
+func (L *LuaState) checkAny(args []Value, n int, fname string) Value {
+	if n > len(args) {
+		L.argError(n, fname, "value expected")
+	}
+	return args[n-1]
+}
+
+// checkString 检查Go函数fname的第n个参数是否是字符串，数字转换为字符串，对应luaL_checkstring
+func (L *LuaState) checkString(args []Value, n int, fname string) string {
+	if n <= len(args) {
+		if s, ok := toStringNumber(args[n-1]); ok {
+			return s
+		}
+	}
+	L.argError(n, fname, "string expected, got "+argTypeName(args, n))
+	return ""
+}
+
+// checkInteger 检查Go函数fname的第n个参数是否可以转换为整数
+func (L *LuaState) checkInteger(args []Value, n int, fname string) int64 {
+	if n <= len(args) {
+		if i, ok := toInteger(args[n-1]); ok {
+			return i
+		}
+		if _, ok := toNumber(args[n-1]); ok {
+			L.argError(n, fname, "number has no integer representation")
+		}
+	}
+	L.argError(n, fname, "number expected, got "+argTypeName(args, n))
+	return 0
+}
+
+// optInteger 返回Go函数的第n个整数参数，参数不存在或为nil时返回def
+func (L *LuaState) optInteger(args []Value, n int, fname string, def int64) int64 {
+	if n > len(args) || args[n-1] == nil {
+		return def
+	}
+	return L.checkInteger(args, n, fname)
+}
+
+// argTypeName 返回第n个参数的类型名，参数不存在时返回"no value"
+func argTypeName(args []Value, n int) string {
+	if n > len(args) {
+		return "no value"
+	}
+	return TypeName(args[n-1])
+}
+
+// arg 返回第n个参数，参数不存在时返回nil
+func arg(args []Value, n int) Value {
+	if n > len(args) {
+		return nil
+	}
+	return args[n-1]
+}
@@ -0,0 +1,644 @@
+package state
+
+import (
+	"math"
+	"strings"
+
+	"github.com/depressi0n/myLua/binchunk"
+	"github.com/depressi0n/myLua/debuginfo"
+	"github.com/depressi0n/myLua/quota"
+	"github.com/depressi0n/myLua/vm"
+)
+
+// execute 执行调用栈层fr中的Lua函数直到返回，返回函数的返回值。
+// 尾调用另一个Lua函数时重新设置fr，在同一层中继续执行
+func (L *LuaState) execute(fr *frame) []Value {
+newFunction:
+	cl := fr.cl
+	p := cl.proto
+	code, consts := p.Code, p.Constants
+	regs := fr.regs
+	for {
+		if err := L.budget.Step(); err != nil {
+			panic(err)
+		}
+		i := vm.Instruction(code[fr.pc])
+		fr.pc++
+		if L.hooks.Active() {
+			L.hooks.Exec(p, fr.pc-1)
+		}
+		a, k, b, c := i.IABC()
+		switch op := i.Opcode(); op {
+		case vm.OP_MOVE:
+			regs[a] = regs[b]
+		case vm.OP_LOADI:
+			_, sbx := i.IAsBx()
+			regs[a] = int64(sbx)
+		case vm.OP_LOADF:
+			_, sbx := i.IAsBx()
+			regs[a] = float64(sbx)
+		case vm.OP_LOADK:
+			_, bx := i.IABx()
+			regs[a] = consts[bx]
+		case vm.OP_LOADKX:
+			regs[a] = consts[vm.Instruction(code[fr.pc]).IAx()]
+			fr.pc++
+		case vm.OP_LOADFALSE:
+			regs[a] = false
+		case vm.OP_LFALSESKIP:
+			regs[a] = false
+			fr.pc++
+		case vm.OP_LOADTRUE:
+			regs[a] = true
+		case vm.OP_LOADNIL:
+			for j := a; j <= a+b; j++ {
+				regs[j] = nil
+			}
+		case vm.OP_GETUPVAL:
+			regs[a] = cl.upvals[b].get()
+		case vm.OP_SETUPVAL:
+			cl.upvals[b].set(regs[a])
+		case vm.OP_GETTABUP:
+			t, ok := cl.upvals[b].get().(*Table)
+			if !ok {
+				L.upvalueTypeError(b, cl.upvals[b].get(), "index")
+			}
+			regs[a] = t.Get(consts[c])
+		case vm.OP_GETTABLE:
+			regs[a] = L.index(b, regs[b], regs[c])
+		case vm.OP_GETI:
+			regs[a] = L.index(b, regs[b], int64(c))
+		case vm.OP_GETFIELD:
+			regs[a] = L.index(b, regs[b], consts[c])
+		case vm.OP_SETTABUP:
+			t, ok := cl.upvals[a].get().(*Table)
+			if !ok {
+				L.upvalueTypeError(a, cl.upvals[a].get(), "index")
+			}
+			L.rawSet(t, consts[b], rk(regs, consts, k, c))
+		case vm.OP_SETTABLE:
+			L.setIndex(a, regs[a], regs[b], rk(regs, consts, k, c))
+		case vm.OP_SETI:
+			L.setIndex(a, regs[a], int64(b), rk(regs, consts, k, c))
+		case vm.OP_SETFIELD:
+			L.setIndex(a, regs[a], consts[b], rk(regs, consts, k, c))
+		case vm.OP_NEWTABLE:
+			// B是哈希部分大小的对数加1，C是数组部分的大小，k为1时加上EXTRAARG中的高位
+			if b > 0 {
+				b = 1 << (b - 1)
+			}
+			if k != 0 {
+				c += vm.Instruction(code[fr.pc]).IAx() * (vm.MAXARG_C + 1)
+			}
+			fr.pc++
+			L.alloc(quota.TableSize(c, b))
+			regs[a] = NewTable(c, b)
+		case vm.OP_SELF:
+			t, key := regs[b], rk(regs, consts, k, c)
+			regs[a+1] = t
+			regs[a] = L.index(b, t, key)
+		case vm.OP_ADDI:
+			L.arithInstr(fr, opAdd, a, regs[b], int64(vm.SC2Int(c)))
+		case vm.OP_ADDK, vm.OP_SUBK, vm.OP_MULK, vm.OP_MODK, vm.OP_POWK,
+			vm.OP_DIVK, vm.OP_IDIVK, vm.OP_BANDK, vm.OP_BORK, vm.OP_BXORK:
+			L.arithInstr(fr, op-vm.OP_ADDK, a, regs[b], consts[c])
+		case vm.OP_SHRI:
+			L.arithInstr(fr, opShr, a, regs[b], int64(vm.SC2Int(c)))
+		case vm.OP_SHLI:
+			L.arithInstr(fr, opShl, a, int64(vm.SC2Int(c)), regs[b])
+		case vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD, vm.OP_POW, vm.OP_DIV, vm.OP_IDIV,
+			vm.OP_BAND, vm.OP_BOR, vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR:
+			L.arithInstr(fr, op-vm.OP_ADD, a, regs[b], regs[c])
+		case vm.OP_MMBIN:
+			L.arithError(c-6, regs[a], a, regs[b], b)
+		case vm.OP_MMBINI:
+			imm := int64(vm.SC2Int(b))
+			if k != 0 {
+				L.arithError(c-6, imm, -1, regs[a], a)
+			}
+			L.arithError(c-6, regs[a], a, imm, -1)
+		case vm.OP_MMBINK:
+			if k != 0 {
+				L.arithError(c-6, consts[b], -1, regs[a], a)
+			}
+			L.arithError(c-6, regs[a], a, consts[b], -1)
+		case vm.OP_UNM:
+			if r, ok := L.arith(opUnm, regs[b], regs[b]); ok {
+				regs[a] = r
+			} else {
+				L.typeError(b, regs[b], "perform arithmetic on")
+			}
+		case vm.OP_BNOT:
+			if r, ok := L.arith(opBnot, regs[b], regs[b]); ok {
+				regs[a] = r
+			} else {
+				L.arithError(opBnot, regs[b], b, regs[b], b)
+			}
+		case vm.OP_NOT:
+			regs[a] = !truthy(regs[b])
+		case vm.OP_LEN:
+			regs[a] = L.length(b, regs[b])
+		case vm.OP_CONCAT:
+			regs[a] = L.concat(regs[a:a+b], a)
+		case vm.OP_CLOSE:
+			closeUpvalues(fr, a)
+		case vm.OP_TBC:
+			// 没有元表，只有nil和false可以作为待关闭变量
+			if truthy(regs[a]) {
+				L.closeError(fr, a)
+			}
+		case vm.OP_JMP:
+			fr.pc += i.IsJx()
+		case vm.OP_EQ:
+			L.condJump(fr, rawEqual(regs[a], regs[b]), k)
+		case vm.OP_LT:
+			L.condJump(fr, L.lessThan(regs[a], regs[b]), k)
+		case vm.OP_LE:
+			L.condJump(fr, L.lessEqual(regs[a], regs[b]), k)
+		case vm.OP_EQK:
+			L.condJump(fr, rawEqual(regs[a], consts[b]), k)
+		case vm.OP_EQI:
+			L.condJump(fr, rawEqual(regs[a], int64(vm.SC2Int(b))), k)
+		case vm.OP_LTI:
+			L.condJump(fr, L.lessThan(regs[a], int64(vm.SC2Int(b))), k)
+		case vm.OP_LEI:
+			L.condJump(fr, L.lessEqual(regs[a], int64(vm.SC2Int(b))), k)
+		case vm.OP_GTI:
+			L.condJump(fr, L.lessThan(int64(vm.SC2Int(b)), regs[a]), k)
+		case vm.OP_GEI:
+			L.condJump(fr, L.lessEqual(int64(vm.SC2Int(b)), regs[a]), k)
+		case vm.OP_TEST:
+			L.condJump(fr, truthy(regs[a]), k)
+		case vm.OP_TESTSET:
+			if truthy(regs[b]) != (k != 0) {
+				fr.pc++
+			} else {
+				regs[a] = regs[b]
+			}
+		case vm.OP_CALL:
+			fn, args := regs[a], L.callArgs(fr, a, b)
+			if !isFunction(fn) {
+				L.callError(a, fn)
+			}
+			L.storeResults(fr, a, c-1, L.call(fn, args))
+			regs = fr.regs
+		case vm.OP_TAILCALL:
+			fn, args := regs[a], L.callArgs(fr, a, b)
+			closeUpvalues(fr, 0)
+			switch f := fn.(type) {
+			case *Closure:
+				*fr = *newLuaFrame(f, args)
+				fr.tailCall = true
+				if L.hooks.Active() {
+					L.hooks.Enter(f.proto, true)
+				}
+				goto newFunction
+			case *GoFunction:
+				return L.callGo(f, args)
+			}
+			L.callError(a, fn)
+		case vm.OP_RETURN:
+			var results []Value
+			if b != 0 {
+				results = regs[a : a+b-1]
+			} else {
+				results = regs[a:L.stackTop(fr, a)]
+			}
+			closeUpvalues(fr, 0)
+			return results
+		case vm.OP_RETURN0:
+			closeUpvalues(fr, 0)
+			return nil
+		case vm.OP_RETURN1:
+			closeUpvalues(fr, 0)
+			return regs[a : a+1]
+		case vm.OP_FORLOOP:
+			if L.forLoop(regs, a) {
+				_, bx := i.IABx()
+				fr.pc -= bx
+			}
+		case vm.OP_FORPREP:
+			if L.forPrep(regs, a) {
+				_, bx := i.IABx()
+				fr.pc += bx + 1
+			}
+		case vm.OP_TFORPREP:
+			if truthy(regs[a+3]) {
+				L.closeError(fr, a+3)
+			}
+			_, bx := i.IABx()
+			fr.pc += bx
+		case vm.OP_TFORCALL:
+			fn := regs[a]
+			if !isFunction(fn) {
+				L.callError(a+4, fn)
+			}
+			results := L.call(fn, []Value{regs[a+1], regs[a+2]})
+			L.storeResults(fr, a+4, c, results)
+		case vm.OP_TFORLOOP:
+			if regs[a+4] != nil {
+				regs[a+2] = regs[a+4]
+				_, bx := i.IABx()
+				fr.pc -= bx
+			}
+		case vm.OP_SETLIST:
+			n := b
+			if n == 0 {
+				n = L.stackTop(fr, a+1) - a - 1
+			}
+			if k != 0 {
+				c += vm.Instruction(code[fr.pc]).IAx() * (vm.MAXARG_C + 1)
+				fr.pc++
+			}
+			t, ok := regs[a].(*Table)
+			if !ok {
+				L.runtimeError("SETLIST on a %s value", TypeName(regs[a]))
+			}
+			for j := 1; j <= n; j++ {
+				L.rawSet(t, int64(c+j), regs[a+j])
+			}
+		case vm.OP_CLOSURE:
+			_, bx := i.IABx()
+			regs[a] = L.newClosure(fr, p.Protos[bx])
+		case vm.OP_VARARG:
+			L.storeResults(fr, a, c-1, fr.varargs)
+			regs = fr.regs
+		case vm.OP_VARARGPREP:
+			// 额外参数在建立调用栈层时已经保存在varargs中
+		default:
+			L.runtimeError("unexpected instruction %s", i.OpName())
+		}
+	}
+}
+
+// rk 返回RK(C)：k为1时是常量C，否则是寄存器C
+func rk(regs []Value, consts []interface{}, k, c int) Value {
+	if k != 0 {
+		return consts[c]
+	}
+	return regs[c]
+}
+
+// grow 保证至少有n个寄存器
+func (fr *frame) grow(n int) {
+	if n > len(fr.regs) {
+		fr.regs = append(fr.regs, make([]Value, n-len(fr.regs))...)
+	}
+}
+
+// condJump 条件cond与k不同时跳过下一条JMP指令
+func (L *LuaState) condJump(fr *frame, cond bool, k int) {
+	if cond != (k != 0) {
+		fr.pc++
+	}
+}
+
+// callArgs 返回CALL和TAILCALL的参数，B为0时参数一直到上一条指令设置的栈顶
+func (L *LuaState) callArgs(fr *frame, a, b int) []Value {
+	if b != 0 {
+		return fr.regs[a+1 : a+b]
+	}
+	return fr.regs[a+1 : L.stackTop(fr, a+1)]
+}
+
+// stackTop 返回上一条指令设置的栈顶，检查它不小于base。
+// Verify不检查指令之间的顺序，伪造的字节码可能在设置栈顶之前使用它
+func (L *LuaState) stackTop(fr *frame, base int) int {
+	if fr.top < base || fr.top > len(fr.regs) {
+		L.runtimeError("stack top not set by a previous instruction")
+	}
+	return fr.top
+}
+
+// storeResults 把返回值保存到从寄存器a开始的位置，n为-1时保存所有返回值并设置栈顶
+func (L *LuaState) storeResults(fr *frame, a, n int, results []Value) {
+	if n < 0 {
+		n = len(results)
+		fr.top = a + n
+		fr.grow(a + n)
+	}
+	for j := 0; j < n; j++ {
+		var v Value
+		if j < len(results) {
+			v = results[j]
+		}
+		fr.regs[a+j] = v
+	}
+}
+
+func isFunction(v Value) bool {
+	switch v.(type) {
+	case *Closure, *GoFunction:
+		return true
+	}
+	return false
+}
+
+// callError 报告调用不是函数的值，名字由正在执行的指令推断，对应luaG_callerror
+func (L *LuaState) callError(reg int, fn Value) {
+	fr := L.currentFrame()
+	p, pc := fr.cl.proto, fr.currentPC()
+	if name, ok := debuginfo.FuncNameFromCode(p, pc); ok {
+		L.raise(debuginfo.NewError(p, pc, "attempt to call a %s value (%s)", TypeName(fn), name).Error())
+	}
+	L.typeError(reg, fn, "call")
+}
+
+// closeError 报告待关闭变量的值不是nil或false
+func (L *LuaState) closeError(fr *frame, reg int) {
+	name, ok := debuginfo.LocalName(fr.cl.proto, reg+1, fr.currentPC())
+	if !ok {
+		name = "?"
+	}
+	L.runtimeError("variable '%s' got a non-closable value", name)
+}
+
+// arithInstr 执行算术或位运算指令，成功时跳过后面的MMBIN指令。
+// 操作数不是数字时由MMBIN报告错误，与Lua一样错误位置是MMBIN指令
+func (L *LuaState) arithInstr(fr *frame, op, a int, x, y Value) {
+	if r, ok := L.arith(op, x, y); ok {
+		fr.regs[a] = r
+		fr.pc++
+		return
+	}
+	code := fr.cl.proto.Code
+	if fr.pc >= len(code) || !vm.Instruction(code[fr.pc]).Info().IsMetamethod {
+		// 手写的字节码中运算指令后面可能没有MMBIN
+		L.arithError(op, x, -1, y, -1)
+	}
+}
+
+// arithError 报告算术或位运算的操作数错误，对应luaT_trybinTM找不到元方法时的处理。
+// r1和r2是两个操作数所在的寄存器，常量和立即数为-1
+func (L *LuaState) arithError(op int, p1 Value, r1 int, p2 Value, r2 int) {
+	_, num1 := toNumber(p1)
+	_, num2 := toNumber(p2)
+	if isBitwise(op) && num1 && num2 {
+		// 两个操作数都是数字，其中一个没有整数表示
+		if _, ok := toInteger(p1); !ok {
+			p2, r2 = p1, r1
+		}
+		var info string
+		if fr := L.currentFrame(); r2 >= 0 {
+			info = debuginfo.VarInfo(fr.cl.proto, fr.currentPC(), r2)
+		}
+		L.runtimeError("number%s has no integer representation", info)
+	}
+	if !num1 {
+		p2, r2 = p1, r1
+	}
+	if isBitwise(op) {
+		L.typeError(r2, p2, "perform bitwise operation on")
+	}
+	L.typeError(r2, p2, "perform arithmetic on")
+}
+
+// index 返回寄存器reg中的表t的键key对应的值
+func (L *LuaState) index(reg int, t, key Value) Value {
+	tbl, ok := t.(*Table)
+	if !ok {
+		L.typeError(reg, t, "index")
+	}
+	return tbl.Get(key)
+}
+
+// setIndex 给寄存器reg中的表t的键key赋值
+func (L *LuaState) setIndex(reg int, t, key, v Value) {
+	tbl, ok := t.(*Table)
+	if !ok {
+		L.typeError(reg, t, "index")
+	}
+	L.rawSet(tbl, key, v)
+}
+
+// rawSet 给表t的键key赋值，key为nil或NaN时报告错误
+func (L *LuaState) rawSet(t *Table, key, v Value) {
+	narr, nhash, err := t.set(key, v)
+	if err != nil {
+		L.runtimeError("%s", err)
+	}
+	if narr > 0 || nhash > 0 {
+		L.alloc(quota.TableSize(narr, nhash) - quota.TableSize(0, 0))
+	}
+}
+
+// alloc 在分配n个字节时检查内存限制
+func (L *LuaState) alloc(n int64) {
+	if err := L.budget.Alloc(n); err != nil {
+		panic(err)
+	}
+}
+
+// tostring 把v转换为字符串，v不是字符串时新建的字符串计入内存和字符串长度的限制
+func (L *LuaState) tostring(v Value) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	s := ToString(v)
+	if err := L.budget.AllocString(len(s)); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// length 返回寄存器reg中的值v的长度
+func (L *LuaState) length(reg int, v Value) Value {
+	switch x := v.(type) {
+	case string:
+		return int64(len(x))
+	case *Table:
+		return x.Len()
+	}
+	L.typeError(reg, v, "get length of")
+	return nil
+}
+
+// concat 连接从寄存器a开始的值vals，只能连接字符串和数字。
+// 与luaV_concat一样从右向左两两连接，报告最先遇到的不能连接的值
+func (L *LuaState) concat(vals []Value, a int) Value {
+	for j := len(vals) - 1; j >= 0; j-- {
+		if _, ok := toStringNumber(vals[j]); ok {
+			continue
+		}
+		if j == len(vals)-1 && j > 0 {
+			if _, ok := toStringNumber(vals[j-1]); !ok {
+				j--
+			}
+		}
+		L.typeError(a+j, vals[j], "concatenate")
+	}
+	strs := make([]string, len(vals))
+	n := 0
+	for j, v := range vals {
+		strs[j], _ = toStringNumber(v)
+		n += len(strs[j])
+	}
+	if err := L.budget.AllocString(n); err != nil {
+		panic(err)
+	}
+	return strings.Join(strs, "")
+}
+
+// newClosure 用函数原型sub创建闭包，在栈上的upvalue取自fr的寄存器，其他的取自fr的闭包
+func (L *LuaState) newClosure(fr *frame, sub *binchunk.Prototype) *Closure {
+	L.alloc(quota.ClosureSize(len(sub.Upvalues)))
+	cl := &Closure{proto: sub, upvals: make([]*Upvalue, len(sub.Upvalues))}
+	for i, uv := range sub.Upvalues {
+		if uv.Instack != 0 {
+			cl.upvals[i] = findUpvalue(fr, int(uv.Idx))
+		} else {
+			cl.upvals[i] = fr.cl.upvals[uv.Idx]
+		}
+	}
+	return cl
+}
+
+// findUpvalue 返回寄存器idx上打开的upvalue，没有时创建一个
+func findUpvalue(fr *frame, idx int) *Upvalue {
+	for _, u := range fr.open {
+		if u.idx == idx {
+			return u
+		}
+	}
+	u := &Upvalue{fr: fr, idx: idx}
+	fr.open = append(fr.open, u)
+	return u
+}
+
+// closeUpvalues 关闭寄存器level及以上的upvalue，对应luaF_close
+func closeUpvalues(fr *frame, level int) {
+	open := fr.open[:0]
+	for _, u := range fr.open {
+		if u.idx >= level {
+			u.value = fr.regs[u.idx]
+			u.fr = nil
+		} else {
+			open = append(open, u)
+		}
+	}
+	for j := len(open); j < len(fr.open); j++ {
+		fr.open[j] = nil
+	}
+	fr.open = open
+}
+
+// forPrep 准备数值for循环，返回是否跳过整个循环，对应lvm.c中的forprep。
+// 整数循环在R[A+1]中保存剩余的循环次数，浮点数循环保存转换为浮点数的上限
+func (L *LuaState) forPrep(regs []Value, a int) bool {
+	init, limit, step := regs[a], regs[a+1], regs[a+2]
+	if i0, ok := init.(int64); ok {
+		if st, ok := step.(int64); ok {
+			if st == 0 {
+				L.runtimeError("'for' step is zero")
+			}
+			regs[a+3] = i0
+			lim, skip := L.forLimit(i0, limit, st)
+			if skip {
+				return true
+			}
+			var count uint64
+			if st > 0 {
+				count = uint64(lim) - uint64(i0)
+				if st != 1 {
+					count /= uint64(st)
+				}
+			} else {
+				count = uint64(i0) - uint64(lim)
+				count /= uint64(-(st + 1)) + 1
+			}
+			regs[a+1] = int64(count)
+			return false
+		}
+	}
+	flimit, ok := toFloat(limit)
+	if !ok {
+		L.forError("limit", limit)
+	}
+	fstep, ok := toFloat(step)
+	if !ok {
+		L.forError("step", step)
+	}
+	finit, ok := toFloat(init)
+	if !ok {
+		L.forError("initial value", init)
+	}
+	if fstep == 0 {
+		L.runtimeError("'for' step is zero")
+	}
+	if fstep > 0 && flimit < finit || fstep < 0 && finit < flimit {
+		return true
+	}
+	regs[a], regs[a+1], regs[a+2], regs[a+3] = finit, flimit, fstep, finit
+	return false
+}
+
+// forLimit 把整数循环的上限转换为整数，对应lvm.c中的forlimit
+func (L *LuaState) forLimit(init int64, limit Value, step int64) (int64, bool) {
+	var lim int64
+	if i, ok := limit.(int64); ok {
+		lim = i
+	} else {
+		f, ok := toFloat(limit)
+		if !ok {
+			L.forError("limit", limit)
+		}
+		// 步长为正时向下取整，为负时向上取整
+		if step < 0 {
+			f = math.Ceil(f)
+		} else {
+			f = math.Floor(f)
+		}
+		if i, ok := floatToInteger(f); ok {
+			lim = i
+		} else if f > 0 {
+			if step < 0 {
+				return 0, true
+			}
+			lim = math.MaxInt64
+		} else {
+			if step > 0 {
+				return 0, true
+			}
+			lim = math.MinInt64
+		}
+	}
+	if step > 0 {
+		return lim, init > lim
+	}
+	return lim, init < lim
+}
+
+func (L *LuaState) forError(what string, v Value) {
+	L.runtimeError("'for' %s must be a number, got %s", what, TypeName(v))
+}
+
+// forLoop 执行数值for循环的一次迭代，返回是否继续循环。
+// 循环变量应该已经由FORPREP转换为同一种数值类型，否则是伪造的字节码
+func (L *LuaState) forLoop(regs []Value, a int) bool {
+	if step, ok := regs[a+2].(int64); ok {
+		count, ok1 := regs[a+1].(int64)
+		idx, ok2 := regs[a].(int64)
+		if !ok1 || !ok2 {
+			L.runtimeError("'for' loop not prepared by FORPREP")
+		}
+		if count == 0 {
+			return false
+		}
+		idx += step
+		regs[a+1] = count - 1
+		regs[a], regs[a+3] = idx, idx
+		return true
+	}
+	step, ok1 := regs[a+2].(float64)
+	limit, ok2 := regs[a+1].(float64)
+	idx, ok3 := regs[a].(float64)
+	if !ok1 || !ok2 || !ok3 {
+		L.runtimeError("'for' loop not prepared by FORPREP")
+	}
+	idx += step
+	if step > 0 && idx <= limit || step <= 0 && limit <= idx {
+		regs[a], regs[a+3] = idx, idx
+		return true
+	}
+	return false
+}
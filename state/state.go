@@ -0,0 +1,278 @@
+// Package state 实现执行Lua5.4字节码的解释器。
+// LuaState 保存全局变量和调用栈，Lua函数的每一层调用有自己的寄存器，
+// Go函数通过 GoFunc 注册到解释器，可以再调用Lua函数。
+//
+// 解释器内部用panic传递Lua错误，Load 和 PCall 是宿主程序的入口，
+// 把错误作为返回值；Go函数中调用的 Call 不捕获错误，错误继续传给外层的保护调用。
+// SetLimits 限制执行不可信代码时使用的资源，超出限制的 *quota.Error 只有 PCall 能够捕获
+package state
+
+import (
+	"io"
+	"os"
+
+	"github.com/depressi0n/myLua/binchunk"
+	"github.com/depressi0n/myLua/hook"
+	"github.com/depressi0n/myLua/quota"
+)
+
+// maxCallDepth 是调用栈的最大层数，超过时报告"stack overflow"
+const maxCallDepth = 200000
+
+// LuaState 是一个独立的Lua状态，不能同时在多个goroutine中使用
+type LuaState struct {
+	globals *Table
+	frames  []*frame // 调用栈，最后一个是正在执行的函数
+	stdout  io.Writer
+
+	errHandler Value // 最内层xpcall的消息处理函数
+	traceback  bool  // 最内层的保护调用是宿主程序的PCall，抛出错误时记录调用栈
+
+	hooks   hook.Hooks
+	luaHook Value // debug.sethook设置的Lua钩子函数
+
+	budget *quota.Budget
+}
+
+// frame 是调用栈中的一层
+type frame struct {
+	cl       *Closure    // Lua函数，Go函数为nil
+	gofn     *GoFunction // Go函数
+	regs     []Value     // 寄存器，Go函数是调用时的参数
+	varargs  []Value     // vararg函数的额外参数
+	pc       int         // 下一条要执行的指令
+	top      int         // 上一条指令设置的栈顶，用于B或C为0的指令
+	open     []*Upvalue  // 打开的upvalue
+	tailCall bool        // 通过尾调用进入
+	hooked   bool        // 正在为这一层调用钩子函数
+}
+
+// currentPC 返回Lua函数正在执行的指令位置，
+// 调用钩子在执行第一条指令之前触发，这时与Lua的currentpc一样返回0
+func (fr *frame) currentPC() int {
+	if fr.pc == 0 {
+		return 0
+	}
+	return fr.pc - 1
+}
+
+// New 创建一个Lua状态并打开基础库
+func New() *LuaState {
+	L := &LuaState{globals: NewTable(0, 0), stdout: os.Stdout, budget: quota.NewBudget(quota.Limits{})}
+	L.openBase()
+	return L
+}
+
+// SetOutput 设置print函数的输出，默认为标准输出
+func (L *LuaState) SetOutput(w io.Writer) {
+	L.stdout = w
+}
+
+// Globals 返回全局变量表，即主函数的_ENV
+func (L *LuaState) Globals() *Table {
+	return L.globals
+}
+
+// SetGlobal 给全局变量name赋值
+func (L *LuaState) SetGlobal(name string, v Value) {
+	L.globals.Set(name, v)
+}
+
+// GetGlobal 返回全局变量name的值
+func (L *LuaState) GetGlobal(name string) Value {
+	return L.globals.Get(name)
+}
+
+// Register 把Go函数fn注册为全局函数name
+func (L *LuaState) Register(name string, fn GoFunc) {
+	L.SetGlobal(name, NewGoFunction(name, fn))
+}
+
+// SetLimits 设置资源限制并重新开始统计使用的资源，默认不限制。
+// 内存限制统计表、闭包和运行时创建的字符串，包括连接以及tostring和print的转换结果；
+// 调用函数时分配的寄存器和vararg不计入，它们在函数返回之后就可以回收，
+// 同时存在的数量由调用深度的限制约束
+func (L *LuaState) SetLimits(l quota.Limits) {
+	L.budget = quota.NewBudget(l)
+}
+
+// Budget 返回已经使用的资源，例如执行的指令数和分配的字节数
+func (L *LuaState) Budget() *quota.Budget {
+	return L.budget
+}
+
+// SetHook 设置调试钩子，对应lua_sethook，fn为nil或mask为0时关闭钩子。
+// 钩子函数在触发事件的函数中同步调用，可以用 Call 调用Lua函数，抛出的错误传给触发事件的函数
+func (L *LuaState) SetHook(fn hook.Func, mask hook.Mask, count int) {
+	L.hooks.Set(fn, mask, count)
+	L.luaHook = nil
+}
+
+// GetHook 返回当前的钩子函数、事件和计数
+func (L *LuaState) GetHook() (hook.Func, hook.Mask, int) {
+	return L.hooks.Get()
+}
+
+// Load 检查函数原型并创建主函数的闭包，主函数的第一个upvalue是全局变量表_ENV
+func (L *LuaState) Load(proto *binchunk.Prototype) (*Closure, error) {
+	if err := binchunk.Verify(proto); err != nil {
+		return nil, err
+	}
+	cl := &Closure{proto: proto, upvals: make([]*Upvalue, len(proto.Upvalues))}
+	for i := range cl.upvals {
+		cl.upvals[i] = &Upvalue{}
+	}
+	if len(cl.upvals) > 0 {
+		cl.upvals[0].value = L.globals
+	}
+	return cl, nil
+}
+
+// Call 调用函数fn并返回所有返回值，只能在被解释器调用的Go函数中使用，
+// 发生的错误不在这里捕获，而是传给外层的 PCall 或者Lua的pcall
+func (L *LuaState) Call(fn Value, args ...Value) []Value {
+	return L.call(fn, args)
+}
+
+// PCall 在保护模式下调用函数fn，对应lua_pcall，Lua代码中的错误以 *Error 返回，
+// 其中带有发生错误时Lua函数和Go函数交错的调用栈。
+// 超出资源限制时返回 *quota.Error，Lua代码中的pcall和xpcall不能捕获这种错误
+func (L *LuaState) PCall(fn Value, args ...Value) (results []Value, err error) {
+	depth := len(L.frames)
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			if !ok || quota.Catchable(e) {
+				panic(r)
+			}
+			L.unwind(depth)
+			results, err = nil, e
+		}
+	}()
+	results, e := L.pcall(fn, args, nil, true)
+	if e != nil {
+		return nil, e
+	}
+	return results, nil
+}
+
+// pcall 调用函数fn，捕获Lua错误并恢复调用栈。handler不为nil时是xpcall的消息处理函数，
+// 在发生错误的位置调用；traceback为true时错误中带有发生错误时的调用栈。
+// 其他panic不捕获，包括超出资源限制的错误
+func (L *LuaState) pcall(fn Value, args []Value, handler Value, traceback bool) (results []Value, err *Error) {
+	depth := len(L.frames)
+	oldHandler, oldTraceback := L.errHandler, L.traceback
+	L.errHandler, L.traceback = handler, traceback
+	defer func() {
+		L.errHandler, L.traceback = oldHandler, oldTraceback
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			L.unwind(depth)
+			err = e
+		}
+	}()
+	return L.call(fn, args), nil
+}
+
+// unwind 在捕获错误之后弹出调用栈中depth以上的各层
+func (L *LuaState) unwind(depth int) {
+	for i := depth; i < len(L.frames); i++ {
+		L.frames[i] = nil
+		L.budget.Leave()
+	}
+	L.frames = L.frames[:depth]
+}
+
+// call 调用函数fn，返回所有返回值
+func (L *LuaState) call(fn Value, args []Value) []Value {
+	switch f := fn.(type) {
+	case *Closure:
+		return L.callLua(f, args)
+	case *GoFunction:
+		return L.callGo(f, args)
+	}
+	L.runtimeError("attempt to call a %s value", TypeName(fn))
+	return nil
+}
+
+// pushFrame 把fr压入调用栈
+func (L *LuaState) pushFrame(fr *frame) {
+	if len(L.frames) >= maxCallDepth {
+		L.runtimeError("stack overflow")
+	}
+	if err := L.budget.Enter(); err != nil {
+		panic(err)
+	}
+	L.frames = append(L.frames, fr)
+}
+
+// popFrame 弹出正在执行的一层
+func (L *LuaState) popFrame() {
+	L.budget.Leave()
+	L.frames[len(L.frames)-1] = nil
+	L.frames = L.frames[:len(L.frames)-1]
+}
+
+// callLua 为Lua函数建立新的一层并执行
+func (L *LuaState) callLua(cl *Closure, args []Value) []Value {
+	callerPC := L.callerPC()
+	fr := newLuaFrame(cl, args)
+	L.pushFrame(fr)
+	if L.hooks.Active() {
+		L.hooks.Enter(cl.proto, false)
+	}
+	results := L.execute(fr)
+	if L.hooks.Active() {
+		// 尾调用之后fr中是最后被调用的函数
+		L.hooks.Leave(fr.cl.proto, callerPC)
+	}
+	L.popFrame()
+	return results
+}
+
+// newLuaFrame 为Lua函数建立调用栈层，参数放在前几个寄存器中，多余的参数作为vararg
+func newLuaFrame(cl *Closure, args []Value) *frame {
+	p := cl.proto
+	fr := &frame{cl: cl, regs: make([]Value, p.MaxStackSize)}
+	nparams := int(p.NumParams)
+	copy(fr.regs[:nparams], args)
+	if p.IsVararg != 0 && len(args) > nparams {
+		fr.varargs = append([]Value(nil), args[nparams:]...)
+	}
+	return fr
+}
+
+// callGo 调用Go函数，返回的错误作为Lua错误抛出
+func (L *LuaState) callGo(f *GoFunction, args []Value) []Value {
+	callerPC := L.callerPC()
+	L.pushFrame(&frame{gofn: f, regs: args})
+	if L.hooks.Active() {
+		L.hooks.Enter(nil, false)
+	}
+	results, err := f.fn(L, args)
+	if err != nil {
+		if !quota.Catchable(err) {
+			panic(err)
+		}
+		if e, ok := err.(*Error); ok {
+			L.raise(e.Value)
+		}
+		L.raise(L.where(1) + err.Error())
+	}
+	if L.hooks.Active() {
+		L.hooks.Leave(nil, callerPC)
+	}
+	L.popFrame()
+	return results
+}
+
+// callerPC 返回正在执行的Lua函数的指令位置，返回到这个函数之后从这里继续检查行事件
+func (L *LuaState) callerPC() int {
+	if fr := L.currentFrame(); fr != nil && fr.cl != nil {
+		return fr.currentPC()
+	}
+	return 0
+}
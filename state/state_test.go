@@ -0,0 +1,850 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/depressi0n/myLua/asm"
+	"github.com/depressi0n/myLua/binchunk"
+	"github.com/depressi0n/myLua/hook"
+	"github.com/depressi0n/myLua/peephole"
+	"github.com/depressi0n/myLua/quota"
+)
+
+// optimized 为true时load先用peephole.Optimize优化汇编得到的函数原型
+var optimized bool
+
+// load 汇编src并创建主函数的闭包，print的输出写入out
+func load(t testing.TB, src string, out *bytes.Buffer) (*LuaState, *Closure) {
+	t.Helper()
+	p, err := asm.Assemble([]byte(".source \"@test.lua\"\n.upval _ENV 1 0\n"+src), "test.lasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if optimized {
+		if err := peephole.Optimize(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	L := New()
+	L.SetOutput(out)
+	cl, err := L.Load(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return L, cl
+}
+
+func TestExecute(t *testing.T) {
+	// local s = 0 for i = 1, 10 do s = s + i end print(s, s / 4, "n" .. s)
+	src := `
+.stack 8
+.const "print"
+.const "n"
+.const 10
+.line 1
+        LOADI 0 0
+        LOADI 1 1
+        LOADK 2 2 ; 优化之后改写为LOADI
+        LOADI 3 1
+        FORPREP 1 done
+loop:   ADD 0 0 4
+        MMBIN 0 4 6
+        FORLOOP 1 loop
+done:   GETTABUP 1 0 0
+        MOVE 2 0
+        LOADI 4 4
+        DIV 3 0 4
+        MMBIN 0 4 11
+        LOADK 4 1
+        MOVE 5 0
+        CONCAT 4 2
+        CALL 1 4 1
+        RETURN 0 1 1
+.local s 1 done
+`
+	var out bytes.Buffer
+	L, cl := load(t, src, &out)
+	if _, err := L.PCall(cl); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "55\t13.75\tn55\n" {
+		t.Errorf("got output %q", got)
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		src, msg string
+	}{
+		// f()
+		{`
+.const "f"
+.line 1
+        JMP get ; 优化之后删除跳转到下一条指令的JMP
+get:    GETTABUP 0 0 0
+        CALL 0 1 1
+        RETURN0
+`, "test.lua:1: attempt to call a nil value (global 'f')"},
+		// local t; local y = t.x
+		{`
+.const "x"
+.line 1
+        LOADNIL 0 0
+.line 2
+        GETFIELD 1 0 0
+        RETURN0
+.local t 1 3
+`, "test.lua:2: attempt to index a nil value (local 't')"},
+		// local t = {} return t.x + 1
+		{`
+.const "x"
+.line 3
+        NEWTABLE 0 0 0
+        EXTRAARG 0
+        GETFIELD 1 0 0
+        ADDI 1 1 1
+        MMBINI 1 1 6
+        RETURN1 1
+.local t 2 6
+`, "test.lua:3: attempt to perform arithmetic on a nil value (field 'x')"},
+		// local s = "a" .. {}
+		{`
+.const "a"
+.line 1
+        LOADK 0 0
+        NEWTABLE 1 0 0
+        EXTRAARG 0
+        CONCAT 0 2
+        RETURN0
+`, "test.lua:1: attempt to concatenate a table value"},
+		// return 1 < "x"
+		{`
+.const "x"
+.line 4
+        LOADI 0 1
+        LOADK 1 0
+        LT 0 1 0
+        JMP 0
+        RETURN0
+`, "test.lua:4: attempt to compare number with string"},
+		// return 1 // 0
+		{`
+.line 1
+        LOADI 0 1
+        LOADI 1 0
+        IDIV 0 0 1
+        MMBIN 0 1 12
+        RETURN0
+`, "test.lua:1: attempt to perform 'n//0'"},
+		// local x = 1.5 return x | 1
+		{`
+.line 1
+        LOADF 0 1
+        LOADI 1 2
+        DIV 0 0 1
+        MMBIN 0 1 11
+        LOADI 1 1
+        BOR 0 0 1
+        MMBIN 0 1 14
+        RETURN0
+.local x 4 8
+`, "test.lua:1: number (local 'x') has no integer representation"},
+		// for i = 1, nil do end
+		{`
+.stack 4
+.line 2
+        LOADI 0 1
+        LOADNIL 1 0
+        LOADI 2 1
+        FORPREP 0 done
+loop:   FORLOOP 0 loop
+done:   RETURN0
+`, "test.lua:2: 'for' limit must be a number, got nil"},
+		// error("boom")
+		{`
+.const "error"
+.const "boom"
+.line 5
+        GETTABUP 0 0 0
+        LOADK 1 1
+        CALL 0 2 1
+        RETURN0
+`, "test.lua:5: boom"},
+		// 通过Verify的伪造字节码：B为0的CALL之前没有设置栈顶
+		{`
+.const "f"
+.line 1
+        GETTABUP 0 0 0
+        CALL 0 0 1
+        RETURN0
+`, "test.lua:1: stack top not set by a previous instruction"},
+		// SETLIST的目标不是表
+		{`
+.line 1
+        LOADI 0 1
+        SETLIST 0 1 0
+        RETURN0
+`, "test.lua:1: SETLIST on a number value"},
+		// 没有FORPREP的FORLOOP
+		{`
+.stack 4
+.line 1
+        LOADI 0 1
+loop:   FORLOOP 0 loop
+        RETURN0
+`, "test.lua:1: 'for' loop not prepared by FORPREP"},
+	}
+	for _, tt := range tests {
+		L, cl := load(t, tt.src, nil)
+		_, err := L.PCall(cl)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("expected *Error for %q, got %v", tt.msg, err)
+			continue
+		}
+		if e.Value != tt.msg {
+			t.Errorf("got error %q, want %q", e.Value, tt.msg)
+		}
+		if len(L.frames) != 0 {
+			t.Errorf("%d frames left after %q", len(L.frames), tt.msg)
+		}
+	}
+}
+
+func TestProtectedCall(t *testing.T) {
+	// print(pcall(error, "x", 0))
+	// print(xpcall(function() local t; t = t.k end, function(m) return "handled: " .. m end))
+	src := `
+.stack 6
+.const "print"
+.const "pcall"
+.const "error"
+.const "x"
+.const "xpcall"
+.line 1
+        GETTABUP 0 0 0
+        GETTABUP 1 0 1
+        GETTABUP 2 0 2
+        LOADK 3 3
+        LOADI 4 0
+        CALL 1 4 0
+        CALL 0 0 1
+.line 2
+        GETTABUP 0 0 0
+        GETTABUP 1 0 4
+        CLOSURE 2 f
+        CLOSURE 3 handler
+        CALL 1 3 0
+        CALL 0 0 1
+        RETURN0
+
+.function f
+.linedefined 3
+.line 3
+        LOADNIL 0 0
+        GETFIELD 0 0 0
+        RETURN0
+.const "k"
+.local t 1 3
+.end
+
+.function handler
+.stack 3
+.params 1
+.const "handled: "
+.line 4
+        LOADK 1 0
+        MOVE 2 0
+        CONCAT 1 2
+        RETURN1 1
+.end
+`
+	var out bytes.Buffer
+	L, cl := load(t, src, &out)
+	if _, err := L.PCall(cl); err != nil {
+		t.Fatal(err)
+	}
+	want := "false\tx\n" +
+		"false\thandled: test.lua:3: attempt to index a nil value (local 't')\n"
+	if got := out.String(); got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestGoFunction(t *testing.T) {
+	// return add(1, 2), fail()
+	src := `
+.stack 4
+.const "add"
+.const "fail"
+.line 7
+        GETTABUP 0 0 0
+        LOADI 1 1
+        LOADI 2 2
+        CALL 0 3 2
+        GETTABUP 1 0 1
+        CALL 1 1 0
+        RETURN 0 0 1
+`
+	L, cl := load(t, src, nil)
+	L.Register("add", func(L *LuaState, args []Value) ([]Value, error) {
+		return []Value{L.checkInteger(args, 1, "add") + L.checkInteger(args, 2, "add")}, nil
+	})
+	fail := errors.New("failed")
+	L.Register("fail", func(L *LuaState, args []Value) ([]Value, error) {
+		return nil, fail
+	})
+	if _, err := L.PCall(cl); err == nil || err.(*Error).Value != "test.lua:7: failed" {
+		t.Errorf("got error %v", err)
+	}
+	L.Register("fail", func(L *LuaState, args []Value) ([]Value, error) {
+		return []Value{"ok", nil}, nil
+	})
+	results, err := L.PCall(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Value{int64(3), "ok", nil}; !reflect.DeepEqual(results, want) {
+		t.Errorf("got results %v, want %v", results, want)
+	}
+}
+
+func TestTable(t *testing.T) {
+	tbl := NewTable(0, 0)
+	for i := int64(1); i <= 10; i++ {
+		tbl.Set(i, i*i)
+	}
+	tbl.Set(2.0, "two")
+	tbl.Set("k", true)
+	if tbl.Len() != 10 || tbl.Get(int64(2)) != "two" || tbl.Get(float64(3)) != int64(9) {
+		t.Errorf("unexpected table contents")
+	}
+	if err := tbl.Set(nil, 1); err == nil {
+		t.Errorf("expected error for nil index")
+	}
+	tbl.Set(int64(10), nil)
+	if tbl.Len() != 9 {
+		t.Errorf("Len() = %d, want 9", tbl.Len())
+	}
+	n := 0
+	for k, _, _ := tbl.Next(nil); k != nil; k, _, _ = tbl.Next(k) {
+		n++
+	}
+	if n != 10 {
+		t.Errorf("Next visited %d keys, want 10", n)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	// local f = function() return 1 end f()
+	src := `
+.stack 3
+.line 1
+        CLOSURE 0 f
+.line 2
+        MOVE 1 0
+        CALL 1 1 1
+.line 3
+        RETURN0
+
+.function f
+.linedefined 10
+.line 11
+        LOADI 0 1
+        RETURN1 0
+.end
+`
+	L, cl := load(t, src, nil)
+	var events []string
+	L.SetHook(func(info hook.Info) {
+		events = append(events, fmt.Sprintf("%s %d %d", info.Event, info.Proto.LineDefined, info.Line))
+	}, hook.MaskCall|hook.MaskReturn|hook.MaskLine|hook.MaskCount, 2)
+	if _, err := L.PCall(cl); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"call 0 -1", "line 0 1", "count 0 -1", "line 0 2",
+		"call 10 -1", "count 10 -1", "line 10 11", "return 10 -1",
+		"count 0 -1", "line 0 3", "return 0 -1",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got events %q, want %q", events, want)
+	}
+}
+
+func TestDebugSetHook(t *testing.T) {
+	// debug.sethook(function(e, l) print(e, l) end, "l") local x = 1
+	src := `
+.stack 3
+.const "debug"
+.const "sethook"
+.const "l"
+.line 1
+        GETTABUP 0 0 0
+        GETFIELD 0 0 1
+        CLOSURE 1 hookfn
+        LOADK 2 2
+        CALL 0 3 1
+.line 2
+        LOADI 0 1
+.line 3
+        RETURN0
+
+.function hookfn
+.params 2
+.stack 5
+.upval _ENV 0 0
+.const "print"
+        GETTABUP 2 0 0
+        MOVE 3 0
+        MOVE 4 1
+        CALL 2 3 1
+        RETURN0
+.end
+`
+	var out bytes.Buffer
+	L, cl := load(t, src, &out)
+	L.OpenDebug()
+	if _, err := L.PCall(cl); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "line\t2\nline\t3\n" {
+		t.Errorf("got output %q", got)
+	}
+	results, _ := debugGetHook(L, nil)
+	if _, ok := results[0].(*Closure); !ok || !reflect.DeepEqual(results[1:], []Value{"l", int64(0)}) {
+		t.Errorf("gethook returned %v", results)
+	}
+	L.SetHook(func(hook.Info) {}, hook.MaskCall, 0)
+	if results, _ := debugGetHook(L, nil); results[0] != "external hook" || results[1] != "c" {
+		t.Errorf("gethook returned %v for a Go hook", results)
+	}
+	debugSetHook(L, nil)
+	if results, _ := debugGetHook(L, nil); !reflect.DeepEqual(results, []Value{nil}) {
+		t.Errorf("gethook returned %v after removing the hook", results)
+	}
+}
+
+func TestDebugLibrary(t *testing.T) {
+	// f = function(a, b) local c = a + b; debug.setlocal(1, 3, 10)
+	//   check(debug.traceback("msg"), debug.getinfo(1, "nSlu"), debug.getlocal(1, 3)) end
+	// f(1, 2)
+	src := `
+.stack 4
+.const "f"
+.line 1
+        CLOSURE 0 f
+        SETTABUP 0 0 0
+.line 2
+        GETTABUP 0 0 0
+        LOADI 1 1
+        LOADI 2 2
+        CALL 0 3 1
+        RETURN0
+
+.function f
+.linedefined 3
+.lastlinedefined 7
+.params 2
+.stack 9
+.upval _ENV 0 0
+.const "check"
+.const "debug"
+.const "getinfo"
+.const "nSlu"
+.const "getlocal"
+.const "setlocal"
+.const "traceback"
+.const "msg"
+.line 4
+        ADD 2 0 1
+        MMBIN 0 1 6
+.line 5
+        GETTABUP 3 0 1
+        GETFIELD 3 3 5
+        LOADI 4 1
+        LOADI 5 3
+        LOADI 6 10
+        CALL 3 4 1
+.line 6
+        GETTABUP 3 0 0
+        GETTABUP 4 0 1
+        GETFIELD 4 4 6
+        LOADK 5 7
+        CALL 4 2 2
+        GETTABUP 5 0 1
+        GETFIELD 5 5 2
+        LOADI 6 1
+        LOADK 7 3
+        CALL 5 3 2
+        GETTABUP 6 0 1
+        GETFIELD 6 6 4
+        LOADI 7 1
+        LOADI 8 3
+        CALL 6 3 0
+        CALL 3 0 1
+.line 7
+        RETURN0
+.local a 0 25
+.local b 0 25
+.local c 2 25
+.end
+`
+	L, cl := load(t, src, nil)
+	L.OpenDebug()
+	var args []Value
+	var temp []Value
+	L.Register("check", func(L *LuaState, a []Value) ([]Value, error) {
+		args = a
+		temp, _ = debugGetLocal(L, []Value{int64(0), int64(1)})
+		return nil, nil
+	})
+	if _, err := L.PCall(cl); err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 4 {
+		t.Fatalf("check got %d arguments", len(args))
+	}
+	want := "msg\nstack traceback:\n\ttest.lua:6: in function 'f'\n\ttest.lua:2: in main chunk"
+	if args[0] != want {
+		t.Errorf("got traceback\n%s\nwant\n%s", args[0], want)
+	}
+	info := args[1].(*Table)
+	fields := map[string]Value{
+		"name": "f", "namewhat": "global", "what": "Lua", "source": "@test.lua", "short_src": "test.lua",
+		"linedefined": int64(3), "lastlinedefined": int64(7), "currentline": int64(6),
+		"nups": int64(1), "nparams": int64(2), "isvararg": false,
+	}
+	for k, v := range fields {
+		if got := info.Get(k); got != v {
+			t.Errorf("getinfo field %s = %v, want %v", k, got, v)
+		}
+	}
+	if args[2] != "c" || args[3] != int64(10) {
+		t.Errorf("getlocal returned %v, %v", args[2], args[3])
+	}
+	if len(temp) != 2 || temp[0] != "(C temporary)" || temp[1] != args[0] {
+		t.Errorf("getlocal in a Go function returned %v", temp)
+	}
+}
+
+func TestCallHookFrame(t *testing.T) {
+	// local f = function(a) return a end f(5)
+	src := `
+.stack 3
+.line 1
+        CLOSURE 0 f
+.line 2
+        MOVE 1 0
+        LOADI 2 5
+        CALL 1 2 1
+        RETURN0
+
+.function f
+.linedefined 10
+.params 1
+.line 11
+        RETURN1 0
+.local a 0 1
+.end
+`
+	L, cl := load(t, src, nil)
+	L.OpenDebug()
+	debug := L.GetGlobal("debug").(*Table)
+	var local, traceback []Value
+	L.SetHook(func(info hook.Info) {
+		// 调用钩子在执行f的第一条指令之前触发
+		if info.Event == hook.Call && info.Proto.LineDefined == 10 {
+			local = L.Call(debug.Get("getlocal"), int64(1), int64(1))
+			traceback = L.Call(debug.Get("traceback"))
+		}
+	}, hook.MaskCall, 0)
+	if _, err := L.PCall(cl); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(local, []Value{"a", int64(5)}) {
+		t.Errorf("getlocal returned %v", local)
+	}
+	want := "stack traceback:\n\ttest.lua:11: in function <test.lua:10>\n\ttest.lua:2: in main chunk"
+	if len(traceback) != 1 || traceback[0] != want {
+		t.Errorf("got traceback %q, want %q", traceback, want)
+	}
+}
+
+func TestDebugUpvalues(t *testing.T) {
+	p := &binchunk.Prototype{Upvalues: make([]binchunk.Upvalue, 2), UpvalueNames: []string{"x"}}
+	f1 := &Closure{proto: p, upvals: []*Upvalue{{value: int64(1)}, {value: int64(2)}}}
+	f2 := &Closure{proto: p, upvals: []*Upvalue{{value: int64(3)}, {value: int64(4)}}}
+	L := New()
+	call := func(fn GoFunc, args ...Value) ([]Value, error) {
+		return L.PCall(NewGoFunction("debug", fn), args...)
+	}
+
+	if got, _ := call(debugGetUpvalue, f1, int64(1)); !reflect.DeepEqual(got, []Value{"x", int64(1)}) {
+		t.Errorf("getupvalue(f1, 1) = %v", got)
+	}
+	if got, _ := call(debugSetUpvalue, f1, int64(2), "y"); !reflect.DeepEqual(got, []Value{"(no name)"}) {
+		t.Errorf("setupvalue(f1, 2) = %v", got)
+	}
+	if got, _ := call(debugGetUpvalue, f1, int64(3)); len(got) != 0 {
+		t.Errorf("getupvalue(f1, 3) = %v", got)
+	}
+	if got, _ := call(debugUpvalueID, f1, int64(3)); !reflect.DeepEqual(got, []Value{nil}) {
+		t.Errorf("upvalueid(f1, 3) = %v", got)
+	}
+	if _, err := call(debugUpvalueJoin, f1, int64(2), f2, int64(1)); err != nil {
+		t.Fatal(err)
+	}
+	id1, _ := call(debugUpvalueID, f1, int64(2))
+	id2, _ := call(debugUpvalueID, f2, int64(1))
+	if id1[0] != id2[0] || TypeName(id1[0]) != "userdata" {
+		t.Errorf("upvalueid after upvaluejoin: %v, %v", id1, id2)
+	}
+	if got, _ := call(debugGetUpvalue, f1, int64(2)); got[1] != int64(3) {
+		t.Errorf("getupvalue(f1, 2) after upvaluejoin = %v", got)
+	}
+	_, err := call(debugUpvalueJoin, f1, int64(1), f2, int64(3))
+	if err == nil || err.(*Error).Value != "bad argument #4 to 'upvaluejoin' (invalid upvalue index)" {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestTraceback(t *testing.T) {
+	// callback(function() local t; t = t.x end)
+	src := `
+.const "callback"
+.line 2
+        GETTABUP 0 0 0
+        CLOSURE 1 f
+        CALL 0 2 1
+        RETURN0
+
+.function f
+.linedefined 3
+.const "x"
+.line 4
+        LOADNIL 0 0
+        LOADNIL 1 0 ; 优化之后与上一条LOADNIL合并
+        GETFIELD 0 0 0
+        RETURN0
+.end
+`
+	L, cl := load(t, src, nil)
+	var file string
+	var line int
+	L.Register("callback", func(L *LuaState, args []Value) ([]Value, error) {
+		_, file, line, _ = runtime.Caller(0)
+		return L.Call(args[0]), nil
+	})
+	_, err := L.PCall(cl)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	want := fmt.Sprintf(`test.lua:4: attempt to index a nil value
+stack traceback:
+	test.lua:4: in function <test.lua:3>
+	[Go function] %s:%d: in function 'callback'
+	test.lua:2: in main chunk`, file, line+1)
+	if got := err.Error(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// Lua的pcall捕获的错误不记录调用栈
+	results, err := L.PCall(L.GetGlobal("pcall"), cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[1] != "test.lua:4: attempt to index a nil value" {
+		t.Errorf("pcall returned %v", results)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		limits   quota.Limits
+		resource quota.Resource
+		body     string
+	}{
+		// while true do end
+		{quota.Limits{Instructions: 1000}, quota.Instructions, `
+loop:   JMP loop
+        RETURN0
+`},
+		// while true do local t = {} end
+		{quota.Limits{Memory: 10000}, quota.Memory, `
+loop:   NEWTABLE 0 0 0
+        EXTRAARG 0
+        JMP loop
+        RETURN0
+`},
+		// local t = {} for i = 1, math.huge do t[i] = i end
+		{quota.Limits{Memory: 10000}, quota.Memory, `
+.stack 5
+        NEWTABLE 0 0 0
+        EXTRAARG 0
+        LOADI 1 1
+        LOADF 2 1
+        LOADI 3 0
+        DIV 2 2 3
+        MMBIN 2 3 11
+        LOADI 3 1
+        FORPREP 1 done
+loop:   SETTABLE 0 4 4
+        FORLOOP 1 loop
+done:   RETURN0
+`},
+		// local s = "ab" while true do s = s .. s end
+		{quota.Limits{StringLen: 1000}, quota.StringLen, `
+.const "ab"
+        LOADK 0 0
+loop:   MOVE 1 0
+        CONCAT 0 2
+        JMP loop
+        RETURN0
+`},
+		// local function f() f() end f()
+		{quota.Limits{CallDepth: 50}, quota.CallDepth, `
+        CLOSURE 0 f
+        MOVE 1 0
+        CALL 1 1 1
+        RETURN0
+.function f
+.upval f 1 0
+        GETUPVAL 0 0
+        CALL 0 1 1
+        RETURN0
+.end
+`},
+		// while true do local s = tostring(1.5) end
+		{quota.Limits{Memory: 10000}, quota.Memory, `
+.stack 3
+.upval _ENV 0 0
+.const "tostring"
+loop:   GETTABUP 0 0 0
+        LOADF 1 1
+        CALL 0 2 2
+        JMP loop
+        RETURN0
+`},
+		// spend()
+		{quota.Limits{}, quota.Memory, `
+.upval _ENV 0 0
+.const "spend"
+        GETTABUP 0 0 0
+        CALL 0 1 1
+        RETURN0
+`},
+	}
+	for _, tt := range tests {
+		// pcall(function() body end)
+		src := `
+.const "pcall"
+        GETTABUP 0 0 0
+        CLOSURE 1 body
+        CALL 0 2 1
+        RETURN0
+.function body
+` + tt.body + `
+.end
+`
+		L, cl := load(t, src, nil)
+		L.SetLimits(tt.limits)
+		L.Register("spend", func(L *LuaState, args []Value) ([]Value, error) {
+			return nil, fmt.Errorf("spend: %w", &quota.Error{Resource: quota.Memory, Limit: 1})
+		})
+		_, err := L.PCall(cl)
+		var qe *quota.Error
+		if !errors.As(err, &qe) || qe.Resource != tt.resource {
+			t.Errorf("expected %s quota error, got %v", tt.resource, err)
+			continue
+		}
+		if len(L.frames) != 0 || L.Budget().Depth() != 0 {
+			t.Errorf("%s: %d frames and depth %d left", tt.resource, len(L.frames), L.Budget().Depth())
+		}
+	}
+}
+
+// sumLoop 是 TestUnlimited 和 BenchmarkExecute 使用的循环
+// local s = 0 for i = 1, 100000 do s = s + i end return s
+const sumLoop = `
+.stack 5
+        LOADI 0 0
+        LOADI 1 1
+        LOADK 2 0
+        LOADI 3 1
+        FORPREP 1 done
+loop:   ADD 0 0 4
+        MMBIN 0 4 6
+        FORLOOP 1 loop
+done:   RETURN1 0
+.const 100000
+`
+
+func TestUnlimited(t *testing.T) {
+	L, cl := load(t, sumLoop, nil)
+	results, err := L.PCall(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0] != int64(5000050000) || L.Budget().Executed() != 0 {
+		t.Errorf("got %v, executed %d", results, L.Budget().Executed())
+	}
+	L.SetLimits(quota.Limits{Instructions: 1000000})
+	if _, err := L.PCall(cl); err != nil {
+		t.Fatal(err)
+	}
+	if n := L.Budget().Executed(); n != 5+2*100000+1 {
+		t.Errorf("executed %d instructions", n)
+	}
+}
+
+// BenchmarkExecute 比较不限制资源和限制资源时执行循环的速度
+func BenchmarkExecute(b *testing.B) {
+	for _, bm := range []struct {
+		name   string
+		limits quota.Limits
+	}{
+		{"Unlimited", quota.Limits{}},
+		{"Limited", quota.Limits{Instructions: math.MaxInt64, CallDepth: 1000, Memory: 1 << 30, StringLen: 1 << 20}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			L, cl := load(b, sumLoop, nil)
+			for i := 0; i < b.N; i++ {
+				L.SetLimits(bm.limits)
+				if _, err := L.PCall(cl); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestPeephole 用优化之后的函数原型重新运行测试，检查输出和错误信息与优化之前相同
+func TestPeephole(t *testing.T) {
+	optimized = true
+	defer func() { optimized = false }()
+	for _, test := range []struct {
+		name string
+		fn   func(*testing.T)
+	}{
+		{"Execute", TestExecute},
+		{"RuntimeErrors", TestRuntimeErrors},
+		{"ProtectedCall", TestProtectedCall},
+		{"GoFunction", TestGoFunction},
+		{"Hooks", TestHooks},
+		{"DebugSetHook", TestDebugSetHook},
+		{"DebugLibrary", TestDebugLibrary},
+		{"CallHookFrame", TestCallHookFrame},
+		{"Traceback", TestTraceback},
+		{"Limits", TestLimits},
+		{"Unlimited", TestUnlimited},
+	} {
+		t.Run(test.name, test.fn)
+	}
+}
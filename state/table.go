@@ -0,0 +1,206 @@
+package state
+
+import (
+	"errors"
+	"math"
+)
+
+// Table 是Lua的表，分为数组部分和哈希部分。
+// 数组部分保存键为1到len(arr)的值，最后一个元素不为nil，len(arr)总是一个边界；
+// 哈希部分按照插入的顺序保存其他的键，删除的键留下空位，
+// 遍历期间给已有的键赋值（包括赋值为nil）不影响遍历的顺序
+type Table struct {
+	arr   []Value
+	nodes []node        // 哈希部分，值为nil的节点是已经删除的键
+	index map[Value]int // 键在nodes中的位置
+	free  int           // nodes中值为nil的节点数
+}
+
+type node struct {
+	key, value Value
+}
+
+// errNilIndex 和 errNaNIndex 是不能作为键的值
+var (
+	errNilIndex = errors.New("index is nil")
+	errNaNIndex = errors.New("index is NaN")
+)
+
+// NewTable 创建一个空表，narr和nhash是数组部分和哈希部分预先分配的大小
+func NewTable(narr, nhash int) *Table {
+	t := &Table{}
+	if narr > 0 {
+		t.arr = make([]Value, 0, narr)
+	}
+	if nhash > 0 {
+		t.nodes = make([]node, 0, nhash)
+		t.index = make(map[Value]int, nhash)
+	}
+	return t
+}
+
+// normKey 把有精确整数表示的浮点数键转换为整数
+func normKey(key Value) Value {
+	if f, ok := key.(float64); ok {
+		if i, ok := floatToInteger(f); ok {
+			return i
+		}
+	}
+	return key
+}
+
+// Get 返回键key对应的值，不调用元方法
+func (t *Table) Get(key Value) Value {
+	key = normKey(key)
+	if i, ok := key.(int64); ok && i >= 1 && i <= int64(len(t.arr)) {
+		return t.arr[i-1]
+	}
+	if pos, ok := t.index[key]; ok {
+		return t.nodes[pos].value
+	}
+	return nil
+}
+
+// Set 给键key赋值，不调用元方法。key为nil或NaN时返回错误
+func (t *Table) Set(key, value Value) error {
+	_, _, err := t.set(key, value)
+	return err
+}
+
+// set 给键key赋值，返回数组部分和哈希部分增加的容量，用于统计分配的内存
+func (t *Table) set(key, value Value) (narr, nhash int, err error) {
+	arr, nodes := cap(t.arr), cap(t.nodes)
+	err = t.assign(key, value)
+	return cap(t.arr) - arr, cap(t.nodes) - nodes, err
+}
+
+func (t *Table) assign(key, value Value) error {
+	switch k := key.(type) {
+	case nil:
+		return errNilIndex
+	case float64:
+		if math.IsNaN(k) {
+			return errNaNIndex
+		}
+	}
+	key = normKey(key)
+	if i, ok := key.(int64); ok && i >= 1 && i <= int64(len(t.arr))+1 {
+		t.setInt(i, value)
+		return nil
+	}
+	if pos, ok := t.index[key]; ok {
+		if t.nodes[pos].value == nil && value != nil {
+			t.free--
+		} else if t.nodes[pos].value != nil && value == nil {
+			t.free++
+		}
+		t.nodes[pos].value = value
+		return nil
+	}
+	if value == nil {
+		return nil
+	}
+	if t.free > 0 && t.free >= len(t.nodes)/2 {
+		t.compact()
+	}
+	if t.index == nil {
+		t.index = make(map[Value]int)
+	}
+	t.index[key] = len(t.nodes)
+	t.nodes = append(t.nodes, node{key, value})
+	return nil
+}
+
+// setInt 给数组部分中或紧跟在数组部分之后的整数键i赋值
+func (t *Table) setInt(i int64, value Value) {
+	n := int64(len(t.arr))
+	switch {
+	case i <= n && (value != nil || i < n):
+		t.arr[i-1] = value
+	case i <= n:
+		// 删除最后一个元素，去掉末尾的nil，使len(arr)仍然是边界
+		t.arr = t.arr[:n-1]
+		for len(t.arr) > 0 && t.arr[len(t.arr)-1] == nil {
+			t.arr = t.arr[:len(t.arr)-1]
+		}
+	case value != nil:
+		t.arr = append(t.arr, value)
+		t.removeNode(i)
+		// 把哈希部分中紧接着的整数键移到数组部分
+		for next := i + 1; ; next++ {
+			v, ok := t.removeNode(next)
+			if !ok {
+				break
+			}
+			t.arr = append(t.arr, v)
+		}
+	default:
+		t.removeNode(i)
+	}
+}
+
+// removeNode 从哈希部分删除键key，返回它原来的值
+func (t *Table) removeNode(key Value) (Value, bool) {
+	pos, ok := t.index[key]
+	if !ok || t.nodes[pos].value == nil {
+		return nil, false
+	}
+	v := t.nodes[pos].value
+	t.nodes[pos].value = nil
+	t.free++
+	return v, true
+}
+
+// compact 去掉哈希部分中已经删除的键
+func (t *Table) compact() {
+	nodes := t.nodes[:0]
+	for _, n := range t.nodes {
+		if n.value == nil {
+			delete(t.index, n.key)
+			continue
+		}
+		t.index[n.key] = len(nodes)
+		nodes = append(nodes, n)
+	}
+	for i := len(nodes); i < len(t.nodes); i++ {
+		t.nodes[i] = node{}
+	}
+	t.nodes = nodes
+	t.free = 0
+}
+
+// Len 返回表的一个边界，即#运算符的结果
+func (t *Table) Len() int64 {
+	return int64(len(t.arr))
+}
+
+// Next 返回遍历时key之后的键和值，key为nil时返回第一个键，遍历结束时返回的键为nil。
+// key不在表中时返回false
+func (t *Table) Next(key Value) (Value, Value, bool) {
+	key = normKey(key)
+	pos := 0 // 下一个要检查的位置，数组部分在前，哈希部分在后
+	if key != nil {
+		i, isInt := key.(int64)
+		if isInt && i >= 1 && i <= int64(len(t.arr)) {
+			pos = int(i)
+		} else if p, ok := t.index[key]; ok {
+			pos = len(t.arr) + p + 1
+		} else if isInt && i > int64(len(t.arr)) && i <= int64(cap(t.arr)) {
+			// 遍历期间删除了数组末尾的元素，数组部分已经缩短，从哈希部分继续
+			pos = len(t.arr)
+		} else {
+			return nil, nil, false
+		}
+	}
+	for ; pos < len(t.arr); pos++ {
+		if t.arr[pos] != nil {
+			return int64(pos + 1), t.arr[pos], true
+		}
+	}
+	for p := pos - len(t.arr); p < len(t.nodes); p++ {
+		if n := t.nodes[p]; n.value != nil {
+			return n.key, n.value, true
+		}
+	}
+	return nil, nil, true
+}
@@ -0,0 +1,170 @@
+package state
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/depressi0n/myLua/number"
+)
+
+// Value 是Lua值，Go中的类型与Lua类型的对应关系：
+//
+//	nil                    nil
+//	bool                   boolean
+//	int64，float64          number（整数和浮点数）
+//	string                 string
+//	*Table                 table
+//	*Closure，*GoFunction   function
+//	*Upvalue               userdata（debug.upvalueid返回的轻量用户数据）
+type Value interface{}
+
+// TypeName 返回值v的Lua类型名，与type函数的结果相同
+func TypeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case int64, float64:
+		return "number"
+	case string:
+		return "string"
+	case *Table:
+		return "table"
+	case *Closure, *GoFunction:
+		return "function"
+	default:
+		return "userdata"
+	}
+}
+
+// truthy 判断值在条件表达式中是否为真，只有nil和false为假
+func truthy(v Value) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	}
+	return true
+}
+
+// ToString 按照tostring的规则把值转换为字符串
+func ToString(v Value) string {
+	switch x := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(x)
+	case int64, float64, string:
+		s, _ := toStringNumber(v)
+		return s
+	case *GoFunction:
+		return fmt.Sprintf("function: builtin: %p", x)
+	default:
+		return fmt.Sprintf("%s: %p", TypeName(v), v)
+	}
+}
+
+// toStringNumber 把字符串和数字转换为字符串，其他值返回false，对应luaO_tostring
+func toStringNumber(v Value) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case int64:
+		return strconv.FormatInt(x, 10), true
+	case float64:
+		return formatFloat(x), true
+	}
+	return "", false
+}
+
+// formatFloat 按照LUAI_NUMFFORMAT（"%.14g"）格式化浮点数，看起来像整数时加上".0"
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		if math.Signbit(f) {
+			return "-nan"
+		}
+		return "nan"
+	}
+	s := strconv.FormatFloat(f, 'g', 14, 64)
+	if !strings.ContainsAny(s, ".en") {
+		s += ".0"
+	}
+	return s
+}
+
+// toNumber 把数字和可以转换为数字的字符串转换为int64或float64
+func toNumber(v Value) (Value, bool) {
+	switch x := v.(type) {
+	case int64, float64:
+		return x, true
+	case string:
+		return number.ParseNumber(x)
+	}
+	return nil, false
+}
+
+// toFloat 把数字和可以转换为数字的字符串转换为浮点数
+func toFloat(v Value) (float64, bool) {
+	n, ok := toNumber(v)
+	switch x := n.(type) {
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, ok
+}
+
+// toInteger 把值转换为整数，浮点数必须有精确的整数表示，对应luaV_tointeger
+func toInteger(v Value) (int64, bool) {
+	n, _ := toNumber(v)
+	switch x := n.(type) {
+	case int64:
+		return x, true
+	case float64:
+		return floatToInteger(x)
+	}
+	return 0, false
+}
+
+// floatToInteger 把有精确整数表示的浮点数转换为整数
+func floatToInteger(f float64) (int64, bool) {
+	if f >= -(1<<63) && f < 1<<63 && math.Floor(f) == f {
+		return int64(f), true
+	}
+	return 0, false
+}
+
+// rawEqual 比较两个值是否相等，不调用元方法，整数与浮点数按照数学上的值比较
+func rawEqual(a, b Value) bool {
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return x == y
+		case float64:
+			i, ok := floatToInteger(y)
+			return ok && i == x
+		}
+		return false
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			i, ok := floatToInteger(x)
+			return ok && i == y
+		case float64:
+			return x == y
+		}
+		return false
+	}
+	return a == b
+}
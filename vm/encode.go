@@ -0,0 +1,120 @@
+package vm
+
+import "fmt"
+
+// 指令编码 Lua 5.4
+// 与解码方法 IABC、IABx、IAsBx、IAx、IsJx 对应，操作数超出编码范围时返回错误
+
+// OperandError 表示操作数超出了指令编码的范围
+type OperandError struct {
+	Op       string // 操作码名称，Int2sC 的错误为空
+	Operand  string // 操作数名称："a"、"b"、"c"、"k"、"bx"、"sbx"、"ax"、"sj"或"sc"
+	Value    int
+	Min, Max int
+}
+
+func (e *OperandError) Error() string {
+	msg := fmt.Sprintf("operand %s %d out of range [%d, %d]", e.Operand, e.Value, e.Min, e.Max)
+	if e.Op == "" {
+		return msg
+	}
+	return e.Op + ": " + msg
+}
+
+// checkOpcode 检查操作码是否存在并且使用mode编码模式
+func checkOpcode(op int, mode byte) error {
+	if op < 0 || op >= NUM_OPCODES {
+		return fmt.Errorf("invalid opcode %d", op)
+	}
+	if opcodes[op].opMode != mode {
+		return fmt.Errorf("%s: wrong encoding mode", opcodes[op].name)
+	}
+	return nil
+}
+
+// operand 是一个操作数及其取值范围
+type operand struct {
+	name     string
+	value    int
+	min, max int
+}
+
+// checkOperands 依次检查操作数的取值范围
+func checkOperands(op int, operands ...operand) error {
+	for _, o := range operands {
+		if o.value < o.min || o.value > o.max {
+			return &OperandError{Op: opcodes[op].name, Operand: o.name, Value: o.value, Min: o.min, Max: o.max}
+		}
+	}
+	return nil
+}
+
+// CreateABCk 创建iABC模式的指令，b和c是编码后的值，有符号的sB和sC先用 Int2sC 编码
+func CreateABCk(op, a, b, c, k int) (Instruction, error) {
+	if err := checkOpcode(op, iABC); err != nil {
+		return 0, err
+	}
+	if err := checkOperands(op, operand{"a", a, 0, MAXARG_A}, operand{"b", b, 0, MAXARG_B},
+		operand{"c", c, 0, MAXARG_C}, operand{"k", k, 0, 1}); err != nil {
+		return 0, err
+	}
+	return Instruction(op | a<<POS_A | k<<POS_k | b<<POS_B | c<<POS_C), nil
+}
+
+// CreateABx 创建iABx模式的指令
+func CreateABx(op, a, bx int) (Instruction, error) {
+	if err := checkOpcode(op, iABx); err != nil {
+		return 0, err
+	}
+	if err := checkOperands(op, operand{"a", a, 0, MAXARG_A}, operand{"bx", bx, 0, MAXARG_Bx}); err != nil {
+		return 0, err
+	}
+	return Instruction(op | a<<POS_A | bx<<POS_Bx), nil
+}
+
+// CreateAsBx 创建iAsBx模式的指令，sbx以excess-K编码，K为 OFFSET_sBx
+func CreateAsBx(op, a, sbx int) (Instruction, error) {
+	if err := checkOpcode(op, iAsBx); err != nil {
+		return 0, err
+	}
+	if err := checkOperands(op, operand{"a", a, 0, MAXARG_A},
+		operand{"sbx", sbx, -OFFSET_sBx, MAXARG_Bx - OFFSET_sBx}); err != nil {
+		return 0, err
+	}
+	return Instruction(op | a<<POS_A | (sbx+OFFSET_sBx)<<POS_Bx), nil
+}
+
+// CreateAx 创建iAx模式的指令
+func CreateAx(op, ax int) (Instruction, error) {
+	if err := checkOpcode(op, iAx); err != nil {
+		return 0, err
+	}
+	if err := checkOperands(op, operand{"ax", ax, 0, MAXARG_Ax}); err != nil {
+		return 0, err
+	}
+	return Instruction(op | ax<<POS_Ax), nil
+}
+
+// CreatesJ 创建isJ模式的指令，sj以excess-K编码，K为 OFFSET_sJ
+func CreatesJ(op, sj int) (Instruction, error) {
+	if err := checkOpcode(op, isJ); err != nil {
+		return 0, err
+	}
+	if err := checkOperands(op, operand{"sj", sj, -OFFSET_sJ, MAXARG_sJ - OFFSET_sJ}); err != nil {
+		return 0, err
+	}
+	return Instruction(op | (sj+OFFSET_sJ)<<POS_sJ), nil
+}
+
+// Int2sC 把有符号整数编码为sB或sC操作数，K为 OFFSET_sC
+func Int2sC(x int) (int, error) {
+	if x < -OFFSET_sC || x > MAXARG_C-OFFSET_sC {
+		return 0, &OperandError{Operand: "sc", Value: x, Min: -OFFSET_sC, Max: MAXARG_C - OFFSET_sC}
+	}
+	return x + OFFSET_sC, nil
+}
+
+// SC2Int 把sB或sC操作数解码为有符号整数
+func SC2Int(x int) int {
+	return x - OFFSET_sC
+}
@@ -0,0 +1,87 @@
+package vm
+
+import (
+	"errors"
+	"testing"
+	"testing/quick"
+)
+
+// 把随机数映射到[min, max]
+func inRange(x uint32, min, max int) int {
+	return min + int(x%uint32(max-min+1))
+}
+
+func TestCreateRoundTrip(t *testing.T) {
+	abc := func(a, b, c, k uint32) bool {
+		a1, b1, c1, k1 := inRange(a, 0, MAXARG_A), inRange(b, 0, MAXARG_B), inRange(c, 0, MAXARG_C), inRange(k, 0, 1)
+		i, err := CreateABCk(OP_GETTABLE, a1, b1, c1, k1)
+		a2, k2, b2, c2 := i.IABC()
+		return err == nil && i.Opcode() == OP_GETTABLE && a2 == a1 && b2 == b1 && c2 == c1 && k2 == k1
+	}
+	abx := func(a, bx uint32) bool {
+		a1, bx1 := inRange(a, 0, MAXARG_A), inRange(bx, 0, MAXARG_Bx)
+		i, err := CreateABx(OP_LOADK, a1, bx1)
+		a2, bx2 := i.IABx()
+		return err == nil && i.Opcode() == OP_LOADK && a2 == a1 && bx2 == bx1
+	}
+	asbx := func(a, sbx uint32) bool {
+		a1, sbx1 := inRange(a, 0, MAXARG_A), inRange(sbx, -OFFSET_sBx, MAXARG_Bx-OFFSET_sBx)
+		i, err := CreateAsBx(OP_LOADI, a1, sbx1)
+		a2, sbx2 := i.IAsBx()
+		return err == nil && i.Opcode() == OP_LOADI && a2 == a1 && sbx2 == sbx1
+	}
+	ax := func(ax uint32) bool {
+		ax1 := inRange(ax, 0, MAXARG_Ax)
+		i, err := CreateAx(OP_EXTRAARG, ax1)
+		return err == nil && i.Opcode() == OP_EXTRAARG && i.IAx() == ax1
+	}
+	sj := func(sj uint32) bool {
+		sj1 := inRange(sj, -OFFSET_sJ, MAXARG_sJ-OFFSET_sJ)
+		i, err := CreatesJ(OP_JMP, sj1)
+		return err == nil && i.Opcode() == OP_JMP && i.IsJx() == sj1
+	}
+	sc := func(x uint32) bool {
+		x1 := inRange(x, -OFFSET_sC, MAXARG_C-OFFSET_sC)
+		c, err := Int2sC(x1)
+		return err == nil && c >= 0 && c <= MAXARG_C && SC2Int(c) == x1
+	}
+	for name, f := range map[string]interface{}{"ABCk": abc, "ABx": abx, "AsBx": asbx, "Ax": ax, "sJ": sj, "sC": sc} {
+		if err := quick.Check(f, nil); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestCreateErrors(t *testing.T) {
+	tests := []struct {
+		create  func() (Instruction, error)
+		operand string // 为空表示不是 OperandError
+	}{
+		{func() (Instruction, error) { return CreateABCk(OP_MOVE, MAXARG_A+1, 0, 0, 0) }, "a"},
+		{func() (Instruction, error) { return CreateABCk(OP_MOVE, 0, -1, 0, 0) }, "b"},
+		{func() (Instruction, error) { return CreateABCk(OP_MOVE, 0, 0, MAXARG_C+1, 0) }, "c"},
+		{func() (Instruction, error) { return CreateABCk(OP_MOVE, 0, 0, 0, 2) }, "k"},
+		{func() (Instruction, error) { return CreateABx(OP_LOADK, 0, MAXARG_Bx+1) }, "bx"},
+		{func() (Instruction, error) { return CreateAsBx(OP_LOADI, 0, -OFFSET_sBx-1) }, "sbx"},
+		{func() (Instruction, error) { return CreateAsBx(OP_LOADI, 0, MAXARG_Bx-OFFSET_sBx+1) }, "sbx"},
+		{func() (Instruction, error) { return CreateAx(OP_EXTRAARG, MAXARG_Ax+1) }, "ax"},
+		{func() (Instruction, error) { return CreatesJ(OP_JMP, -OFFSET_sJ-1) }, "sj"},
+		{func() (Instruction, error) { return CreatesJ(OP_JMP, MAXARG_sJ-OFFSET_sJ+1) }, "sj"},
+		{func() (Instruction, error) { x, err := Int2sC(MAXARG_C - OFFSET_sC + 1); return Instruction(x), err }, "sc"},
+		{func() (Instruction, error) { return CreateABx(OP_MOVE, 0, 0) }, ""},
+		{func() (Instruction, error) { return CreatesJ(OP_LOADI, 0) }, ""},
+		{func() (Instruction, error) { return CreateAx(NUM_OPCODES, 0) }, ""},
+		{func() (Instruction, error) { return CreateABCk(-1, 0, 0, 0, 0) }, ""},
+	}
+	for n, test := range tests {
+		_, err := test.create()
+		if err == nil {
+			t.Errorf("%d: no error", n)
+			continue
+		}
+		var oe *OperandError
+		if errors.As(err, &oe) != (test.operand != "") || oe != nil && oe.Operand != test.operand {
+			t.Errorf("%d: got %v, want operand %q", n, err, test.operand)
+		}
+	}
+}
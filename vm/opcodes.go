@@ -10,6 +10,15 @@ const (
 	isJ
 )
 
+// 指令的编码模式，与 Instruction.OpMode 的返回值对应
+const (
+	OpModeABC  = iABC
+	OpModeABx  = iABx
+	OpModeAsBx = iAsBx
+	OpModeAx   = iAx
+	OpModesJ   = isJ
+)
+
 // /*===========================================================================
 //  We assume that instructions are unsigned 32-bit integers.
 //  All instructions have an opcode in the first 7 bits.
@@ -266,6 +275,16 @@ var opcodes = []opcode{
 	{setMMFlag: 0, setOTFlag: 0, setITFlag: 0, testFlag: 0, setAFlag: 0, opMode: iAx, name: "EXTRAARG"},    /* OP_EXTRAARG */
 }
 
+// OpcodeByName 根据操作码名称（如"MOVE"）查找操作码
+func OpcodeByName(name string) (int, bool) {
+	for op := range opcodes {
+		if opcodes[op].name == name {
+			return op, true
+		}
+	}
+	return 0, false
+}
+
 type Instruction uint32
 
 // 指令解码 Lua 5.4
@@ -341,7 +360,38 @@ func (i Instruction) IAx() int {
    └-----------------------┘└-----┘*/
 
 func (i Instruction) IsJx() int {
-	return int(i >> POS_sJ)
+	return int(i>>POS_sJ) - OFFSET_sJ
+}
+
+// String 按照luac -l的格式返回指令的操作码名称和操作数，有符号的sB和sC解码为整数，
+// iABC模式下k标志为1时在C之后加上"k"
+func (i Instruction) String() string {
+	if i.Opcode() >= NUM_OPCODES {
+		return fmt.Sprintf("OP_%d", i.Opcode())
+	}
+	switch i.OpMode() {
+	case iABC:
+		a, k, b, c := i.IABC()
+		if info := i.Info(); info.SignedB {
+			b = SC2Int(b)
+		} else if info.SignedC {
+			c = SC2Int(c)
+		}
+		if k != 0 {
+			return fmt.Sprintf("%s %d %d %dk", i.OpName(), a, b, c)
+		}
+		return fmt.Sprintf("%s %d %d %d", i.OpName(), a, b, c)
+	case iABx:
+		a, bx := i.IABx()
+		return fmt.Sprintf("%s %d %d", i.OpName(), a, bx)
+	case iAsBx:
+		a, sbx := i.IAsBx()
+		return fmt.Sprintf("%s %d %d", i.OpName(), a, sbx)
+	case iAx:
+		return fmt.Sprintf("%s %d", i.OpName(), i.IAx())
+	default:
+		return fmt.Sprintf("%s %d", i.OpName(), i.IsJx())
+	}
 }
 
 // PrintOprands provide debug information for testing
@@ -363,7 +413,7 @@ func PrintOprands(i Instruction) {
 		fmt.Printf("%d", a)
 		fmt.Printf(" %d", bx)
 	case iAsBx:
-		a, sbx := i.IABx()
+		a, sbx := i.IAsBx()
 		fmt.Printf("%d", a)
 		fmt.Printf(" %d", sbx)
 	case iAx:
@@ -1 +1,28 @@
 package vm
+
+import "testing"
+
+func TestInstructionString(t *testing.T) {
+	tests := []struct {
+		i    Instruction
+		want string
+	}{
+		{Instruction(OP_MOVE | 1<<POS_A | 2<<POS_B), "MOVE 1 2 0"},
+		{Instruction(OP_SETFIELD | 1<<POS_k | 3<<POS_C), "SETFIELD 0 0 3k"},
+		{Instruction(OP_LOADK | 4<<POS_A | 70000<<POS_Bx), "LOADK 4 70000"},
+		{Instruction(OP_LOADI | (OFFSET_sBx-5)<<POS_Bx), "LOADI 0 -5"},
+		{Instruction(OP_JMP | (OFFSET_sJ+12)<<POS_sJ), "JMP 12"},
+		{Instruction(OP_JMP | (OFFSET_sJ-3)<<POS_sJ), "JMP -3"},
+		{Instruction(OP_EXTRAARG | 9<<POS_Ax), "EXTRAARG 9"},
+		{Instruction(OP_ADDI | 1<<POS_A | 1<<POS_B | (OFFSET_sC+1)<<POS_C), "ADDI 1 1 1"},
+		{Instruction(OP_SHRI | (OFFSET_sC-3)<<POS_C), "SHRI 0 0 -3"},
+		{Instruction(OP_GEI | 2<<POS_A | (OFFSET_sC-5)<<POS_B | 1<<POS_k), "GEI 2 -5 0k"},
+		{Instruction(OP_MMBINI | (OFFSET_sC+1)<<POS_B | 6<<POS_C), "MMBINI 0 1 6"},
+		{Instruction(NUM_OPCODES), "OP_83"},
+	}
+	for _, test := range tests {
+		if got := test.i.String(); got != test.want {
+			t.Errorf("%#08x: got %q, want %q", uint32(test.i), got, test.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package vm
+
+// OpInfo 描述一个操作码的属性，对应Lua5.4 lopcodes.c中luaP_opmodes的各个标志位，
+// 供 vm 之外的分析工具判断控制流和寄存器的读写
+type OpInfo struct {
+	Name         string
+	Mode         byte // 编码模式，与 Instruction.OpMode 的返回值相同
+	SetsA        bool // 指令修改寄存器A
+	IsTest       bool // 测试指令，条件不成立时跳过下一条指令，下一条指令必须是JMP
+	UsesTop      bool // B为0时使用上一条指令设置的栈顶，见 Instruction.UsesTop
+	SetsTop      bool // C为0时为下一条指令设置栈顶，见 Instruction.SetsTop
+	IsMetamethod bool // 调用元方法的指令（MMBIN、MMBINI和MMBINK）
+	IsJump       bool // 跳转指令，目标由 Instruction.JumpTarget 计算
+	SignedB      bool // B是有符号的sB，用 SC2Int 解码
+	SignedC      bool // C是有符号的sC，用 SC2Int 解码
+}
+
+// OpcodeInfo 返回操作码的属性，op不是合法的操作码时返回false
+func OpcodeInfo(op int) (OpInfo, bool) {
+	if op < 0 || op >= NUM_OPCODES {
+		return OpInfo{}, false
+	}
+	o := opcodes[op]
+	info := OpInfo{
+		Name:         o.name,
+		Mode:         o.opMode,
+		SetsA:        o.setAFlag != 0,
+		IsTest:       o.testFlag != 0,
+		UsesTop:      o.setITFlag != 0,
+		SetsTop:      o.setOTFlag != 0,
+		IsMetamethod: o.setMMFlag != 0,
+	}
+	switch op {
+	case OP_JMP, OP_FORPREP, OP_FORLOOP, OP_TFORPREP, OP_TFORLOOP:
+		info.IsJump = true
+	case OP_ADDI, OP_SHRI, OP_SHLI:
+		info.SignedC = true
+	case OP_EQI, OP_LTI, OP_LEI, OP_GTI, OP_GEI, OP_MMBINI:
+		info.SignedB = true
+	}
+	return info, true
+}
+
+// Info 返回指令操作码的属性，操作码不合法时返回零值
+func (i Instruction) Info() OpInfo {
+	info, _ := OpcodeInfo(i.Opcode())
+	return info
+}
+
+// UsesTop 判断指令是否使用上一条指令设置的栈顶，对应Lua5.4的isIT
+func (i Instruction) UsesTop() bool {
+	_, _, b, _ := i.IABC()
+	return i.Info().UsesTop && b == 0
+}
+
+// SetsTop 判断指令是否为下一条指令设置栈顶，对应Lua5.4的isOT，
+// TAILCALL总是设置栈顶
+func (i Instruction) SetsTop() bool {
+	_, _, _, c := i.IABC()
+	return i.Info().SetsTop && c == 0 || i.Opcode() == OP_TAILCALL
+}
+
+// JumpTarget 返回位于pc的跳转指令的目标位置（从0开始计数），不是跳转指令时返回false
+//
+// FORPREP的目标是循环结束之后的指令，FORLOOP和TFORLOOP的目标是循环体的第一条指令，
+// TFORPREP的目标是TFORCALL。JMP和TFORPREP总是跳转，其他跳转指令也可能继续执行pc+1
+func (i Instruction) JumpTarget(pc int) (int, bool) {
+	switch i.Opcode() {
+	case OP_JMP:
+		return pc + 1 + i.IsJx(), true
+	case OP_FORLOOP, OP_TFORLOOP:
+		_, bx := i.IABx()
+		return pc + 1 - bx, true
+	case OP_FORPREP:
+		_, bx := i.IABx()
+		return pc + bx + 2, true
+	case OP_TFORPREP:
+		_, bx := i.IABx()
+		return pc + 1 + bx, true
+	}
+	return 0, false
+}
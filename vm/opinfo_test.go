@@ -0,0 +1,78 @@
+package vm
+
+import "testing"
+
+func TestOpcodeInfo(t *testing.T) {
+	for op := 0; op < NUM_OPCODES; op++ {
+		info, ok := OpcodeInfo(op)
+		i := Instruction(op)
+		if !ok || info.Name != i.OpName() || info.Mode != i.OpMode() || info.SetsA != (i.testAMode() != 0) ||
+			info.IsTest != (i.testTMode() != 0) || info.UsesTop != (i.testITMode() != 0) ||
+			info.SetsTop != (i.testOTMode() != 0) {
+			t.Errorf("OpcodeInfo(%d) = %+v, %v", op, info, ok)
+		}
+	}
+	if _, ok := OpcodeInfo(NUM_OPCODES); ok {
+		t.Errorf("OpcodeInfo(NUM_OPCODES) succeeded")
+	}
+	if info := Instruction(OP_MMBINK).Info(); !info.IsMetamethod || info.IsJump {
+		t.Errorf("MMBINK: %+v", info)
+	}
+	if info := Instruction(OP_EQK).Info(); !info.IsTest || info.SetsA {
+		t.Errorf("EQK: %+v", info)
+	}
+	if info := Instruction(OP_SHLI).Info(); info.SignedB || !info.SignedC {
+		t.Errorf("SHLI: %+v", info)
+	}
+	if info := Instruction(OP_LTI).Info(); !info.SignedB || info.SignedC {
+		t.Errorf("LTI: %+v", info)
+	}
+}
+
+func TestUsesSetsTop(t *testing.T) {
+	tests := []struct {
+		i                Instruction
+		usesTop, setsTop bool
+	}{
+		{Instruction(OP_CALL | 1<<POS_A), true, true},
+		{Instruction(OP_CALL | 1<<POS_A | 2<<POS_B | 1<<POS_C), false, false},
+		{Instruction(OP_TAILCALL | 1<<POS_A | 2<<POS_B | 1<<POS_C), false, true},
+		{Instruction(OP_VARARG | 1<<POS_A), false, true},
+		{Instruction(OP_RETURN | 1<<POS_A), true, false},
+		{Instruction(OP_MOVE), false, false},
+	}
+	for _, test := range tests {
+		if got := test.i.UsesTop(); got != test.usesTop {
+			t.Errorf("%v: UsesTop() = %v", test.i, got)
+		}
+		if got := test.i.SetsTop(); got != test.setsTop {
+			t.Errorf("%v: SetsTop() = %v", test.i, got)
+		}
+	}
+}
+
+func TestJumpTarget(t *testing.T) {
+	tests := []struct {
+		i      Instruction
+		target int
+		ok     bool
+	}{
+		{Instruction(OP_JMP | (OFFSET_sJ+3)<<POS_sJ), 14, true},
+		{Instruction(OP_JMP | (OFFSET_sJ-11)<<POS_sJ), 0, true},
+		{Instruction(OP_FORPREP | 4<<POS_Bx), 16, true},
+		{Instruction(OP_FORLOOP | 5<<POS_Bx), 6, true},
+		{Instruction(OP_TFORPREP | 2<<POS_Bx), 13, true},
+		{Instruction(OP_TFORLOOP | 3<<POS_Bx), 8, true},
+		{Instruction(OP_TEST), 0, false},
+		{Instruction(OP_LFALSESKIP), 0, false},
+	}
+	for _, test := range tests {
+		if !test.i.Info().IsJump && test.ok {
+			t.Errorf("%v: IsJump = false", test.i)
+		}
+		target, ok := test.i.JumpTarget(10)
+		if target != test.target || ok != test.ok {
+			t.Errorf("%v: JumpTarget(10) = %d, %v, want %d, %v", test.i, target, ok, test.target, test.ok)
+		}
+	}
+}